@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long the compress/decompress handlers wait for small archives before
+// handing back a job to poll, and how long the job keeps polling Wings
+const (
+	archiveInlineWait  = 10 * time.Second
+	archivePollEvery   = 5 * time.Second
+	archivePollTimeout = 2 * time.Hour
+)
+
+// archiveStillRunning reports whether an archive call failed only because the
+// panel or a proxy in front of it gave up waiting for Wings
+func archiveStillRunning(err error) bool {
+	switch pteroStatus(err) {
+	case http.StatusBadGateway, http.StatusGatewayTimeout, 524:
+		return true
+	case 0:
+		return strings.Contains(err.Error(), "request failed")
+	}
+	return false
+}
+
+// CompressFiles archives files in root on the server and returns the new archive
+func (p *PteroClient) CompressFiles(serverID, root string, files []string) (*FileObject, error) {
+	data, err := p.Request("POST", "/api/client/servers/"+serverID+"/files/compress", map[string]interface{}{
+		"root":  root,
+		"files": files,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Attributes FileObject `json:"attributes"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result.Attributes.Name == "" {
+		return nil, fmt.Errorf("failed to parse compress response")
+	}
+	return &result.Attributes, nil
+}
+
+// DecompressFile extracts an archive into root on the server
+func (p *PteroClient) DecompressFile(serverID, root, file string) error {
+	_, err := p.Request("POST", "/api/client/servers/"+serverID+"/files/decompress", map[string]string{
+		"root": root,
+		"file": file,
+	})
+	return err
+}
+
+// waitForNewArchive polls root until an archive that wasn't there before
+// appears and its size stops changing
+func waitForNewArchive(job *Job, client *PteroClient, serverID, root string, before map[string]bool, started time.Time) (*FileObject, error) {
+	deadline := time.Now().Add(archivePollTimeout)
+	var lastSize int64 = -1
+	for time.Now().Before(deadline) {
+		time.Sleep(archivePollEvery)
+
+		entries, err := client.ListFiles(serverID, root)
+		if err != nil {
+			continue
+		}
+
+		var candidate *FileObject
+		for i := range entries {
+			e := &entries[i]
+			if !e.IsFile || before[e.Name] || !isArchiveName(e.Name) || e.ModifiedAt.Before(started.Add(-time.Minute)) {
+				continue
+			}
+			if candidate == nil || e.ModifiedAt.After(candidate.ModifiedAt) {
+				candidate = e
+			}
+		}
+		if candidate == nil {
+			job.SetProgress(0, 0, "Waiting for Wings to create the archive")
+			continue
+		}
+
+		job.SetProgress(candidate.Size, 0, "Writing "+candidate.Name)
+		if candidate.Size > 0 && candidate.Size == lastSize {
+			return candidate, nil
+		}
+		lastSize = candidate.Size
+	}
+	return nil, fmt.Errorf("timed out waiting for the archive to finish")
+}
+
+// waitForStableDirectory polls root until its listing stops changing, which is
+// the only signal we get that a timed out decompress has finished
+func waitForStableDirectory(job *Job, client *PteroClient, serverID, root string) (int, error) {
+	deadline := time.Now().Add(archivePollTimeout)
+	last := ""
+	stable := 0
+	for time.Now().Before(deadline) {
+		time.Sleep(archivePollEvery)
+
+		entries, err := client.ListFiles(serverID, root)
+		if err != nil {
+			continue
+		}
+
+		var total int64
+		for _, e := range entries {
+			total += e.Size
+		}
+		fingerprint := fmt.Sprintf("%d:%d", len(entries), total)
+		job.SetProgress(total, 0, fmt.Sprintf("Extracting (%d entries so far)", len(entries)))
+
+		if fingerprint == last {
+			stable++
+			if stable >= 2 {
+				return len(entries), nil
+			}
+		} else {
+			stable = 0
+		}
+		last = fingerprint
+	}
+	return 0, fmt.Errorf("timed out waiting for extraction to finish")
+}
+
+func isArchiveName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".tar", ".tar.bz2", ".tar.xz", ".7z", ".rar", ".gz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// respondWithJob returns the job result directly when it finishes quickly and
+// 202 with the job otherwise
+func respondWithJob(c *gin.Context, job *Job) {
+	if job.Wait(archiveInlineWait) {
+		state := job.Snapshot()
+		if state.Status == JobFailed {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": state.Error, "job": state})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"job": state})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job": job.Snapshot()})
+}
+
+// CompressFilesHandler archives files into the given root directory
+func CompressFilesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			Root  string   `json:"root"`
+			Files []string `json:"files" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		root, err := normalizeServerPath(req.Root)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, f := range req.Files {
+			if full, err := resolveInRoot(root, f); err != nil || full == root {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Files must be inside root: " + f})
+				return
+			}
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		job := StartJob("compress", id, func(job *Job) (map[string]interface{}, error) {
+			job.SetProgress(0, 0, "Compressing")
+			before := map[string]bool{}
+			if entries, err := client.ListFiles(id, root); err == nil {
+				for _, e := range entries {
+					before[e.Name] = true
+				}
+			}
+			started := time.Now()
+
+			archive, err := client.CompressFiles(id, root, req.Files)
+			if err != nil {
+				if !archiveStillRunning(err) {
+					return nil, err
+				}
+				archive, err = waitForNewArchive(job, client, id, root, before, started)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			job.SetProgress(archive.Size, archive.Size, "Archive created")
+			return map[string]interface{}{
+				"archive": path.Join(root, archive.Name),
+				"name":    archive.Name,
+				"size":    archive.Size,
+			}, nil
+		})
+
+		respondWithJob(c, job)
+	}
+}
+
+// DecompressFileHandler extracts an archive into the given root directory
+func DecompressFileHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			Root string `json:"root"`
+			File string `json:"file" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		root, err := normalizeServerPath(req.Root)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		archivePath, err := resolveInRoot(root, req.File)
+		if err != nil || archivePath == root {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Archive must be inside root"})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		stat, err := client.StatFile(id, archivePath)
+		if err == errFileNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Archive not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		job := StartJob("decompress", id, func(job *Job) (map[string]interface{}, error) {
+			job.SetProgress(0, stat.Size, "Extracting "+stat.Name)
+			result := map[string]interface{}{
+				"archive": archivePath,
+				"root":    root,
+				"size":    stat.Size,
+			}
+
+			// Wings resolves the archive relative to root
+			rel := strings.TrimPrefix(strings.TrimPrefix(archivePath, root), "/")
+			err := client.DecompressFile(id, root, rel)
+			if err != nil {
+				if !archiveStillRunning(err) {
+					return nil, err
+				}
+				entries, err := waitForStableDirectory(job, client, id, root)
+				if err != nil {
+					return nil, err
+				}
+				result["entries"] = entries
+			}
+
+			job.SetProgress(stat.Size, stat.Size, "Extraction finished")
+			return result, nil
+		})
+
+		respondWithJob(c, job)
+	}
+}
@@ -103,3 +103,15 @@ func generateToken() string {
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
+
+// CurrentUsername returns the name of the logged in user for audit records
+func CurrentUsername(c *gin.Context, db *sql.DB) string {
+	var username string
+	if userID, ok := c.Get("user_id"); ok {
+		db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	}
+	if username == "" {
+		username = "unknown"
+	}
+	return username
+}
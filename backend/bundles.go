@@ -0,0 +1,675 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PluginBundle is a named set of plugins, and optionally config files, to
+// put on many servers at once. Version goes up on every change so servers
+// can be told apart by the bundle version they last had applied.
+type PluginBundle struct {
+	ID          int64          `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Version     int            `json:"version"`
+	Plugins     []BundlePlugin `json:"plugins"`
+	Files       []BundleFile   `json:"files"`
+	Servers     []BundleServer `json:"servers,omitempty"`
+	UpdatedAt   string         `json:"updated_at,omitempty"`
+}
+
+// BundlePlugin is a plugin in a bundle. Version is empty for the best
+// version for each server, an exact version, or a constraint such as
+// "5.4.*" or ">=2.0 <3".
+type BundlePlugin struct {
+	Source  string `json:"source" binding:"required"`
+	Slug    string `json:"slug" binding:"required"`
+	Version string `json:"version"`
+}
+
+// BundleFile is a config file a bundle writes, e.g.
+// /plugins/LuckPerms/config.yml
+type BundleFile struct {
+	Path    string `json:"path" binding:"required"`
+	Content string `json:"content"`
+}
+
+// BundleServer records which version of a bundle a server has
+type BundleServer struct {
+	ServerID  string `json:"server_id"`
+	Version   int    `json:"version"`
+	AppliedAt string `json:"applied_at"`
+}
+
+func loadBundle(db *sql.DB, id string) (*PluginBundle, error) {
+	b := &PluginBundle{Plugins: []BundlePlugin{}, Files: []BundleFile{}, Servers: []BundleServer{}}
+	err := db.QueryRow("SELECT id, name, description, version, updated_at FROM plugin_bundles WHERE id = ?", id).
+		Scan(&b.ID, &b.Name, &b.Description, &b.Version, &b.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT source, slug, version FROM plugin_bundle_plugins WHERE bundle_id = ? ORDER BY position", b.ID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var p BundlePlugin
+		rows.Scan(&p.Source, &p.Slug, &p.Version)
+		b.Plugins = append(b.Plugins, p)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT path, content FROM plugin_bundle_files WHERE bundle_id = ? ORDER BY path", b.ID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var f BundleFile
+		rows.Scan(&f.Path, &f.Content)
+		b.Files = append(b.Files, f)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT server_id, bundle_version, applied_at FROM server_bundles WHERE bundle_id = ? ORDER BY server_id", b.ID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s BundleServer
+		rows.Scan(&s.ServerID, &s.Version, &s.AppliedAt)
+		b.Servers = append(b.Servers, s)
+	}
+	rows.Close()
+	return b, nil
+}
+
+// bundleFromRequest binds and checks a bundle sent by the client
+func bundleFromRequest(c *gin.Context) (*PluginBundle, bool) {
+	var req struct {
+		Name        string         `json:"name" binding:"required"`
+		Description string         `json:"description"`
+		Plugins     []BundlePlugin `json:"plugins" binding:"dive"`
+		Files       []BundleFile   `json:"files" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	b := &PluginBundle{Name: strings.TrimSpace(req.Name), Description: req.Description}
+	seen := map[string]bool{}
+	for _, p := range req.Plugins {
+		if _, err := GetPluginSource(p.Source); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		if _, err := parseVersionConstraint(p.Version); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", p.Slug, err)})
+			return nil, false
+		}
+		key := p.Source + "/" + strings.ToLower(p.Slug)
+		if seen[key] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Plugin listed twice: " + key})
+			return nil, false
+		}
+		seen[key] = true
+		b.Plugins = append(b.Plugins, p)
+	}
+	for _, f := range req.Files {
+		p, err := normalizeServerPath(f.Path)
+		if err != nil || p == "/" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path: " + f.Path})
+			return nil, false
+		}
+		if seen["file:"+p] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File listed twice: " + p})
+			return nil, false
+		}
+		seen["file:"+p] = true
+		b.Files = append(b.Files, BundleFile{Path: p, Content: f.Content})
+	}
+	return b, true
+}
+
+// saveBundleContents replaces a bundle's plugins and files
+func saveBundleContents(tx *sql.Tx, b *PluginBundle) error {
+	if _, err := tx.Exec("DELETE FROM plugin_bundle_plugins WHERE bundle_id = ?", b.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM plugin_bundle_files WHERE bundle_id = ?", b.ID); err != nil {
+		return err
+	}
+	for i, p := range b.Plugins {
+		if _, err := tx.Exec("INSERT INTO plugin_bundle_plugins (bundle_id, position, source, slug, version) VALUES (?, ?, ?, ?, ?)",
+			b.ID, i, p.Source, p.Slug, p.Version); err != nil {
+			return err
+		}
+	}
+	for _, f := range b.Files {
+		if _, err := tx.Exec("INSERT INTO plugin_bundle_files (bundle_id, path, content) VALUES (?, ?, ?)",
+			b.ID, f.Path, f.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ListBundlesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rows, err := db.Query("SELECT id FROM plugin_bundles ORDER BY name")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		bundles := []*PluginBundle{}
+		for _, id := range ids {
+			if b, err := loadBundle(db, id); err == nil {
+				bundles = append(bundles, b)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"bundles": bundles})
+	}
+}
+
+func GetBundleHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		b, err := loadBundle(db, c.Param("bundle"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bundle not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"bundle": b})
+	}
+}
+
+func CreateBundleHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		b, ok := bundleFromRequest(c)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer tx.Rollback()
+		res, err := tx.Exec("INSERT INTO plugin_bundles (name, description) VALUES (?, ?)", b.Name, b.Description)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				c.JSON(http.StatusConflict, gin.H{"error": "A bundle named " + b.Name + " already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		b.ID, _ = res.LastInsertId()
+		if err := saveBundleContents(tx, b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		created, _ := loadBundle(db, strconv.FormatInt(b.ID, 10))
+		c.JSON(http.StatusOK, gin.H{"message": "Bundle created", "bundle": created})
+	}
+}
+
+// UpdateBundleHandler replaces a bundle's contents and bumps its version, so
+// servers with the previous version show as out of date
+func UpdateBundleHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("bundle")
+		b, ok := bundleFromRequest(c)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer tx.Rollback()
+		res, err := tx.Exec(`UPDATE plugin_bundles SET name = ?, description = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, b.Name, b.Description, id)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				c.JSON(http.StatusConflict, gin.H{"error": "A bundle named " + b.Name + " already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bundle not found"})
+			return
+		}
+		b.ID, _ = strconv.ParseInt(id, 10, 64)
+		if err := saveBundleContents(tx, b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		updated, _ := loadBundle(db, id)
+		c.JSON(http.StatusOK, gin.H{"message": "Bundle saved", "bundle": updated})
+	}
+}
+
+// DeleteBundleHandler deletes a bundle. Plugins it put on servers stay.
+func DeleteBundleHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("bundle")
+		res, err := db.Exec("DELETE FROM plugin_bundles WHERE id = ?", id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bundle not found"})
+			return
+		}
+		db.Exec("DELETE FROM plugin_bundle_plugins WHERE bundle_id = ?", id)
+		db.Exec("DELETE FROM plugin_bundle_files WHERE bundle_id = ?", id)
+		db.Exec("DELETE FROM server_bundles WHERE bundle_id = ?", id)
+		c.JSON(http.StatusOK, gin.H{"message": "Bundle deleted"})
+	}
+}
+
+var versionParts = regexp.MustCompile(`[0-9]+|[A-Za-z]+`)
+
+// compareVersions orders version strings part by part, numbers numerically.
+// A version with extra letters after an equal prefix (1.0-beta) sorts
+// before the plain one (1.0).
+func compareVersions(a, b string) int {
+	pa, pb := versionParts.FindAllString(a, -1), versionParts.FindAllString(b, -1)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		if i >= len(pa) || i >= len(pb) {
+			longer, sign := pa, 1
+			if i >= len(pa) {
+				longer, sign = pb, -1
+			}
+			if _, err := strconv.Atoi(longer[i]); err != nil {
+				return -sign
+			}
+			return sign
+		}
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			return 1
+		case errB == nil:
+			return -1
+		default:
+			if c := strings.Compare(strings.ToLower(pa[i]), strings.ToLower(pb[i])); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// versionConstraint holds the terms of a version constraint, all of which
+// must match. A single term without an operator or wildcard is an exact
+// version.
+type versionConstraint []func(version string) bool
+
+var constraintTerm = regexp.MustCompile(`^(>=|<=|!=|>|<|=)?\s*([^\s,<>=!]+)`)
+
+// parseVersionConstraint reads terms separated by commas or spaces, such as
+// ">= 2.0, < 3" or "5.4.*". An operator belongs to the version after it,
+// with or without a space between them.
+func parseVersionConstraint(s string) (versionConstraint, error) {
+	var vc versionConstraint
+	rest := strings.TrimLeft(s, " \t,")
+	for rest != "" {
+		m := constraintTerm.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("invalid version constraint %q", s)
+		}
+		rest = rest[len(m[0]):]
+		if rest != "" && !strings.ContainsRune(" \t,", rune(rest[0])) {
+			return nil, fmt.Errorf("invalid version constraint %q", s)
+		}
+		rest = strings.TrimLeft(rest, " \t,")
+
+		op, v := m[1], m[2]
+		if strings.HasSuffix(v, ".*") || strings.HasSuffix(v, ".x") {
+			if op != "" && op != "=" {
+				return nil, fmt.Errorf("wildcards cannot be combined with %s", op)
+			}
+			// 5.4.* covers 5.4 itself as well as 5.4.1
+			base := v[:len(v)-2]
+			vc = append(vc, func(version string) bool {
+				return version == base || strings.HasPrefix(version, base+".")
+			})
+			continue
+		}
+		vc = append(vc, func(version string) bool {
+			c := compareVersions(version, v)
+			switch op {
+			case ">=":
+				return c >= 0
+			case "<=":
+				return c <= 0
+			case ">":
+				return c > 0
+			case "<":
+				return c < 0
+			case "!=":
+				return c != 0
+			}
+			return c == 0
+		})
+	}
+	return vc, nil
+}
+
+func (vc versionConstraint) Matches(version string) bool {
+	for _, match := range vc {
+		if !match(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// isExactVersion reports whether a constraint names one version as-is
+func isExactVersion(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "<>=!*, ") && !strings.HasSuffix(s, ".x")
+}
+
+// bundlePluginVersion picks the version of a bundle plugin to install on
+// target: the version itself when exact, the best version meeting the
+// constraint otherwise. Empty means the best version overall. target may be
+// nil when the server's platform is unknown.
+func bundlePluginVersion(p BundlePlugin, target *ServerPlatform) (string, error) {
+	if p.Version == "" || isExactVersion(p.Version) {
+		return p.Version, nil
+	}
+	if target == nil {
+		target = &ServerPlatform{}
+	}
+	vc, err := parseVersionConstraint(p.Version)
+	if err != nil {
+		return "", err
+	}
+	candidates, err := pluginArtifacts(p.Source, p.Slug, target)
+	if err != nil {
+		return "", err
+	}
+	var matching []PluginArtifact
+	for _, a := range candidates {
+		if vc.Matches(a.Version) {
+			matching = append(matching, a)
+		}
+	}
+	if len(matching) == 0 {
+		return "", fmt.Errorf("no version matches %s", p.Version)
+	}
+	pick, err := selectArtifact(matching, "", target)
+	if err != nil {
+		return "", err
+	}
+	return pick.Version, nil
+}
+
+// BundleFilePlan says what applying a bundle does to one of its files
+type BundleFilePlan struct {
+	Path   string `json:"path"`
+	Status string `json:"status"` // create, update or unchanged
+}
+
+// BundleServerPlan is what applying a bundle would do on one server
+type BundleServerPlan struct {
+	Server         string           `json:"server"`
+	Platform       *ServerPlatform  `json:"platform,omitempty"`
+	AppliedVersion int              `json:"applied_version"` // 0 when never applied
+	Plan           *InstallPlan     `json:"plan,omitempty"`
+	Files          []BundleFilePlan `json:"files"`
+	Error          string           `json:"error,omitempty"`
+}
+
+// planBundle builds one install plan for every plugin of a bundle on a
+// server. Plugins already installed at the version the bundle wants are
+// left alone; a dependency shared by several plugins is installed once. A
+// server whose platform cannot be detected gets no plan, only an Error.
+func planBundle(db *sql.DB, client *PteroClient, serverID string, b *PluginBundle) *BundleServerPlan {
+	sp := &BundleServerPlan{Server: serverID, Files: []BundleFilePlan{}}
+	db.QueryRow("SELECT bundle_version FROM server_bundles WHERE server_id = ? AND bundle_id = ?", serverID, b.ID).Scan(&sp.AppliedVersion)
+
+	target, err := DetectServerPlatform(client, serverID, false)
+	if err != nil {
+		sp.Error = "Could not detect platform: " + err.Error()
+		return sp
+	}
+	sp.Platform = target
+
+	current := map[string]string{}
+	if rows, err := installedPluginRows(db, serverID); err == nil {
+		for _, p := range rows {
+			current[p.Source+"/"+strings.ToLower(p.Name)] = p.Version
+		}
+	}
+
+	merged := &InstallPlan{Items: []*PlanItem{}, Problems: []string{}}
+	index := map[string]int{}
+	for _, p := range b.Plugins {
+		version, err := bundlePluginVersion(p, target)
+		if err != nil {
+			merged.Problems = append(merged.Problems, fmt.Sprintf("%s: %v", p.Slug, err))
+			continue
+		}
+		plan, err := buildInstallPlan(db, client, serverID, target, p.Source, p.Slug, version, false, nil)
+		if err != nil {
+			merged.Problems = append(merged.Problems, fmt.Sprintf("%s: %v", p.Slug, err))
+			continue
+		}
+
+		root := plan.Items[len(plan.Items)-1]
+		if have, ok := current[p.Source+"/"+strings.ToLower(p.Slug)]; ok && have == root.Version {
+			root.Status = PlanInstalled
+			root.Message = "Already at " + have
+		}
+		for _, item := range plan.Items {
+			key := item.Source + "/" + strings.ToLower(item.Slug)
+			if item.Slug == "" {
+				key = "external/" + normalizePluginName(item.Name)
+			}
+			if i, ok := index[key]; ok {
+				// Keep the first plan's entry unless this one installs it
+				if merged.Items[i].Status == PlanInstall || item.Status != PlanInstall {
+					continue
+				}
+				merged.Items = append(merged.Items[:i], merged.Items[i+1:]...)
+				for k, j := range index {
+					if j > i {
+						index[k] = j - 1
+					}
+				}
+			}
+			index[key] = len(merged.Items)
+			merged.Items = append(merged.Items, item)
+		}
+		merged.Problems = append(merged.Problems, plan.Problems...)
+		merged.edges = append(merged.edges, plan.edges...)
+	}
+	sp.Plan = merged
+
+	limit := GetSettingInt(db, "editor_max_file_kb", defaultEditorMaxFileKB) << 10
+	for _, f := range b.Files {
+		status := "update"
+		existing, err := client.ReadFile(serverID, f.Path, limit)
+		switch {
+		case err == nil && bytes.Equal(existing, []byte(f.Content)):
+			status = "unchanged"
+		case err != nil && pteroStatus(err) == http.StatusNotFound:
+			status = "create"
+		}
+		sp.Files = append(sp.Files, BundleFilePlan{Path: f.Path, Status: status})
+	}
+	return sp
+}
+
+// applyBundle installs a bundle's plugins on one server, then writes its
+// files with history so they can be rolled back, and records the bundle
+// version the server now has. It returns the plugins installed and files
+// written even when it fails part way: a file that cannot be written leaves
+// the plugins and earlier files in place, and the bundle unrecorded.
+func applyBundle(db *sql.DB, client *PteroClient, sp *BundleServerPlan, b *PluginBundle, author string) ([]*InstalledPlugin, []string, error) {
+	written := []string{}
+	installed, err := installPlan(db, client, sp.Server, sp.Plan)
+	if err != nil {
+		return nil, written, err
+	}
+	for i, f := range b.Files {
+		if sp.Files[i].Status == "unchanged" {
+			continue
+		}
+		if err := writeFileWithHistory(db, client, sp.Server, f.Path, []byte(f.Content), author); err != nil {
+			return installed, written, fmt.Errorf("plugins were installed but writing %s failed: %v", f.Path, err)
+		}
+		written = append(written, f.Path)
+	}
+	_, err = db.Exec("INSERT OR REPLACE INTO server_bundles (server_id, bundle_id, bundle_version) VALUES (?, ?, ?)",
+		sp.Server, b.ID, b.Version)
+	return installed, written, err
+}
+
+type bundleApplyRequest struct {
+	Servers []string `json:"servers" binding:"required,min=1"`
+	Force   bool     `json:"force"`
+}
+
+// bundleRequest loads the bundle a plan or apply request is for, writing
+// the error response itself when that fails
+func bundleRequest(c *gin.Context, db *sql.DB) (*bundleApplyRequest, *PluginBundle, *PteroClient, bool) {
+	var req bundleApplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, nil, false
+	}
+	b, err := loadBundle(db, c.Param("bundle"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bundle not found"})
+		return nil, nil, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, nil, false
+	}
+	client, err := NewPteroClientAPI(db)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, nil, false
+	}
+	return &req, b, client, true
+}
+
+// PlanBundleHandler shows what applying a bundle would do on each server
+func PlanBundleHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, b, client, ok := bundleRequest(c, db)
+		if !ok {
+			return
+		}
+		plans := []*BundleServerPlan{}
+		for _, serverID := range req.Servers {
+			plans = append(plans, planBundle(db, client, serverID, b))
+		}
+		c.JSON(http.StatusOK, gin.H{"bundle": b.Name, "version": b.Version, "servers": plans})
+	}
+}
+
+// ApplyBundleHandler applies a bundle to servers one after another as a
+// background job. A server whose plan has problems is skipped unless force
+// is set, and one whose platform is unknown always is. A failure on one
+// server does not stop the others. A failed plugin install leaves none of
+// the bundle's plugins behind; a failed file write comes after the plugins
+// are in, so that result is marked partial and lists what was done.
+func ApplyBundleHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, b, client, ok := bundleRequest(c, db)
+		if !ok {
+			return
+		}
+		author := CurrentUsername(c, db)
+
+		job := StartJob("bundle-apply", "", func(job *Job) (map[string]interface{}, error) {
+			results := []gin.H{}
+			failed := 0
+			for i, serverID := range req.Servers {
+				job.SetProgress(int64(i), int64(len(req.Servers)), "Applying "+b.Name+" to "+serverID)
+				sp := planBundle(db, client, serverID, b)
+				result := gin.H{"server": serverID, "plan": sp}
+				if sp.Error != "" {
+					result["error"] = sp.Error
+					failed++
+					results = append(results, result)
+					continue
+				}
+				if sp.Plan.Blocked() && !req.Force {
+					result["error"] = "Bundle cannot be applied as planned"
+					failed++
+					results = append(results, result)
+					continue
+				}
+				installed, written, err := applyBundle(db, client, sp, b, author)
+				result["installed"] = installed
+				result["files_written"] = written
+				if err != nil {
+					result["error"] = err.Error()
+					result["partial"] = len(installed) > 0 || len(written) > 0
+					failed++
+				}
+				results = append(results, result)
+			}
+			job.SetProgress(int64(len(req.Servers)), int64(len(req.Servers)), "Done")
+			return map[string]interface{}{
+				"bundle":  b.Name,
+				"version": b.Version,
+				"results": results,
+				"failed":  failed,
+			}, nil
+		})
+
+		respondWithJob(c, job)
+	}
+}
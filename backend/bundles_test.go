@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.0", -1},
+		{"1.2", "1.10", -1},
+		{"2.0", "1.99.9", 1},
+		{"1.0-beta", "1.0", -1},
+		{"1.0", "1.0-beta", 1},
+		{"1.0-alpha", "1.0-beta", -1},
+		{"1.0-BETA", "1.0-beta", 0},
+		{"5.4.145", "5.4.2", 1},
+		{"v2.0", "2.0", -1},
+		{"1.0.1", "1.0-rc1", 1},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{"", []string{"1.0", "anything"}, nil},
+		{"2.0", []string{"2.0"}, []string{"2.0.1", "1.9"}},
+		{"=2.0", []string{"2.0"}, []string{"2.1"}},
+		{">=2.0", []string{"2.0", "2.5", "10.0"}, []string{"1.9", "2.0-beta"}},
+		{">= 2.0", []string{"2.0", "3.1"}, []string{"1.9"}},
+		{">= 2.0, < 3", []string{"2.0", "2.9.9"}, []string{"3", "3.0.1", "1.0"}},
+		{">=2.0 <3", []string{"2.5"}, []string{"3.1"}},
+		{"  > 1.0 ,<= 1.5  ", []string{"1.1", "1.5"}, []string{"1.0", "1.6"}},
+		{"!= 1.2", []string{"1.1", "1.3"}, []string{"1.2"}},
+		{"5.4.*", []string{"5.4", "5.4.0", "5.4.145"}, []string{"5.40", "5.5", "5"}},
+		{"1.21.x", []string{"1.21", "1.21.4"}, []string{"1.2", "1.22"}},
+		{"= 5.4.*", []string{"5.4.1"}, []string{"5.3"}},
+		{"5.4.*, != 5.4.2", []string{"5.4.1"}, []string{"5.4.2"}},
+	}
+
+	for _, tt := range tests {
+		vc, err := parseVersionConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("%q: %v", tt.constraint, err)
+			continue
+		}
+		for _, v := range tt.matches {
+			if !vc.Matches(v) {
+				t.Errorf("%q should match %s", tt.constraint, v)
+			}
+		}
+		for _, v := range tt.rejects {
+			if vc.Matches(v) {
+				t.Errorf("%q should not match %s", tt.constraint, v)
+			}
+		}
+	}
+
+	for _, bad := range []string{">=", ">= ", "2.0 >=", ">=2.0<3", ">> 2", ">= 5.4.*", "< 1.x", "=<2"} {
+		if _, err := parseVersionConstraint(bad); err == nil {
+			t.Errorf("%q should be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Downloaded plugins, mods and modpack files are kept here, named by their
+// sha256, so installing the same file on another server needs no download
+const artifactCacheDir = "./artifact-cache"
+
+// Size the cache is kept under, overridable through the artifact_cache_mb
+// setting; 0 turns caching off
+const defaultArtifactCacheMB = 2048
+
+// ArtifactCache is a content-addressed store of downloaded artifacts. Files
+// are found by the hashes a source publishes for them, or by
+// source/slug/version when it publishes none, and the least recently used
+// are evicted once the cache outgrows its size limit.
+type ArtifactCache struct {
+	db  *sql.DB
+	dir string
+	mu  sync.Mutex
+}
+
+type CachedArtifact struct {
+	Source   string `json:"source"`
+	Slug     string `json:"slug"`
+	Version  string `json:"version"`
+	FileName string `json:"file_name"`
+	SHA256   string `json:"sha256"`
+	SHA1     string `json:"sha1"`
+	Size     int64  `json:"size"`
+	CachedAt string `json:"cached_at"`
+	LastUsed string `json:"last_used"`
+}
+
+var artifactCache *ArtifactCache
+
+func InitArtifactCache(db *sql.DB) {
+	if err := os.MkdirAll(artifactCacheDir, 0755); err != nil {
+		log.Printf("[WARN] Artifact cache disabled: %v", err)
+		return
+	}
+	artifactCache = &ArtifactCache{db: db, dir: artifactCacheDir}
+}
+
+func (ac *ArtifactCache) maxSize() int64 {
+	return int64(GetSettingInt(ac.db, "artifact_cache_mb", defaultArtifactCacheMB)) << 20
+}
+
+func (ac *ArtifactCache) blobPath(sum string) string {
+	return filepath.Join(ac.dir, sum[:2], sum)
+}
+
+// lookup finds the sha256 of a cached copy of artifact. The hashes its
+// source published decide which file that is, and an entry whose stored
+// hashes disagree with them is never used. Only artifacts without any
+// published hash are looked up by source, slug and version.
+func (ac *ArtifactCache) lookup(artifact *PluginArtifact) string {
+	sha256Sum := strings.ToLower(artifact.Hashes["sha256"])
+	sha1Sum := strings.ToLower(artifact.Hashes["sha1"])
+	var sum string
+	switch {
+	case sha256Sum != "":
+		ac.db.QueryRow("SELECT sha256 FROM artifact_cache WHERE sha256 = ? AND (? = '' OR sha1 = ?) LIMIT 1",
+			sha256Sum, sha1Sum, sha1Sum).Scan(&sum)
+	case sha1Sum != "":
+		ac.db.QueryRow("SELECT sha256 FROM artifact_cache WHERE sha1 = ? LIMIT 1", sha1Sum).Scan(&sum)
+	case len(artifact.Hashes) == 0 && artifact.Slug != "":
+		ac.db.QueryRow(`SELECT sha256 FROM artifact_cache WHERE source = ? AND slug = ? AND version = ?
+			ORDER BY last_used DESC LIMIT 1`, artifact.Source, artifact.Slug, artifact.Version).Scan(&sum)
+	}
+	return sum
+}
+
+// openCached opens a cached file after checking it still has the hash it
+// was stored under. Damaged files are dropped from the cache. The lock is
+// only held to open the file: one evicted while it is hashed stays readable
+// through the open handle.
+func (ac *ArtifactCache) openCached(sum string) (*os.File, error) {
+	ac.mu.Lock()
+	f, err := os.Open(ac.blobPath(sum))
+	if err != nil {
+		ac.remove(sum)
+		ac.mu.Unlock()
+		return nil, err
+	}
+	ac.mu.Unlock()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil || hex.EncodeToString(hash.Sum(nil)) != sum {
+		log.Printf("[WARN] Cached artifact %s is damaged, downloading it again", sum)
+		ac.mu.Lock()
+		// Unless it was replaced by a fresh download in the meantime
+		opened, err1 := f.Stat()
+		current, err2 := os.Stat(ac.blobPath(sum))
+		if err1 == nil && err2 == nil && os.SameFile(opened, current) {
+			ac.remove(sum)
+		}
+		ac.mu.Unlock()
+		f.Close()
+		return nil, fmt.Errorf("cached file is damaged")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	ac.db.Exec("UPDATE artifact_cache SET last_used = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE sha256 = ?", sum)
+	return f, nil
+}
+
+// remove deletes a cached file and every entry pointing at it
+func (ac *ArtifactCache) remove(sum string) {
+	os.Remove(ac.blobPath(sum))
+	ac.db.Exec("DELETE FROM artifact_cache WHERE sha256 = ?", sum)
+}
+
+// store downloads an artifact into the cache, checking it against the
+// hashes its source published before keeping it. Only moving the finished
+// file into place holds the cache lock.
+func (ac *ArtifactCache) store(artifact *PluginArtifact, limit int64) (io.ReadCloser, error) {
+	resp, err := sourceDownload(artifact.URL)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed (status %d)", resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp(ac.dir, "download-*")
+	if err != nil {
+		return nil, err
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	sum256, sum1 := sha256.New(), sha1.New()
+	writers := []io.Writer{tmp, sum256, sum1}
+	var verifiers []*checksumVerifier
+	for algorithm, digest := range artifact.Hashes {
+		if v, err := newChecksumVerifier(algorithm + ":" + digest); err == nil && v != nil {
+			verifiers = append(verifiers, v)
+			writers = append(writers, v)
+		}
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %v", err)
+	}
+	if size > limit {
+		return nil, fmt.Errorf("download is larger than %d MB", limit>>20)
+	}
+	for _, v := range verifiers {
+		if err := v.Verify(); err != nil {
+			return nil, err
+		}
+	}
+	sum := hex.EncodeToString(sum256.Sum(nil))
+
+	// Too big to ever fit: hand the download over and let it go on close
+	if size > ac.maxSize() {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		keep = true
+		return &tempFile{tmp}, nil
+	}
+
+	tmp.Close()
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(ac.blobPath(sum)), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), ac.blobPath(sum)); err != nil {
+		return nil, err
+	}
+	keep = true
+
+	fileName := artifact.FileName
+	if fileName == "" {
+		fileName = filepath.Base(resp.Request.URL.Path)
+	}
+	// What the version supports is kept so an offline install can still
+	// pick one that fits the server
+	gameVersions, _ := json.Marshal(artifact.GameVersions)
+	loaders, _ := json.Marshal(artifact.Loaders)
+	ac.db.Exec(`INSERT OR REPLACE INTO artifact_cache (source, slug, version, file_name, sha256, sha1, size, game_versions, loaders, client_only)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		artifact.Source, artifact.Slug, artifact.Version, fileName, sum, hex.EncodeToString(sum1.Sum(nil)), size,
+		string(gameVersions), string(loaders), artifact.ClientOnly)
+	ac.evict(sum)
+
+	return os.Open(ac.blobPath(sum))
+}
+
+// evict removes the least recently used files until the cache fits its
+// size limit again, never removing keep
+func (ac *ArtifactCache) evict(keep string) {
+	max := ac.maxSize()
+	var total int64
+	ac.db.QueryRow("SELECT COALESCE(SUM(size), 0) FROM (SELECT DISTINCT sha256, size FROM artifact_cache)").Scan(&total)
+	if total <= max {
+		return
+	}
+
+	rows, err := ac.db.Query(`SELECT sha256, MAX(size) FROM artifact_cache WHERE sha256 != ?
+		GROUP BY sha256 ORDER BY MAX(last_used)`, keep)
+	if err != nil {
+		return
+	}
+	var victims []string
+	for rows.Next() && total > max {
+		var sum string
+		var size int64
+		rows.Scan(&sum, &size)
+		victims = append(victims, sum)
+		total -= size
+	}
+	rows.Close()
+	for _, sum := range victims {
+		ac.remove(sum)
+	}
+}
+
+// tempFile deletes the file it reads from once closed
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.File.Name())
+	return err
+}
+
+// openArtifact returns the content of an artifact, from the cache when a
+// good copy is there and from its source otherwise. Downloads larger than
+// limit are refused.
+func openArtifact(artifact *PluginArtifact, limit int64) (io.ReadCloser, error) {
+	ac := artifactCache
+	if ac == nil || ac.maxSize() <= 0 {
+		if artifact.URL == "" {
+			return nil, fmt.Errorf("%s is not cached and has no download URL", artifact.FileName)
+		}
+		resp, err := sourceDownload(artifact.URL)
+		if err != nil {
+			return nil, fmt.Errorf("download failed: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("download failed (status %d)", resp.StatusCode)
+		}
+		return resp.Body, nil
+	}
+
+	if sum := ac.lookup(artifact); sum != "" {
+		if f, err := ac.openCached(sum); err == nil {
+			return f, nil
+		}
+	}
+	if artifact.URL == "" {
+		return nil, fmt.Errorf("%s is not cached and has no download URL", artifact.FileName)
+	}
+	return ac.store(artifact, limit)
+}
+
+// cachedArtifacts lists the cached versions of a project, newest first
+func cachedArtifacts(source, slug string) []PluginArtifact {
+	ac := artifactCache
+	if ac == nil || slug == "" {
+		return nil
+	}
+	rows, err := ac.db.Query(`SELECT version, file_name, sha256, game_versions, loaders, client_only FROM artifact_cache
+		WHERE source = ? AND slug = ? ORDER BY cached_at DESC, last_used DESC`, source, slug)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var artifacts []PluginArtifact
+	for rows.Next() {
+		a := PluginArtifact{Source: source, Slug: slug, Channel: ChannelRelease}
+		var sum, gameVersions, loaders string
+		if err := rows.Scan(&a.Version, &a.FileName, &sum, &gameVersions, &loaders, &a.ClientOnly); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(gameVersions), &a.GameVersions)
+		json.Unmarshal([]byte(loaders), &a.Loaders)
+		a.Hashes = map[string]string{"sha256": sum}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts
+}
+
+// cachedFallback picks the cached version of a project to install when its
+// source could not be reached (cause), chosen for target the same way as an
+// online install, or returns nil
+func cachedFallback(source, slug, version string, target *ServerPlatform, cause error) *PluginArtifact {
+	if target == nil {
+		target = &ServerPlatform{}
+	}
+	a, err := selectArtifact(cachedArtifacts(source, slug), version, target)
+	if err != nil {
+		return nil
+	}
+	warning := fmt.Sprintf("Could not reach %s (%v); installing cached version %s", source, cause, a.Version)
+	if a.Warning != "" {
+		warning += ". " + a.Warning
+	}
+	a.Warning = warning
+	return a
+}
+
+// ListArtifactCacheHandler lists what is in the artifact cache
+func ListArtifactCacheHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rows, err := db.Query(`SELECT source, slug, version, file_name, sha256, sha1, size, cached_at, last_used
+			FROM artifact_cache ORDER BY last_used DESC`)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		entries := []CachedArtifact{}
+		seen := map[string]bool{}
+		var total int64
+		for rows.Next() {
+			var e CachedArtifact
+			rows.Scan(&e.Source, &e.Slug, &e.Version, &e.FileName, &e.SHA256, &e.SHA1, &e.Size, &e.CachedAt, &e.LastUsed)
+			if !seen[e.SHA256] {
+				seen[e.SHA256] = true
+				total += e.Size
+			}
+			entries = append(entries, e)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"entries":  entries,
+			"size":     total,
+			"max_size": int64(GetSettingInt(db, "artifact_cache_mb", defaultArtifactCacheMB)) << 20,
+		})
+	}
+}
+
+// PurgeArtifactCacheHandler empties the artifact cache, or with ?source= and
+// ?slug= (and optionally ?version=) drops just the files of one project
+func PurgeArtifactCacheHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ac := artifactCache
+		if ac == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Artifact cache is not available"})
+			return
+		}
+
+		query := "SELECT DISTINCT sha256 FROM artifact_cache"
+		var args []interface{}
+		if source, slug := c.Query("source"), c.Query("slug"); source != "" || slug != "" {
+			query += " WHERE source = ? AND slug = ?"
+			args = append(args, source, slug)
+			if version := c.Query("version"); version != "" {
+				query += " AND version = ?"
+				args = append(args, version)
+			}
+		}
+
+		ac.mu.Lock()
+		defer ac.mu.Unlock()
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var sums []string
+		for rows.Next() {
+			var sum string
+			rows.Scan(&sum)
+			sums = append(sums, sum)
+		}
+		rows.Close()
+
+		for _, sum := range sums {
+			ac.remove(sum)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Cache purged", "removed": len(sums)})
+	}
+}
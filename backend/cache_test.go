@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestArtifactCacheLookup(t *testing.T) {
+	ac := &ArtifactCache{db: testDB(t), dir: t.TempDir()}
+	ac.db.Exec(`INSERT INTO artifact_cache (source, slug, version, file_name, sha256, sha1, size) VALUES
+		('modrinth', 'chunky', '1.4', 'Chunky-1.4.jar', 'aaaa', '1111', 10),
+		('spigot', '81534', '1.4', 'Chunky.jar', 'bbbb', '2222', 10)`)
+
+	tests := []struct {
+		name     string
+		artifact PluginArtifact
+		want     string
+	}{
+		{"sha256", PluginArtifact{Hashes: map[string]string{"sha256": "AAAA"}}, "aaaa"},
+		{"sha256 and a matching sha1", PluginArtifact{Hashes: map[string]string{"sha256": "aaaa", "sha1": "1111"}}, "aaaa"},
+		{"sha256 with a contradicting sha1", PluginArtifact{Hashes: map[string]string{"sha256": "aaaa", "sha1": "9999"}}, ""},
+		{"sha1", PluginArtifact{Hashes: map[string]string{"sha1": "2222", "sha512": "ffff"}}, "bbbb"},
+		{"hashes win over the version", PluginArtifact{Source: "modrinth", Slug: "chunky", Version: "1.4", Hashes: map[string]string{"sha1": "3333"}}, ""},
+		{"unhashed artifact by version", PluginArtifact{Source: "spigot", Slug: "81534", Version: "1.4"}, "bbbb"},
+		{"unhashed artifact of another version", PluginArtifact{Source: "spigot", Slug: "81534", Version: "1.5"}, ""},
+		{"only unindexed hashes", PluginArtifact{Source: "modrinth", Slug: "chunky", Version: "1.4", Hashes: map[string]string{"sha512": "ffff"}}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := ac.lookup(&tt.artifact); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,1391 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigFile describes a server config PanelManager knows how to edit structurally
+type ConfigFile struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Format string `json:"format"` // properties, yaml or toml
+}
+
+var knownConfigs = []ConfigFile{
+	{Name: "server.properties", Path: "/server.properties", Format: "properties"},
+	{Name: "bukkit.yml", Path: "/bukkit.yml", Format: "yaml"},
+	{Name: "spigot.yml", Path: "/spigot.yml", Format: "yaml"},
+	{Name: "paper-global.yml", Path: "/config/paper-global.yml", Format: "yaml"},
+	{Name: "paper-world-defaults.yml", Path: "/config/paper-world-defaults.yml", Format: "yaml"},
+	{Name: "velocity.toml", Path: "/velocity.toml", Format: "toml"},
+}
+
+func findKnownConfig(name string) (ConfigFile, bool) {
+	for _, cfg := range knownConfigs {
+		if cfg.Name == name {
+			return cfg, true
+		}
+	}
+	return ConfigFile{}, false
+}
+
+// ConfigEntry is one typed key/value pair. Nested keys are joined with dots.
+// Entries the line editor can't safely rewrite (multi-line strings, lists of
+// maps, inline tables) are returned with ReadOnly set.
+type ConfigEntry struct {
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value"`
+	Type     string      `json:"type"` // string, bool, int, float, list, null or raw
+	Comment  string      `json:"comment,omitempty"`
+	ReadOnly bool        `json:"read_only,omitempty"`
+}
+
+// configEntry remembers where an entry lives in the file so an update can
+// rewrite just its value and keep everything around it untouched
+type configEntry struct {
+	ConfigEntry
+	line   int    // first line of the entry
+	end    int    // line after the last line belonging to the value
+	prefix string // everything on the first line before the value
+	suffix string // trailing comment, including the whitespace before it
+	block  bool   // value is a YAML block list
+	indent string // indentation of the block list items
+}
+
+type parsedConfig struct {
+	format  string
+	lines   []string
+	entries []*configEntry
+	byKey   map[string]*configEntry
+}
+
+func (p *parsedConfig) add(e *configEntry) {
+	if _, dup := p.byKey[e.Key]; dup {
+		return
+	}
+	p.entries = append(p.entries, e)
+	p.byKey[e.Key] = e
+}
+
+// Entries returns the public view of all entries in file order
+func (p *parsedConfig) Entries() []ConfigEntry {
+	entries := make([]ConfigEntry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e.ConfigEntry)
+	}
+	return entries
+}
+
+// String reassembles the file
+func (p *parsedConfig) String() string {
+	return strings.Join(p.lines, "\n")
+}
+
+func parseConfig(format, content string) *parsedConfig {
+	cfg := &parsedConfig{format: format, lines: strings.Split(content, "\n"), byKey: map[string]*configEntry{}}
+	switch format {
+	case "properties":
+		parseProperties(cfg)
+	case "yaml":
+		parseYAML(cfg)
+	case "toml":
+		parseTOML(cfg)
+	}
+	return cfg
+}
+
+// scalarType infers the type of an unquoted scalar
+func scalarType(s string) (interface{}, string) {
+	switch s {
+	case "true":
+		return true, "bool"
+	case "false":
+		return false, "bool"
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, "int"
+	}
+	if strings.ContainsAny(s, ".eE") {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return f, "float"
+		}
+	}
+	return s, "string"
+}
+
+// splitTrailingComment separates a value from a trailing "# comment",
+// ignoring # characters inside quotes. The returned suffix keeps the
+// whitespace in front of the comment.
+func splitTrailingComment(s string, requireSpace bool) (value, suffix string) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '#':
+			if !requireSpace || i == 0 || s[i-1] == ' ' || s[i-1] == '\t' {
+				value = strings.TrimRight(s[:i], " \t")
+				return value, s[len(value):]
+			}
+		}
+	}
+	value = strings.TrimRight(s, " \t\r")
+	return value, s[len(value):]
+}
+
+func leadingComment(lines []string, line int) string {
+	var comment []string
+	for i := line - 1; i >= 0; i-- {
+		t := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(t, "#") && !strings.HasPrefix(t, "!") {
+			break
+		}
+		comment = append([]string{strings.TrimSpace(strings.TrimLeft(t, "#!"))}, comment...)
+	}
+	return strings.Join(comment, "\n")
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// server.properties
+
+func parseProperties(cfg *parsedConfig) {
+	for i, raw := range cfg.lines {
+		line := strings.TrimRight(raw, "\r")
+		t := strings.TrimLeft(line, " \t\f")
+		if t == "" || t[0] == '#' || t[0] == '!' {
+			continue
+		}
+
+		// The key ends at the first unescaped '=', ':' or whitespace
+		keyEnd := len(line)
+		start := len(line) - len(t)
+		for j := start; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+				continue
+			}
+			if line[j] == '=' || line[j] == ':' || line[j] == ' ' || line[j] == '\t' {
+				keyEnd = j
+				break
+			}
+		}
+		valueStart := keyEnd
+		for valueStart < len(line) && (line[valueStart] == ' ' || line[valueStart] == '\t') {
+			valueStart++
+		}
+		if valueStart < len(line) && (line[valueStart] == '=' || line[valueStart] == ':') {
+			valueStart++
+		}
+		for valueStart < len(line) && (line[valueStart] == ' ' || line[valueStart] == '\t') {
+			valueStart++
+		}
+
+		value, typ := scalarType(unescapeProperty(line[valueStart:]))
+		cfg.add(&configEntry{
+			ConfigEntry: ConfigEntry{
+				Key:     unescapeProperty(line[start:keyEnd]),
+				Value:   value,
+				Type:    typ,
+				Comment: leadingComment(cfg.lines, i),
+			},
+			line:   i,
+			end:    i + 1,
+			prefix: line[:valueStart],
+			suffix: raw[len(line):],
+		})
+	}
+}
+
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					b.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			b.WriteByte('u')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// escapeProperty escapes a value the way java.util.Properties stores it
+func escapeProperty(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\' || r == ':' || r == '=' || r == '#' || r == '!':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == ' ' && i == 0:
+			b.WriteString("\\ ")
+		case r == '\n':
+			b.WriteString("\\n")
+		case r == '\t':
+			b.WriteString("\\t")
+		case r == '\r':
+			b.WriteString("\\r")
+		case r < 0x20 || r > 0x7e:
+			if r > 0xffff {
+				for _, unit := range utf16Units(r) {
+					fmt.Fprintf(&b, "\\u%04X", unit)
+				}
+			} else {
+				fmt.Fprintf(&b, "\\u%04X", r)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func utf16Units(r rune) []rune {
+	r -= 0x10000
+	return []rune{0xd800 + (r>>10)&0x3ff, 0xdc00 + r&0x3ff}
+}
+
+// YAML (the block-style subset Bukkit, Spigot and Paper write)
+
+// yamlKey splits "key: rest" and returns the unquoted key and the offset of rest
+func yamlKey(line string, start int) (key string, rest int, ok bool) {
+	t := line[start:]
+	if t == "" {
+		return "", 0, false
+	}
+	if t[0] == '"' || t[0] == '\'' {
+		end := strings.IndexByte(t[1:], t[0])
+		if end < 0 {
+			return "", 0, false
+		}
+		key = t[1 : end+1]
+		after := t[end+2:]
+		trimmed := strings.TrimLeft(after, " ")
+		if !strings.HasPrefix(trimmed, ":") {
+			return "", 0, false
+		}
+		return key, start + end + 2 + (len(after) - len(trimmed)) + 1, true
+	}
+	for i := 0; i < len(t); i++ {
+		if t[i] == ':' && (i+1 == len(t) || t[i+1] == ' ' || t[i+1] == '\t' || t[i+1] == '\r') {
+			return strings.TrimRight(t[:i], " "), start + i + 1, true
+		}
+		if t[i] == '#' && i > 0 && t[i-1] == ' ' {
+			break
+		}
+	}
+	return "", 0, false
+}
+
+// yamlScalar decodes a single scalar. ok is false for anything that isn't a
+// plain or quoted scalar (flow collections, anchors, tags, block scalars).
+func yamlScalar(s string) (interface{}, string, bool) {
+	if s == "" || s == "~" || s == "null" {
+		return nil, "null", true
+	}
+	switch s[0] {
+	case '\'':
+		if len(s) >= 2 && s[len(s)-1] == '\'' {
+			return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), "string", true
+		}
+		return nil, "", false
+	case '"':
+		if unq, err := strconv.Unquote(s); err == nil {
+			return unq, "string", true
+		}
+		return nil, "", false
+	case '[', '{', '&', '*', '!', '|', '>', '%', '@', '`':
+		return nil, "", false
+	}
+	v, typ := scalarType(s)
+	return v, typ, true
+}
+
+// yamlFlowList decodes a single-line flow list of scalars such as [a, 'b', 3]
+func yamlFlowList(s string) ([]interface{}, bool) {
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil, false
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	items := []interface{}{}
+	if inner == "" {
+		return items, true
+	}
+	for _, part := range splitFlowItems(inner) {
+		v, _, ok := yamlScalar(strings.TrimSpace(part))
+		if !ok {
+			return nil, false
+		}
+		items = append(items, v)
+	}
+	return items, true
+}
+
+// splitFlowItems splits on commas outside quotes and brackets
+func splitFlowItems(s string) []string {
+	var parts []string
+	var quote byte
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '[' || ch == '{':
+			depth++
+		case ch == ']' || ch == '}':
+			depth--
+		case ch == ',' && depth == 0:
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+func parseYAML(cfg *parsedConfig) {
+	type parent struct {
+		indent int
+		key    string
+	}
+	var stack []parent
+	lines := cfg.lines
+
+	isBlank := func(i int) bool {
+		t := strings.TrimSpace(lines[i])
+		return t == "" || strings.HasPrefix(t, "#")
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "#") || t == "---" || t == "..." {
+			continue
+		}
+		indent := indentOf(line)
+		if strings.HasPrefix(t, "- ") || t == "-" {
+			// Stray list item (its key was consumed as read-only); skip it
+			continue
+		}
+
+		key, restAt, ok := yamlKey(line, indent)
+		if !ok {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		fullKey := key
+		if len(stack) > 0 {
+			fullKey = stack[len(stack)-1].key + "." + key
+		}
+
+		rest := line[restAt:]
+		valueText, suffix := splitTrailingComment(rest, true)
+		value := strings.TrimSpace(valueText)
+		valueStart := restAt + (len(valueText) - len(strings.TrimLeft(valueText, " \t")))
+		entry := &configEntry{
+			ConfigEntry: ConfigEntry{Key: fullKey, Comment: leadingComment(lines, i)},
+			line:        i,
+			end:         i + 1,
+			prefix:      line[:valueStart],
+			suffix:      suffix + lines[i][len(line):],
+		}
+
+		// Lines that belong to this key's value (more indented, or list items
+		// at the same indent)
+		next := i + 1
+		childEnd := i + 1
+		for next < len(lines) {
+			if isBlank(next) {
+				next++
+				continue
+			}
+			nl := strings.TrimRight(lines[next], "\r")
+			ni := indentOf(nl)
+			nt := strings.TrimSpace(nl)
+			if ni > indent || (ni == indent && (strings.HasPrefix(nt, "- ") || nt == "-")) {
+				next++
+				childEnd = next
+				continue
+			}
+			break
+		}
+
+		switch {
+		case value == "" && childEnd > i+1 && strings.HasPrefix(strings.TrimSpace(firstSignificant(lines, i+1, childEnd)), "-"):
+			// Block list
+			items := []interface{}{}
+			itemIndent := ""
+			simple := true
+			for j := i + 1; j < childEnd; j++ {
+				if isBlank(j) {
+					continue
+				}
+				il := strings.TrimRight(lines[j], "\r")
+				it := strings.TrimSpace(il)
+				if !strings.HasPrefix(it, "-") {
+					simple = false
+					break
+				}
+				if itemIndent == "" {
+					itemIndent = il[:indentOf(il)]
+				} else if il[:indentOf(il)] != itemIndent {
+					simple = false
+					break
+				}
+				itemText, _ := splitTrailingComment(strings.TrimSpace(strings.TrimPrefix(it, "-")), true)
+				if _, _, isKey := yamlKey(itemText, 0); isKey {
+					simple = false
+					break
+				}
+				v, _, ok := yamlScalar(itemText)
+				if !ok {
+					simple = false
+					break
+				}
+				items = append(items, v)
+			}
+			entry.end = childEnd
+			if simple {
+				entry.Value, entry.Type, entry.block, entry.indent = items, "list", true, itemIndent
+			} else {
+				entry.Value, entry.Type, entry.ReadOnly = nil, "raw", true
+			}
+			cfg.add(entry)
+			i = childEnd - 1
+
+		case value == "":
+			// Nested mapping (or an empty value)
+			if childEnd == i+1 {
+				entry.Value, entry.Type = nil, "null"
+				cfg.add(entry)
+			} else {
+				stack = append(stack, parent{indent: indent, key: fullKey})
+			}
+
+		case strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"):
+			entry.Type, entry.ReadOnly, entry.end = "raw", true, childEnd
+			cfg.add(entry)
+			i = childEnd - 1
+
+		case strings.HasPrefix(value, "["):
+			if items, ok := yamlFlowList(value); ok && childEnd == i+1 {
+				entry.Value, entry.Type = items, "list"
+			} else {
+				entry.Type, entry.ReadOnly, entry.end = "raw", true, childEnd
+				i = childEnd - 1
+			}
+			cfg.add(entry)
+
+		default:
+			v, typ, ok := yamlScalar(value)
+			if !ok || childEnd > i+1 {
+				entry.Type, entry.ReadOnly, entry.end = "raw", true, childEnd
+				i = childEnd - 1
+			} else {
+				entry.Value, entry.Type = v, typ
+			}
+			cfg.add(entry)
+		}
+	}
+}
+
+func firstSignificant(lines []string, from, to int) string {
+	for j := from; j < to; j++ {
+		t := strings.TrimSpace(lines[j])
+		if t != "" && !strings.HasPrefix(t, "#") {
+			return t
+		}
+	}
+	return ""
+}
+
+// yamlNeedsQuotes reports whether a string would be read back as something else unquoted
+func yamlNeedsQuotes(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+	if _, typ, ok := yamlScalar(s); !ok || typ != "string" {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "yes", "no", "on", "off", "y", "n", "null", "~":
+		return true
+	}
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	return strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.ContainsAny(s, "\n\t") || strings.HasSuffix(s, ":")
+}
+
+func formatYAMLScalar(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if !yamlNeedsQuotes(x) {
+			return x
+		}
+		if strings.ContainsAny(x, "\n\t") {
+			return strconv.Quote(x)
+		}
+		return "'" + strings.ReplaceAll(x, "'", "''") + "'"
+	default:
+		return formatPlainScalar(x)
+	}
+}
+
+func formatPlainScalar(v interface{}) string {
+	switch x := v.(type) {
+	case bool:
+		return strconv.FormatBool(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		s := strconv.FormatFloat(x, 'f', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		return s
+	case string:
+		return x
+	}
+	return fmt.Sprint(v)
+}
+
+// TOML (Velocity's config)
+
+func tomlKeyParts(s string) []string {
+	var parts []string
+	for _, part := range splitOutsideQuotes(s, '.') {
+		part = strings.TrimSpace(part)
+		if len(part) >= 2 && (part[0] == '"' || part[0] == '\'') && part[len(part)-1] == part[0] {
+			if part[0] == '"' {
+				if unq, err := strconv.Unquote(part); err == nil {
+					part = unq
+				}
+			} else {
+				part = part[1 : len(part)-1]
+			}
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == sep:
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// tomlScalar decodes a single TOML value; ok is false for inline tables,
+// multi-line strings and dates
+func tomlScalar(s string) (interface{}, string, bool) {
+	if s == "" {
+		return nil, "", false
+	}
+	switch {
+	case strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''"):
+		return nil, "", false
+	case s[0] == '"':
+		if unq, err := strconv.Unquote(s); err == nil {
+			return unq, "string", true
+		}
+		return nil, "", false
+	case s[0] == '\'':
+		if len(s) >= 2 && s[len(s)-1] == '\'' {
+			return s[1 : len(s)-1], "string", true
+		}
+		return nil, "", false
+	case s == "true":
+		return true, "bool", true
+	case s == "false":
+		return false, "bool", true
+	}
+	clean := strings.ReplaceAll(s, "_", "")
+	if n, err := strconv.ParseInt(clean, 0, 64); err == nil {
+		return n, "int", true
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return f, "float", true
+	}
+	return nil, "", false
+}
+
+// tomlBracketDepth returns the [ ] nesting left open at the end of s
+func tomlBracketDepth(s string, depth int) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '#':
+			return depth
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+func parseTOML(cfg *parsedConfig) {
+	lines := cfg.lines
+	table := ""
+	inArrayTable := false
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(t, "[[") {
+			inArrayTable = true
+			continue
+		}
+		if strings.HasPrefix(t, "[") {
+			header, _ := splitTrailingComment(t, false)
+			header = strings.TrimSuffix(strings.TrimPrefix(header, "["), "]")
+			table = strings.Join(tomlKeyParts(header), ".")
+			inArrayTable = false
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			continue
+		}
+		keyParts := tomlKeyParts(line[:eq])
+		fullKey := strings.Join(keyParts, ".")
+		if table != "" {
+			fullKey = table + "." + fullKey
+		}
+
+		valueStart := eq + 1
+		for valueStart < len(line) && (line[valueStart] == ' ' || line[valueStart] == '\t') {
+			valueStart++
+		}
+		valueText, suffix := splitTrailingComment(line[valueStart:], false)
+		entry := &configEntry{
+			ConfigEntry: ConfigEntry{Key: fullKey, Comment: leadingComment(lines, i)},
+			line:        i,
+			end:         i + 1,
+			prefix:      line[:valueStart],
+			suffix:      suffix + lines[i][len(line):],
+		}
+
+		switch {
+		case strings.HasPrefix(valueText, "["):
+			// Arrays may span several lines
+			depth := tomlBracketDepth(line[valueStart:], 0)
+			text := valueText
+			j := i
+			for depth > 0 && j+1 < len(lines) {
+				j++
+				next := strings.TrimRight(lines[j], "\r")
+				depth = tomlBracketDepth(next, depth)
+				nv, _ := splitTrailingComment(strings.TrimSpace(next), false)
+				text += " " + nv
+			}
+			entry.end = j + 1
+			if j > i {
+				entry.suffix = lines[j][len(strings.TrimRight(lines[j], "\r")):]
+			}
+			items, ok := tomlArray(text)
+			if ok {
+				entry.Value, entry.Type = items, "list"
+			} else {
+				entry.Type, entry.ReadOnly = "raw", true
+			}
+			i = j
+
+		case strings.HasPrefix(valueText, `"""`) || strings.HasPrefix(valueText, "'''"):
+			delim := valueText[:3]
+			j := i
+			if strings.Count(valueText, delim) < 2 {
+				for j+1 < len(lines) {
+					j++
+					if strings.Contains(lines[j], delim) {
+						break
+					}
+				}
+			}
+			entry.Type, entry.ReadOnly, entry.end = "raw", true, j+1
+			i = j
+
+		default:
+			v, typ, ok := tomlScalar(valueText)
+			if ok {
+				entry.Value, entry.Type = v, typ
+			} else {
+				entry.Type, entry.ReadOnly = "raw", true
+			}
+		}
+
+		if inArrayTable {
+			continue
+		}
+		cfg.add(entry)
+	}
+}
+
+func tomlArray(s string) ([]interface{}, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil, false
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	items := []interface{}{}
+	if inner == "" {
+		return items, true
+	}
+	for _, part := range splitFlowItems(inner) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue // trailing comma
+		}
+		v, _, ok := tomlScalar(part)
+		if !ok {
+			return nil, false
+		}
+		items = append(items, v)
+	}
+	return items, true
+}
+
+func tomlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func formatTOMLScalar(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return tomlQuote(s)
+	}
+	return formatPlainScalar(v)
+}
+
+// Updates
+
+// coerceConfigValue converts a JSON value into the type of the entry it replaces
+func coerceConfigValue(typ string, v interface{}) (interface{}, error) {
+	switch typ {
+	case "bool":
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case string:
+			if b, err := strconv.ParseBool(x); err == nil && (x == "true" || x == "false") {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("expected true or false")
+	case "int":
+		switch x := v.(type) {
+		case float64:
+			if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+				return int64(x), nil
+			}
+		case string:
+			if n, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64); err == nil {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("expected a whole number")
+	case "float":
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("expected a number")
+	case "list":
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a list")
+		}
+		out := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			switch x := item.(type) {
+			case string, bool, nil:
+				out = append(out, x)
+			case float64:
+				if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+					out = append(out, int64(x))
+				} else {
+					out = append(out, x)
+				}
+			default:
+				return nil, fmt.Errorf("lists may only contain plain values")
+			}
+		}
+		return out, nil
+	case "string", "null":
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case bool:
+			return x, nil
+		case float64:
+			if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+				return int64(x), nil
+			}
+			return x, nil
+		case nil:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("expected a plain value")
+	}
+	return nil, fmt.Errorf("value cannot be edited")
+}
+
+// set rewrites the lines of an entry with a new value
+func (p *parsedConfig) set(e *configEntry, v interface{}) {
+	var replacement []string
+	switch p.format {
+	case "properties":
+		s := formatPlainScalar(v)
+		if v == nil {
+			s = ""
+		}
+		replacement = []string{e.prefix + escapeProperty(s) + e.suffix}
+
+	case "yaml":
+		if items, ok := v.([]interface{}); ok && e.block && len(items) > 0 {
+			keyLine := strings.TrimRight(e.prefix, " ") + e.suffix
+			replacement = []string{keyLine}
+			for _, item := range items {
+				replacement = append(replacement, e.indent+"- "+formatYAMLScalar(item))
+			}
+			break
+		}
+		value := ""
+		if items, ok := v.([]interface{}); ok {
+			parts := make([]string, 0, len(items))
+			for _, item := range items {
+				parts = append(parts, formatYAMLScalar(item))
+			}
+			value = "[" + strings.Join(parts, ", ") + "]"
+		} else {
+			value = formatYAMLScalar(v)
+		}
+		prefix := e.prefix
+		if !strings.HasSuffix(prefix, " ") {
+			prefix += " "
+		}
+		replacement = []string{prefix + value + e.suffix}
+
+	case "toml":
+		value := ""
+		if items, ok := v.([]interface{}); ok {
+			parts := make([]string, 0, len(items))
+			for _, item := range items {
+				parts = append(parts, formatTOMLScalar(item))
+			}
+			value = "[" + strings.Join(parts, ", ") + "]"
+		} else {
+			value = formatTOMLScalar(v)
+		}
+		replacement = []string{e.prefix + value + e.suffix}
+	}
+
+	delta := len(replacement) - (e.end - e.line)
+	lines := append([]string{}, p.lines[:e.line]...)
+	lines = append(lines, replacement...)
+	p.lines = append(lines, p.lines[e.end:]...)
+
+	for _, other := range p.entries {
+		if other.line > e.line {
+			other.line += delta
+			other.end += delta
+		}
+	}
+	e.end = e.line + len(replacement)
+	e.Value = v
+}
+
+// appendProperty adds a key that isn't in server.properties yet
+func (p *parsedConfig) appendProperty(key string, v interface{}) {
+	line := escapeProperty(key) + "=" + escapeProperty(formatPlainScalar(v))
+	if n := len(p.lines); n > 0 && p.lines[n-1] == "" {
+		p.lines = append(p.lines[:n-1], line, "")
+	} else {
+		p.lines = append(p.lines, line)
+	}
+	value, typ := scalarType(formatPlainScalar(v))
+	p.add(&configEntry{ConfigEntry: ConfigEntry{Key: key, Value: value, Type: typ}})
+}
+
+// Validation
+
+type configRule func(v interface{}) error
+
+func rulePort(v interface{}) error {
+	n, ok := v.(int64)
+	if !ok || n < 1 || n > 65535 {
+		return fmt.Errorf("must be a port between 1 and 65535")
+	}
+	return nil
+}
+
+func ruleBool(v interface{}) error {
+	if _, ok := v.(bool); !ok {
+		return fmt.Errorf("must be true or false")
+	}
+	return nil
+}
+
+func ruleIntRange(min, max int64) configRule {
+	return func(v interface{}) error {
+		n, ok := v.(int64)
+		if !ok || n < min || n > max {
+			return fmt.Errorf("must be a whole number between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+func ruleEnum(options ...string) configRule {
+	return func(v interface{}) error {
+		s, ok := v.(string)
+		if ok {
+			for _, o := range options {
+				if strings.EqualFold(s, o) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(options, ", "))
+	}
+}
+
+func ruleHostPort(v interface{}) error {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("must be host:port")
+	}
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return fmt.Errorf("must be host:port")
+	}
+	n, err := strconv.ParseInt(port, 10, 64)
+	if err != nil {
+		return fmt.Errorf("must be host:port")
+	}
+	return rulePort(n)
+}
+
+func ruleString(v interface{}) error {
+	if _, ok := v.(string); !ok {
+		return fmt.Errorf("must be text")
+	}
+	return nil
+}
+
+// Known keys per file. A "*" segment matches any single key at that level.
+var configRules = map[string]map[string]configRule{
+	"server.properties": {
+		"server-port":                   rulePort,
+		"query.port":                    rulePort,
+		"rcon.port":                     rulePort,
+		"gamemode":                      ruleEnum("survival", "creative", "adventure", "spectator"),
+		"difficulty":                    ruleEnum("peaceful", "easy", "normal", "hard"),
+		"max-players":                   ruleIntRange(0, math.MaxInt32),
+		"view-distance":                 ruleIntRange(2, 32),
+		"simulation-distance":           ruleIntRange(2, 32),
+		"spawn-protection":              ruleIntRange(0, math.MaxInt32),
+		"max-world-size":                ruleIntRange(1, 29999984),
+		"network-compression-threshold": ruleIntRange(-1, math.MaxInt32),
+		"player-idle-timeout":           ruleIntRange(0, math.MaxInt32),
+		"op-permission-level":           ruleIntRange(0, 4),
+		"function-permission-level":     ruleIntRange(1, 4),
+		"motd":                          ruleString,
+		"level-name":                    ruleString,
+		"level-seed":                    ruleString,
+		"server-ip":                     ruleString,
+		"pvp":                           ruleBool,
+		"online-mode":                   ruleBool,
+		"white-list":                    ruleBool,
+		"enforce-whitelist":             ruleBool,
+		"hardcore":                      ruleBool,
+		"allow-flight":                  ruleBool,
+		"allow-nether":                  ruleBool,
+		"enable-command-block":          ruleBool,
+		"enable-rcon":                   ruleBool,
+		"enable-query":                  ruleBool,
+		"enable-status":                 ruleBool,
+		"force-gamemode":                ruleBool,
+		"generate-structures":           ruleBool,
+		"spawn-monsters":                ruleBool,
+		"spawn-animals":                 ruleBool,
+		"spawn-npcs":                    ruleBool,
+		"enforce-secure-profile":        ruleBool,
+		"prevent-proxy-connections":     ruleBool,
+	},
+	"bukkit.yml": {
+		"settings.allow-end":           ruleBool,
+		"settings.warn-on-overload":    ruleBool,
+		"settings.connection-throttle": ruleIntRange(-1, math.MaxInt32),
+		"spawn-limits.*":               ruleIntRange(-1, math.MaxInt32),
+		"ticks-per.*":                  ruleIntRange(-1, math.MaxInt32),
+	},
+	"spigot.yml": {
+		"settings.bungeecord":                   ruleBool,
+		"settings.restart-on-crash":             ruleBool,
+		"settings.timeout-time":                 ruleIntRange(1, math.MaxInt32),
+		"settings.save-user-cache-on-stop-only": ruleBool,
+	},
+	"paper-global.yml": {
+		"proxies.velocity.enabled":        ruleBool,
+		"proxies.velocity.online-mode":    ruleBool,
+		"proxies.velocity.secret":         ruleString,
+		"proxies.bungee-cord.online-mode": ruleBool,
+	},
+	"velocity.toml": {
+		"bind":                             ruleHostPort,
+		"motd":                             ruleString,
+		"show-max-players":                 ruleIntRange(0, math.MaxInt32),
+		"online-mode":                      ruleBool,
+		"force-key-authentication":         ruleBool,
+		"prevent-client-proxy-connections": ruleBool,
+		"player-info-forwarding-mode":      ruleEnum("none", "legacy", "bungeeguard", "modern"),
+		"forwarding-secret-file":           ruleString,
+		"servers.*":                        ruleHostPort,
+		"forced-hosts.*":                   nil,
+		"query.enabled":                    ruleBool,
+		"query.port":                       rulePort,
+	},
+}
+
+func lookupConfigRule(file, key string) (configRule, bool) {
+	rules := configRules[file]
+	if rule, ok := rules[key]; ok {
+		return rule, true
+	}
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		rule, ok := rules[key[:i]+".*"]
+		return rule, ok
+	}
+	return nil, false
+}
+
+// validateConfigValue applies the known rule for a key, if any. servers.try
+// in velocity.toml is a list of server names rather than an address.
+func validateConfigValue(file, key string, v interface{}) error {
+	if file == "velocity.toml" && key == "servers.try" {
+		return nil
+	}
+	rule, ok := lookupConfigRule(file, key)
+	if !ok || rule == nil {
+		return nil
+	}
+	return rule(v)
+}
+
+// propertyText renders a JSON value as server.properties text
+func propertyText(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		if strings.ContainsAny(x, "\n\r") {
+			return "", fmt.Errorf("must be a single line")
+		}
+		return x, nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return strconv.FormatInt(int64(x), 10), nil
+		}
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("expected a plain value")
+}
+
+// applyConfigUpdates validates and applies updates in key order. Problems are
+// collected per key so the caller can report all of them at once.
+func applyConfigUpdates(cfg *parsedConfig, file string, updates map[string]interface{}) map[string]string {
+	problems := map[string]string{}
+	keys := make([]string, 0, len(updates))
+	for k := range updates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		raw := updates[key]
+		entry, exists := cfg.byKey[key]
+
+		if cfg.format == "properties" {
+			// Everything in server.properties is text; the rules decide what it must look like
+			text, err := propertyText(raw)
+			if err != nil {
+				problems[key] = err.Error()
+				continue
+			}
+			typed, _ := scalarType(text)
+			if err := validateConfigValue(file, key, typed); err != nil && validateConfigValue(file, key, text) != nil {
+				problems[key] = err.Error()
+				continue
+			}
+			if !exists {
+				if key == "" || strings.ContainsAny(key, "\n\r") || !utf8.ValidString(key) {
+					problems[key] = "invalid key"
+					continue
+				}
+				cfg.appendProperty(key, text)
+				continue
+			}
+			// Write the text as given; re-rendering typed would turn "007" into 7
+			cfg.set(entry, text)
+			entry.Value = typed
+			continue
+		}
+
+		if !exists {
+			problems[key] = "unknown key"
+			continue
+		}
+		if entry.ReadOnly {
+			problems[key] = "this value can only be changed in the file editor"
+			continue
+		}
+		v, err := coerceConfigValue(entry.Type, raw)
+		if err == nil {
+			err = validateConfigValue(file, key, v)
+		}
+		if err != nil {
+			problems[key] = err.Error()
+			continue
+		}
+		cfg.set(entry, v)
+	}
+	return problems
+}
+
+// ListConfigsHandler lists the structured configs and whether each exists on the server
+func ListConfigsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		listings := map[string]map[string]bool{}
+		var configs []gin.H
+		for _, cfg := range knownConfigs {
+			dir := cfg.Path[:strings.LastIndex(cfg.Path, "/")+1]
+			if _, ok := listings[dir]; !ok {
+				listings[dir] = map[string]bool{}
+				if entries, err := client.ListFiles(id, dir); err == nil {
+					for _, e := range entries {
+						listings[dir][e.Name] = e.IsFile
+					}
+				}
+			}
+			configs = append(configs, gin.H{
+				"name":   cfg.Name,
+				"path":   cfg.Path,
+				"format": cfg.Format,
+				"exists": listings[dir][cfg.Name],
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"configs": configs})
+	}
+}
+
+func loadConfig(c *gin.Context, db *sql.DB) (*PteroClient, ConfigFile, []byte, bool) {
+	cfg, ok := findKnownConfig(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown config file"})
+		return nil, cfg, nil, false
+	}
+
+	client, err := NewPteroClientAPI(db)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, cfg, nil, false
+	}
+
+	limit := GetSettingInt(db, "editor_max_file_kb", defaultEditorMaxFileKB) << 10
+	content, err := client.ReadFile(c.Param("id"), cfg.Path, limit)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if pteroStatus(err) == http.StatusNotFound {
+			status = http.StatusNotFound
+		} else if err == errFileTooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return nil, cfg, nil, false
+	}
+	return client, cfg, content, true
+}
+
+// GetConfigHandler parses a known config into typed entries
+func GetConfigHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, cfg, content, ok := loadConfig(c, db)
+		if !ok {
+			return
+		}
+
+		parsed := parseConfig(cfg.Format, string(content))
+		c.JSON(http.StatusOK, gin.H{
+			"name":    cfg.Name,
+			"path":    cfg.Path,
+			"format":  cfg.Format,
+			"hash":    contentHash(content),
+			"entries": parsed.Entries(),
+		})
+	}
+}
+
+// UpdateConfigHandler applies a partial update to a known config. Only the
+// changed values are rewritten; comments, ordering and formatting elsewhere
+// in the file are preserved.
+func UpdateConfigHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Values       map[string]interface{} `json:"values" binding:"required"`
+			ExpectedHash string                 `json:"expected_hash"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		client, cfg, content, ok := loadConfig(c, db)
+		if !ok {
+			return
+		}
+		if req.ExpectedHash != "" && req.ExpectedHash != contentHash(content) {
+			c.JSON(http.StatusConflict, gin.H{"error": "File was modified since it was opened", "hash": contentHash(content)})
+			return
+		}
+
+		parsed := parseConfig(cfg.Format, string(content))
+		if problems := applyConfigUpdates(parsed, cfg.Name, req.Values); len(problems) > 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Some values are invalid", "problems": problems})
+			return
+		}
+
+		updated := []byte(parsed.String())
+		if err := writeFileWithHistory(db, client, c.Param("id"), cfg.Path, updated, CurrentUsername(c, db)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Config saved",
+			"name":    cfg.Name,
+			"path":    cfg.Path,
+			"format":  cfg.Format,
+			"hash":    contentHash(updated),
+			"entries": parseConfig(cfg.Format, string(updated)).Entries(),
+		})
+	}
+}
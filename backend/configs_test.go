@@ -0,0 +1,250 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testProperties = `#Minecraft server properties
+#Fri Jan 01 00:00:00 UTC 2021
+
+# the port players connect to
+server-port=25565
+motd=A Minecraft Server \u00A7a!
+level-seed=007
+pvp=true
+`
+
+const testYAML = `# This is the main configuration file for Bukkit.
+settings:
+  # whether the end is generated
+  allow-end: true   # keep the end
+  connection-throttle: 4000
+  shutdown-message: 'Server closed'
+spawn-limits:
+  monsters: 70
+  animals: 10
+aliases: now-in-commands.yml
+worlds:
+  - world
+  - world_nether
+`
+
+const testTOML = `# Config version. Do not change this
+config-version = "2.6"
+
+# What port should the proxy be bound to?
+bind = "0.0.0.0:25577" # default
+
+show-max-players = 500
+online-mode = true
+
+[servers]
+# Configure your servers here.
+lobby = "127.0.0.1:30066"
+try = [
+  "lobby"
+]
+
+[query]
+enabled = false
+port = 25577
+`
+
+func TestParseConfigRoundTrip(t *testing.T) {
+	tests := []struct {
+		format, content string
+	}{
+		{"properties", testProperties},
+		{"yaml", testYAML},
+		{"toml", testTOML},
+		{"properties", ""},
+		{"yaml", "a: 1\r\nb: two\r\n"},
+	}
+
+	for _, tt := range tests {
+		if got := parseConfig(tt.format, tt.content).String(); got != tt.content {
+			t.Errorf("%s round trip changed the file:\n%s\nwant:\n%s", tt.format, got, tt.content)
+		}
+	}
+}
+
+func TestParseConfigEntries(t *testing.T) {
+	tests := []struct {
+		format, content string
+		key             string
+		value           interface{}
+		typ             string
+		comment         string
+	}{
+		{"properties", testProperties, "server-port", int64(25565), "int", "the port players connect to"},
+		{"properties", testProperties, "motd", "A Minecraft Server §a!", "string", ""},
+		{"properties", testProperties, "level-seed", int64(7), "int", ""},
+		{"yaml", testYAML, "settings.allow-end", true, "bool", "whether the end is generated"},
+		{"yaml", testYAML, "settings.shutdown-message", "Server closed", "string", ""},
+		{"yaml", testYAML, "spawn-limits.animals", int64(10), "int", ""},
+		{"yaml", testYAML, "worlds", []interface{}{"world", "world_nether"}, "list", ""},
+		{"toml", testTOML, "bind", "0.0.0.0:25577", "string", "What port should the proxy be bound to?"},
+		{"toml", testTOML, "servers.try", []interface{}{"lobby"}, "list", ""},
+		{"toml", testTOML, "query.enabled", false, "bool", ""},
+	}
+
+	for _, tt := range tests {
+		entry, ok := parseConfig(tt.format, tt.content).byKey[tt.key]
+		if !ok {
+			t.Errorf("%s: %s not found", tt.format, tt.key)
+			continue
+		}
+		if !reflect.DeepEqual(entry.Value, tt.value) || entry.Type != tt.typ || entry.Comment != tt.comment {
+			t.Errorf("%s: %s = %#v (%s, %q); want %#v (%s, %q)",
+				tt.format, tt.key, entry.Value, entry.Type, entry.Comment, tt.value, tt.typ, tt.comment)
+		}
+	}
+}
+
+func TestApplyConfigUpdatesKeepsComments(t *testing.T) {
+	tests := []struct {
+		name, format, file, content string
+		updates                     map[string]interface{}
+		want                        string
+	}{
+		{
+			name:    "properties value",
+			format:  "properties",
+			file:    "server.properties",
+			content: testProperties,
+			updates: map[string]interface{}{"server-port": float64(25566), "pvp": false},
+			want: `#Minecraft server properties
+#Fri Jan 01 00:00:00 UTC 2021
+
+# the port players connect to
+server-port=25566
+motd=A Minecraft Server \u00A7a!
+level-seed=007
+pvp=false
+`,
+		},
+		{
+			name:    "properties text is kept as given",
+			format:  "properties",
+			file:    "server.properties",
+			content: testProperties,
+			updates: map[string]interface{}{"level-seed": "0042", "white-list": true},
+			want: `#Minecraft server properties
+#Fri Jan 01 00:00:00 UTC 2021
+
+# the port players connect to
+server-port=25565
+motd=A Minecraft Server \u00A7a!
+level-seed=0042
+pvp=true
+white-list=true
+`,
+		},
+		{
+			name:    "yaml scalar with trailing comment",
+			format:  "yaml",
+			file:    "bukkit.yml",
+			content: testYAML,
+			updates: map[string]interface{}{"settings.allow-end": false, "spawn-limits.monsters": float64(50)},
+			want: `# This is the main configuration file for Bukkit.
+settings:
+  # whether the end is generated
+  allow-end: false   # keep the end
+  connection-throttle: 4000
+  shutdown-message: 'Server closed'
+spawn-limits:
+  monsters: 50
+  animals: 10
+aliases: now-in-commands.yml
+worlds:
+  - world
+  - world_nether
+`,
+		},
+		{
+			name:    "yaml block list",
+			format:  "yaml",
+			file:    "bukkit.yml",
+			content: testYAML,
+			updates: map[string]interface{}{"worlds": []interface{}{"world", "world_nether", "world_the_end"}},
+			want: `# This is the main configuration file for Bukkit.
+settings:
+  # whether the end is generated
+  allow-end: true   # keep the end
+  connection-throttle: 4000
+  shutdown-message: 'Server closed'
+spawn-limits:
+  monsters: 70
+  animals: 10
+aliases: now-in-commands.yml
+worlds:
+  - world
+  - world_nether
+  - world_the_end
+`,
+		},
+		{
+			name:    "toml values",
+			format:  "toml",
+			file:    "velocity.toml",
+			content: testTOML,
+			updates: map[string]interface{}{"bind": "0.0.0.0:25578", "servers.try": []interface{}{"lobby", "survival"}, "query.enabled": true},
+			want: `# Config version. Do not change this
+config-version = "2.6"
+
+# What port should the proxy be bound to?
+bind = "0.0.0.0:25578" # default
+
+show-max-players = 500
+online-mode = true
+
+[servers]
+# Configure your servers here.
+lobby = "127.0.0.1:30066"
+try = ["lobby", "survival"]
+
+[query]
+enabled = true
+port = 25577
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		cfg := parseConfig(tt.format, tt.content)
+		if problems := applyConfigUpdates(cfg, tt.file, tt.updates); len(problems) > 0 {
+			t.Errorf("%s: unexpected problems %v", tt.name, problems)
+			continue
+		}
+		if got := cfg.String(); got != tt.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyConfigUpdatesRejects(t *testing.T) {
+	tests := []struct {
+		format, file, content string
+		key                   string
+		value                 interface{}
+	}{
+		{"properties", "server.properties", testProperties, "server-port", float64(70000)},
+		{"properties", "server.properties", testProperties, "pvp", "maybe"},
+		{"properties", "server.properties", testProperties, "motd", "two\nlines"},
+		{"yaml", "bukkit.yml", testYAML, "settings.allow-end", "yes please"},
+		{"yaml", "bukkit.yml", testYAML, "settings.missing", true},
+		{"toml", "velocity.toml", testTOML, "bind", "no port"},
+	}
+
+	for _, tt := range tests {
+		cfg := parseConfig(tt.format, tt.content)
+		problems := applyConfigUpdates(cfg, tt.file, map[string]interface{}{tt.key: tt.value})
+		if _, ok := problems[tt.key]; !ok {
+			t.Errorf("%s: %s = %#v was accepted", tt.file, tt.key, tt.value)
+		}
+		if got := cfg.String(); got != tt.content {
+			t.Errorf("%s: rejected update changed the file:\n%s", tt.file, got)
+		}
+	}
+}
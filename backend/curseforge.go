@@ -0,0 +1,456 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The API is reached at curseforge_api_url when set, so a local stand-in can
+// be used instead of the real service
+const defaultCurseForgeAPIURL = "https://api.curseforge.com"
+
+const curseForgeGameID = 432 // Minecraft
+
+// CurseForge class ids
+const (
+	curseForgeClassPlugins  = 5
+	curseForgeClassMods     = 6
+	curseForgeClassModpacks = 4471
+)
+
+// CurseForge modLoaderType values
+var curseForgeLoaderTypes = map[string]int{
+	PlatformForge:    1,
+	PlatformFabric:   4,
+	PlatformQuilt:    5,
+	PlatformNeoForge: 6,
+}
+
+// CurseForge relationType values
+var curseForgeRelations = map[int]string{
+	1: DependencyEmbedded,
+	2: DependencyOptional,
+	3: DependencyRequired,
+	4: DependencyOptional, // tool
+	5: DependencyIncompatible,
+	6: DependencyEmbedded, // include
+}
+
+type curseForgeFile struct {
+	ID          int    `json:"id"`
+	DisplayName string `json:"displayName"`
+	FileName    string `json:"fileName"`
+	ReleaseType int    `json:"releaseType"`
+	DownloadURL string `json:"downloadUrl"`
+	FileLength  int64  `json:"fileLength"`
+	Hashes      []struct {
+		Value string `json:"value"`
+		Algo  int    `json:"algo"`
+	} `json:"hashes"`
+	GameVersions []string `json:"gameVersions"`
+	Dependencies []struct {
+		ModID        int `json:"modId"`
+		RelationType int `json:"relationType"`
+	} `json:"dependencies"`
+	ServerPackFileID int `json:"serverPackFileId"`
+}
+
+// curseForgeGet calls the CurseForge API with the key from settings and
+// decodes the response into v
+func curseForgeGet(db *sql.DB, endpoint string, v interface{}) error {
+	key, _ := GetSetting(db, "curseforge_api_key")
+	if key == "" {
+		return fmt.Errorf("CurseForge API key not configured: %w", errSourceNotConfigured)
+	}
+	base, _ := GetSetting(db, "curseforge_api_url")
+	if base == "" {
+		base = defaultCurseForgeAPIURL
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(base, "/")+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-api-key", key)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := sourceDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusNotFound:
+		return fmt.Errorf("CurseForge: %w", errNotFound)
+	case http.StatusForbidden:
+		return fmt.Errorf("CurseForge rejected the API key")
+	}
+	return sourceStatusError(resp.StatusCode)
+}
+
+// CurseForge sortField values by search sort. CurseForge has no relevance
+// order; popularity is closest.
+var curseForgeSorts = map[string]string{
+	SortRelevance: "2",
+	SortDownloads: "6",
+	SortUpdated:   "3",
+}
+
+// searchCurseForge searches one CurseForge class. Categories are
+// CurseForge's numeric category ids.
+func searchCurseForge(db *sql.DB, q SearchQuery, classID int, loaders []string) (*SearchPage, error) {
+	params := url.Values{}
+	params.Set("gameId", strconv.Itoa(curseForgeGameID))
+	params.Set("classId", strconv.Itoa(classID))
+	params.Set("searchFilter", q.Text)
+	params.Set("sortField", curseForgeSorts[SortRelevance])
+	if sort := curseForgeSorts[q.Sort]; sort != "" {
+		params.Set("sortField", sort)
+	}
+	params.Set("sortOrder", "desc")
+	params.Set("index", strconv.Itoa(q.Offset))
+	params.Set("pageSize", strconv.Itoa(min(q.Limit, 50)))
+	if q.Version != "" {
+		params.Set("gameVersion", q.Version)
+	}
+	for _, l := range loaders {
+		if t, ok := curseForgeLoaderTypes[l]; ok {
+			params.Set("modLoaderType", strconv.Itoa(t))
+			break
+		}
+	}
+	if len(q.Categories) > 0 {
+		ids := make([]int, len(q.Categories))
+		for i, category := range q.Categories {
+			id, err := strconv.Atoi(category)
+			if err != nil {
+				return nil, fmt.Errorf("category %q is not a CurseForge category id", category)
+			}
+			ids[i] = id
+		}
+		encoded, _ := json.Marshal(ids)
+		params.Set("categoryIds", string(encoded))
+	}
+
+	var data struct {
+		Data []struct {
+			ID            int     `json:"id"`
+			Name          string  `json:"name"`
+			Summary       string  `json:"summary"`
+			DownloadCount float64 `json:"downloadCount"`
+			Logo          struct {
+				URL string `json:"url"`
+			} `json:"logo"`
+			Categories []struct {
+				Name string `json:"name"`
+			} `json:"categories"`
+			DateModified string `json:"dateModified"`
+			LatestFiles  []struct {
+				Hashes []struct {
+					Value string `json:"value"`
+					Algo  int    `json:"algo"`
+				} `json:"hashes"`
+			} `json:"latestFiles"`
+		} `json:"data"`
+		Pagination struct {
+			TotalCount int `json:"totalCount"`
+		} `json:"pagination"`
+	}
+	if err := curseForgeGet(db, "/v1/mods/search?"+params.Encode(), &data); err != nil {
+		return nil, err
+	}
+
+	page := &SearchPage{Results: []PluginResult{}, Total: data.Pagination.TotalCount}
+	for _, p := range data.Data {
+		var categories []string
+		for _, c := range p.Categories {
+			categories = append(categories, c.Name)
+		}
+		var hashes []string
+		for _, f := range p.LatestFiles {
+			for _, h := range f.Hashes {
+				if h.Algo == 1 { // sha1
+					hashes = append(hashes, "sha1:"+h.Value)
+				}
+			}
+		}
+		page.Results = append(page.Results, PluginResult{
+			Name:        p.Name,
+			Description: p.Summary,
+			Downloads:   int(p.DownloadCount),
+			Source:      "curseforge",
+			Slug:        strconv.Itoa(p.ID),
+			IconURL:     p.Logo.URL,
+			Categories:  categories,
+			UpdatedAt:   p.DateModified,
+
+			fileHashes: hashes,
+		})
+	}
+	return page, nil
+}
+
+// curseForgeModName looks up the name of a CurseForge project by id
+func curseForgeModName(db *sql.DB, id string) (string, error) {
+	var data struct {
+		Data struct {
+			Name string `json:"name"`
+		} `json:"data"`
+	}
+	if err := curseForgeGet(db, "/v1/mods/"+url.PathEscape(id), &data); err != nil {
+		return "", err
+	}
+	return data.Data.Name, nil
+}
+
+func curseForgeChannel(releaseType int) string {
+	switch releaseType {
+	case 2:
+		return ChannelBeta
+	case 3:
+		return ChannelAlpha
+	}
+	return ChannelRelease
+}
+
+// curseForgeArtifact turns a file into an artifact. CurseForge mixes game
+// versions, loaders and environments in one gameVersions list.
+func curseForgeArtifact(f curseForgeFile) PluginArtifact {
+	a := PluginArtifact{
+		Source:   "curseforge",
+		Version:  f.DisplayName,
+		Channel:  curseForgeChannel(f.ReleaseType),
+		URL:      f.DownloadURL,
+		FileName: f.FileName,
+		Hashes:   map[string]string{},
+	}
+	if a.Version == "" {
+		a.Version = f.FileName
+	}
+	for _, h := range f.Hashes {
+		switch h.Algo {
+		case 1:
+			a.Hashes["sha1"] = h.Value
+		case 2:
+			a.Hashes["md5"] = h.Value
+		}
+	}
+
+	client, server := false, false
+	for _, v := range f.GameVersions {
+		lower := strings.ToLower(v)
+		switch {
+		case lower == "client":
+			client = true
+		case lower == "server":
+			server = true
+		case curseForgeLoaderTypes[strings.ReplaceAll(lower, " ", "")] != 0:
+			a.Loaders = append(a.Loaders, strings.ReplaceAll(lower, " ", ""))
+		default:
+			if _, ok := parseGameVersion(v); ok {
+				a.GameVersions = append(a.GameVersions, v)
+			}
+		}
+	}
+	a.ClientOnly = client && !server
+
+	for _, d := range f.Dependencies {
+		id := strconv.Itoa(d.ModID)
+		a.Dependencies = append(a.Dependencies, PluginDependency{
+			Source: "curseforge",
+			Slug:   id,
+			Name:   id,
+			Type:   curseForgeRelations[d.RelationType],
+		})
+	}
+	return a
+}
+
+// curseForgeFiles lists a project's files, newest first
+func curseForgeFiles(db *sql.DB, id string) ([]curseForgeFile, error) {
+	var data struct {
+		Data []curseForgeFile `json:"data"`
+	}
+	if err := curseForgeGet(db, "/v1/mods/"+url.PathEscape(id)+"/files?pageSize=50", &data); err != nil {
+		return nil, fmt.Errorf("curseforge project %s: %w", id, err)
+	}
+	return data.Data, nil
+}
+
+// curseForgeArtifacts lists the downloadable files of a project. Files whose
+// author turned off third-party downloads have no URL and are left out.
+func curseForgeArtifacts(db *sql.DB, id string) ([]PluginArtifact, error) {
+	files, err := curseForgeFiles(db, id)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []PluginArtifact
+	for _, f := range files {
+		if f.DownloadURL == "" {
+			continue
+		}
+		candidates = append(candidates, curseForgeArtifact(f))
+	}
+	if len(candidates) == 0 && len(files) > 0 {
+		return nil, fmt.Errorf("the author of curseforge project %s does not allow downloads outside CurseForge", id)
+	}
+	return candidates, nil
+}
+
+// curseForgeModLoader reads the primary mod loader out of a CurseForge pack
+// manifest, e.g. "forge-47.2.0"
+func curseForgeModLoader(id string) (string, string) {
+	name, version, _ := strings.Cut(id, "-")
+	switch strings.ToLower(name) {
+	case "forge":
+		return "forge", version
+	case "neoforge":
+		return "neoforge", version
+	case "fabric":
+		return "fabric-loader", version
+	case "quilt":
+		return "quilt-loader", version
+	}
+	return "", ""
+}
+
+// openCurseForgeModpack builds a modpack from a CurseForge modpack file. The
+// client pack's manifest names the game and loader versions; the server pack
+// the file links to holds everything that goes onto the server and is applied
+// like overrides. Both are downloaded to temporary files; the server pack's
+// is kept until the returned pack is closed.
+func openCurseForgeModpack(db *sql.DB, id, version string, limit int64) (*modpack, error) {
+	files, err := curseForgeFiles(db, id)
+	if err != nil {
+		return nil, err
+	}
+	var pick *curseForgeFile
+	for i := range files {
+		f := &files[i]
+		if version != "" && f.DisplayName != version && f.FileName != version {
+			continue
+		}
+		if pick == nil || channelRank(curseForgeChannel(f.ReleaseType)) < channelRank(curseForgeChannel(pick.ReleaseType)) {
+			pick = f
+		}
+	}
+	if pick == nil {
+		return nil, fmt.Errorf("no matching modpack file found")
+	}
+	if pick.DownloadURL == "" {
+		return nil, fmt.Errorf("the pack author does not allow downloads outside CurseForge")
+	}
+	if pick.ServerPackFileID == 0 {
+		return nil, fmt.Errorf("%s has no server pack", pick.DisplayName)
+	}
+
+	packFile := curseForgeArtifact(*pick)
+	packFile.Slug = id
+	clientPack, size, err := downloadVerifiedFile(packFile, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer removeTempFile(clientPack)
+	zr, err := zip.NewReader(clientPack, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid modpack: %v", err)
+	}
+	var manifest struct {
+		Name      string `json:"name"`
+		Version   string `json:"version"`
+		Minecraft struct {
+			Version    string `json:"version"`
+			ModLoaders []struct {
+				ID      string `json:"id"`
+				Primary bool   `json:"primary"`
+			} `json:"modLoaders"`
+		} `json:"minecraft"`
+	}
+	for _, f := range zr.File {
+		if f.Name != "manifest.json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(io.LimitReader(rc, 16<<20)).Decode(&manifest)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest.json: %v", err)
+		}
+	}
+
+	pack := &modpack{
+		Index: mrpackIndex{
+			Name:         manifest.Name,
+			VersionID:    manifest.Version,
+			Dependencies: map[string]string{"minecraft": manifest.Minecraft.Version},
+		},
+		Overrides: map[string]*zip.File{},
+	}
+	for _, l := range manifest.Minecraft.ModLoaders {
+		if key, v := curseForgeModLoader(l.ID); key != "" && (l.Primary || len(manifest.Minecraft.ModLoaders) == 1) {
+			pack.Index.Dependencies[key] = v
+		}
+	}
+
+	var serverFile struct {
+		Data curseForgeFile `json:"data"`
+	}
+	if err := curseForgeGet(db, fmt.Sprintf("/v1/mods/%s/files/%d", url.PathEscape(id), pick.ServerPackFileID), &serverFile); err != nil {
+		return nil, fmt.Errorf("server pack: %v", err)
+	}
+	if serverFile.Data.DownloadURL == "" {
+		return nil, fmt.Errorf("the pack author does not allow server pack downloads outside CurseForge")
+	}
+	serverPack, size, err := downloadVerifiedFile(curseForgeArtifact(serverFile.Data), limit)
+	if err != nil {
+		return nil, fmt.Errorf("server pack: %v", err)
+	}
+	zr, err = zip.NewReader(serverPack, size)
+	if err != nil {
+		removeTempFile(serverPack)
+		return nil, fmt.Errorf("server pack is not a valid zip: %v", err)
+	}
+	pack.serverPack = serverPack
+
+	// Server packs are often wrapped in a single top-level folder
+	prefix := ""
+	for i, f := range zr.File {
+		top, _, nested := strings.Cut(f.Name, "/")
+		if !nested {
+			prefix = ""
+			break
+		}
+		if i == 0 {
+			prefix = top + "/"
+		} else if top+"/" != prefix {
+			prefix = ""
+			break
+		}
+	}
+	// The pack can only be extracted as it is when every entry lands where
+	// its name says
+	pack.repackServerPack = prefix != ""
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if p, err := normalizeServerPath(strings.TrimPrefix(f.Name, prefix)); err == nil && p != "/" {
+			pack.Overrides[p] = f
+		} else {
+			pack.repackServerPack = true
+		}
+	}
+	return pack, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testDB opens a fresh database in a temporary directory
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	db := InitDB()
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// fakeCurseForge serves the parts of the CurseForge API PanelManager uses,
+// and the files it links to
+func fakeCurseForge(t *testing.T) *sql.DB {
+	t.Helper()
+	clientPack := testJar(t, map[string]string{
+		"manifest.json":                `{"name":"Test Pack","version":"1.0","minecraft":{"version":"1.20.1","modLoaders":[{"id":"forge-47.2.0","primary":true}]}}`,
+		"overrides/config/client.toml": "client = true",
+	})
+	clientSum := sha1.Sum(clientPack)
+	downloads := map[string][]byte{
+		"/download/client.zip": clientPack,
+		"/download/wrapped.zip": testJar(t, map[string]string{
+			"Server-1.0/config/server.toml": "motd = 'hi'",
+			"Server-1.0/mods/example.jar":   "PK",
+		}),
+		"/download/flat.zip": testJar(t, map[string]string{
+			"config/server.toml": "motd = 'hi'",
+			"mods/example.jar":   "PK",
+		}),
+	}
+
+	var srv *httptest.Server
+	api := map[string]string{
+		"/v1/mods/search": `{"data":[{"id":100,"name":"Example Mod","summary":"Does things","downloadCount":1234,
+			"logo":{"url":"https://example.com/logo.png"},"categories":[{"name":"Utility"}],"dateModified":"2024-01-01T00:00:00Z",
+			"latestFiles":[{"hashes":[{"value":"aaaa","algo":1},{"value":"bbbb","algo":2}]}]}],"pagination":{"totalCount":1}}`,
+		"/v1/mods/100/files": `{"data":[
+			{"id":14,"displayName":"example-0.8","fileName":"example-0.8.jar","releaseType":1,"downloadUrl":"","gameVersions":["1.21.4","Fabric"]},
+			{"id":13,"displayName":"example-0.7-beta","fileName":"example-0.7-beta.jar","releaseType":2,"downloadUrl":"URL/download/a.jar","gameVersions":["1.21.4","Fabric"]},
+			{"id":12,"displayName":"example-0.6-forge","fileName":"example-0.6-forge.jar","releaseType":1,"downloadUrl":"URL/download/b.jar","gameVersions":["1.21.4","Forge","Server"]},
+			{"id":11,"displayName":"example-0.6-fabric","fileName":"example-0.6-fabric.jar","releaseType":1,"downloadUrl":"URL/download/c.jar",
+				"hashes":[{"value":"cccc","algo":1}],"gameVersions":["1.21.4","Fabric","Server","Client"],
+				"dependencies":[{"modId":200,"relationType":3},{"modId":300,"relationType":2},{"modId":400,"relationType":1}]},
+			{"id":10,"displayName":"example-0.5-fabric","fileName":"example-0.5-fabric.jar","releaseType":1,"downloadUrl":"URL/download/d.jar","gameVersions":["1.20.1","Fabric"]}]}`,
+		"/v1/mods/101/files": `{"data":[{"id":1,"displayName":"only-client","fileName":"only-client.jar","releaseType":1,"downloadUrl":"","gameVersions":["1.21.4"]}]}`,
+		"/v1/mods/200":       `{"data":{"id":200,"name":"Fabric API"}}`,
+		"/v1/mods/500/files": `{"data":[{"id":50,"displayName":"Test Pack 1.0","fileName":"pack.zip","releaseType":1,"downloadUrl":"URL/download/client.zip",
+			"hashes":[{"value":"` + hex.EncodeToString(clientSum[:]) + `","algo":1}],"serverPackFileId":51}]}`,
+		"/v1/mods/500/files/51": `{"data":{"id":51,"displayName":"Server 1.0","fileName":"server.zip","downloadUrl":"URL/download/wrapped.zip"}}`,
+		"/v1/mods/501/files": `{"data":[{"id":60,"displayName":"Test Pack 1.0","fileName":"pack.zip","releaseType":1,"downloadUrl":"URL/download/client.zip",
+			"serverPackFileId":61}]}`,
+		"/v1/mods/501/files/61": `{"data":{"id":61,"displayName":"Server 1.0","fileName":"server.zip","downloadUrl":"URL/download/flat.zip"}}`,
+		"/v1/mods/502/files":    `{"data":[{"id":70,"displayName":"Broken","fileName":"pack.zip","releaseType":1,"downloadUrl":"URL/download/client.zip","hashes":[{"value":"0000000000000000000000000000000000000000","algo":1}],"serverPackFileId":51}]}`,
+	}
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if content, ok := downloads[r.URL.Path]; ok {
+			w.Write(content)
+			return
+		}
+		if r.Header.Get("x-api-key") != "test-key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, ok := api[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == "/v1/mods/search" {
+			q := r.URL.Query()
+			if q.Get("classId") != "6" || q.Get("modLoaderType") != "4" || q.Get("gameVersion") != "1.21.4" || q.Get("searchFilter") != "example" {
+				t.Errorf("unexpected search parameters %s", r.URL.RawQuery)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, strings.ReplaceAll(body, "URL/", srv.URL+"/"))
+	}))
+	t.Cleanup(srv.Close)
+
+	db := testDB(t)
+	SetSetting(db, "curseforge_api_key", "test-key")
+	SetSetting(db, "curseforge_api_url", srv.URL)
+	return db
+}
+
+func TestCurseForgeSearch(t *testing.T) {
+	db := fakeCurseForge(t)
+	source := curseForgeSource{db: db}
+
+	page, err := source.Search(SearchQuery{
+		Text:    "example",
+		Kind:    KindMod,
+		Version: "1.21.4",
+		Target:  &ServerPlatform{Platform: PlatformFabric, Version: "1.21.4"},
+		Limit:   20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Results) != 1 {
+		t.Fatalf("got %d of %d results, want 1 of 1", len(page.Results), page.Total)
+	}
+	r := page.Results[0]
+	if r.Name != "Example Mod" || r.Slug != "100" || r.Source != "curseforge" || r.Downloads != 1234 ||
+		!reflect.DeepEqual(r.Categories, []string{"Utility"}) || !reflect.DeepEqual(r.fileHashes, []string{"sha1:aaaa"}) {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestCurseForgeResolve(t *testing.T) {
+	db := fakeCurseForge(t)
+	source := curseForgeSource{db: db}
+
+	tests := []struct {
+		target  ServerPlatform
+		version string
+		want    string
+	}{
+		{ServerPlatform{Platform: PlatformFabric, Version: "1.21.4"}, "", "example-0.6-fabric"},
+		{ServerPlatform{Platform: PlatformQuilt, Version: "1.21.4"}, "", "example-0.6-fabric"},
+		{ServerPlatform{Platform: PlatformForge, Version: "1.21.4"}, "", "example-0.6-forge"},
+		{ServerPlatform{Platform: PlatformFabric, Version: "1.20.1"}, "", "example-0.5-fabric"},
+		{ServerPlatform{Platform: PlatformFabric, Version: "1.21.4"}, "example-0.7-beta", "example-0.7-beta"},
+	}
+	for _, tt := range tests {
+		target := tt.target
+		a, err := source.Resolve("100", tt.version, &target)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.target.Platform, tt.target.Version, err)
+			continue
+		}
+		if a.Version != tt.want || a.Source != "curseforge" || a.Slug != "100" {
+			t.Errorf("%s %s: got %s, want %s", tt.target.Platform, tt.target.Version, a.Version, tt.want)
+		}
+	}
+
+	a, err := source.Resolve("100", "", &ServerPlatform{Platform: PlatformFabric, Version: "1.21.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Hashes["sha1"] != "cccc" || a.ClientOnly || !reflect.DeepEqual(a.GameVersions, []string{"1.21.4"}) ||
+		!reflect.DeepEqual(a.Loaders, []string{"fabric"}) || !strings.HasSuffix(a.URL, "/download/c.jar") {
+		t.Errorf("unexpected artifact %+v", a)
+	}
+
+	if _, err := source.Resolve("101", "", &ServerPlatform{Platform: PlatformFabric, Version: "1.21.4"}); err == nil ||
+		!strings.Contains(err.Error(), "does not allow downloads") {
+		t.Errorf("project without downloads: got %v", err)
+	}
+	if _, err := source.Resolve("999", "", &ServerPlatform{Platform: PlatformFabric}); !errors.Is(err, errNotFound) {
+		t.Errorf("missing project: got %v, want errNotFound", err)
+	}
+}
+
+func TestCurseForgeDependencies(t *testing.T) {
+	db := fakeCurseForge(t)
+	source := curseForgeSource{db: db}
+
+	a, err := source.Resolve("100", "", &ServerPlatform{Platform: PlatformFabric, Version: "1.21.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps, err := source.Dependencies(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PluginDependency{
+		{Source: "curseforge", Slug: "200", Name: "Fabric API", Type: DependencyRequired},
+		{Source: "curseforge", Slug: "300", Name: "300", Type: DependencyOptional},
+		{Source: "curseforge", Slug: "400", Name: "400", Type: DependencyEmbedded},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("got %+v, want %+v", deps, want)
+	}
+}
+
+func TestCurseForgeModpack(t *testing.T) {
+	db := fakeCurseForge(t)
+
+	tests := []struct {
+		id     string
+		repack bool
+	}{
+		{"500", true},  // server pack wrapped in a folder
+		{"501", false}, // server pack that can be extracted as it is
+	}
+	for _, tt := range tests {
+		pack, err := openCurseForgeModpack(db, tt.id, "", 1<<20)
+		if err != nil {
+			t.Errorf("%s: %v", tt.id, err)
+			continue
+		}
+		if pack.Index.Name != "Test Pack" || pack.GameVersion() != "1.20.1" {
+			t.Errorf("%s: got %q for %s", tt.id, pack.Index.Name, pack.GameVersion())
+		}
+		if loader, version := pack.Loader(); loader != PlatformForge || version != "47.2.0" {
+			t.Errorf("%s: loader %s %s, want forge 47.2.0", tt.id, loader, version)
+		}
+		var paths []string
+		for p := range pack.Overrides {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		if !reflect.DeepEqual(paths, []string{"/config/server.toml", "/mods/example.jar"}) {
+			t.Errorf("%s: overrides %v", tt.id, paths)
+		}
+		if pack.repackServerPack != tt.repack {
+			t.Errorf("%s: repack = %v, want %v", tt.id, pack.repackServerPack, tt.repack)
+		}
+
+		// The overrides zip must hold the entries under their server paths
+		var buf bytes.Buffer
+		if err := writeOverridesZip(&buf, pack.Overrides); err != nil {
+			t.Fatalf("%s: %v", tt.id, err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.id, err)
+		}
+		got := map[string]string{}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			got[f.Name] = string(data)
+		}
+		if !reflect.DeepEqual(got, map[string]string{"config/server.toml": "motd = 'hi'", "mods/example.jar": "PK"}) {
+			t.Errorf("%s: repacked overrides %v", tt.id, got)
+		}
+
+		temp := pack.serverPack.Name()
+		pack.Close()
+		if _, err := os.Stat(temp); !os.IsNotExist(err) {
+			t.Errorf("%s: server pack %s was not removed", tt.id, temp)
+		}
+	}
+
+	if _, err := openCurseForgeModpack(db, "502", "", 1<<20); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("pack with a bad hash: got %v", err)
+	}
+}
+
+func TestCurseForgeRequiresKey(t *testing.T) {
+	db := fakeCurseForge(t)
+	SetSetting(db, "curseforge_api_key", "wrong")
+	if _, err := curseForgeFiles(db, "100"); err == nil || !strings.Contains(err.Error(), "rejected the API key") {
+		t.Errorf("got %v", err)
+	}
+	var unused json.RawMessage
+	db.Exec("DELETE FROM settings WHERE key = 'curseforge_api_key'")
+	if err := curseForgeGet(db, "/v1/mods/100", &unused); err == nil {
+		t.Error("expected an error without an API key")
+	}
+}
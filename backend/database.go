@@ -3,6 +3,8 @@ package main
 import (
 	"database/sql"
 	"log"
+	"strconv"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -40,8 +42,108 @@ func InitDB() *sql.DB {
 		plugin_name TEXT NOT NULL,
 		plugin_version TEXT NOT NULL,
 		source TEXT NOT NULL,
+		file_name TEXT NOT NULL DEFAULT '',
+		file_hash TEXT NOT NULL DEFAULT '',
 		installed_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
+
+	CREATE TABLE IF NOT EXISTS plugin_updates (
+		server_id TEXT NOT NULL,
+		plugin_name TEXT NOT NULL,
+		source TEXT NOT NULL,
+		current_version TEXT NOT NULL,
+		latest_version TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		checked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (server_id, plugin_name, source)
+	);
+
+	CREATE TABLE IF NOT EXISTS plugin_dependencies (
+		server_id TEXT NOT NULL,
+		plugin_name TEXT NOT NULL,
+		source TEXT NOT NULL,
+		depends_on TEXT NOT NULL,
+		depends_on_source TEXT NOT NULL,
+		required BOOLEAN NOT NULL,
+		PRIMARY KEY (server_id, plugin_name, source, depends_on, depends_on_source)
+	);
+
+	CREATE TABLE IF NOT EXISTS installed_mods (
+		server_id TEXT NOT NULL,
+		source TEXT NOT NULL DEFAULT 'modrinth',
+		slug TEXT NOT NULL,
+		title TEXT NOT NULL,
+		version TEXT NOT NULL,
+		loader TEXT NOT NULL,
+		file_name TEXT NOT NULL,
+		file_hash TEXT NOT NULL,
+		client_side TEXT NOT NULL DEFAULT '',
+		server_side TEXT NOT NULL DEFAULT '',
+		installed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (server_id, slug)
+	);
+
+	CREATE TABLE IF NOT EXISTS file_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id TEXT NOT NULL,
+		path TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		content BLOB NOT NULL,
+		replaced_by TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_file_revisions_path ON file_revisions (server_id, path);
+
+	CREATE TABLE IF NOT EXISTS artifact_cache (
+		source TEXT NOT NULL,
+		slug TEXT NOT NULL,
+		version TEXT NOT NULL,
+		file_name TEXT NOT NULL,
+		sha256 TEXT NOT NULL,
+		sha1 TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		cached_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+		game_versions TEXT NOT NULL DEFAULT '',
+		loaders TEXT NOT NULL DEFAULT '',
+		client_only INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (source, slug, version, sha256)
+	);
+	CREATE INDEX IF NOT EXISTS idx_artifact_cache_sha256 ON artifact_cache (sha256);
+	CREATE INDEX IF NOT EXISTS idx_artifact_cache_sha1 ON artifact_cache (sha1);
+
+	CREATE TABLE IF NOT EXISTS plugin_bundles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		version INTEGER NOT NULL DEFAULT 1,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS plugin_bundle_plugins (
+		bundle_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		source TEXT NOT NULL,
+		slug TEXT NOT NULL,
+		version TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (bundle_id, source, slug)
+	);
+
+	CREATE TABLE IF NOT EXISTS plugin_bundle_files (
+		bundle_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		content TEXT NOT NULL,
+		PRIMARY KEY (bundle_id, path)
+	);
+
+	CREATE TABLE IF NOT EXISTS server_bundles (
+		server_id TEXT NOT NULL,
+		bundle_id INTEGER NOT NULL,
+		bundle_version INTEGER NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (server_id, bundle_id)
+	);
 	`
 
 	_, err = db.Exec(schema)
@@ -49,6 +151,18 @@ func InitDB() *sql.DB {
 		log.Fatal(err)
 	}
 
+	// Columns added after installed_plugins was first created. SQLite has no
+	// IF NOT EXISTS for these, so "duplicate column" errors are expected.
+	migrations := []string{
+		"ALTER TABLE installed_plugins ADD COLUMN file_name TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE installed_plugins ADD COLUMN file_hash TEXT NOT NULL DEFAULT ''",
+	}
+	for _, m := range migrations {
+		if _, err := db.Exec(m); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			log.Fatal(err)
+		}
+	}
+
 	return db
 }
 
@@ -68,3 +182,16 @@ func HasAdmin(db *sql.DB) bool {
 	db.QueryRow("SELECT COUNT(*) FROM users WHERE is_admin = 1").Scan(&count)
 	return count > 0
 }
+
+// GetSettingInt reads a numeric setting, falling back to def when it is unset or invalid
+func GetSettingInt(db *sql.DB, key string, def int64) int64 {
+	value, err := GetSetting(db, key)
+	if err != nil || value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
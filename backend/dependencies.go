@@ -0,0 +1,309 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Most plugins a single install plan may pull in
+const maxPlanItems = 50
+
+// Plan item statuses
+const (
+	PlanInstall      = "install"
+	PlanInstalled    = "installed"
+	PlanSkipped      = "skipped"
+	PlanMissing      = "missing"
+	PlanIncompatible = "incompatible"
+)
+
+// PlanItem is one plugin in an install plan
+type PlanItem struct {
+	Source     string `json:"source"`
+	Slug       string `json:"slug"`
+	Name       string `json:"name"`
+	Version    string `json:"version,omitempty"`
+	Type       string `json:"type"` // requested, required or optional
+	RequiredBy string `json:"required_by,omitempty"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+
+	artifact *PluginArtifact
+}
+
+type planEdge struct {
+	From     string
+	To       string
+	Source   string
+	Required bool
+}
+
+// InstallPlan lists everything an install will touch, dependencies first
+type InstallPlan struct {
+	Items    []*PlanItem `json:"items"`
+	Problems []string    `json:"problems"`
+
+	edges []planEdge
+}
+
+// Blocked reports whether the plan cannot be installed as-is
+func (p *InstallPlan) Blocked() bool {
+	return len(p.Problems) > 0
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+func normalizePluginName(name string) string {
+	return nonAlphanumeric.ReplaceAllString(strings.ToLower(name), "")
+}
+
+// installedIndex answers whether a dependency is already on the server,
+// either tracked in installed_plugins or as a jar in /plugins whose name
+// starts with the dependency's name
+type installedIndex struct {
+	tracked map[string]string // source/slug -> version
+	jars    []string          // normalized jar names
+}
+
+func loadInstalledIndex(db *sql.DB, client *PteroClient, serverID string) *installedIndex {
+	index := &installedIndex{tracked: map[string]string{}}
+	if rows, err := installedPluginRows(db, serverID); err == nil {
+		for _, p := range rows {
+			index.tracked[p.Source+"/"+strings.ToLower(p.Name)] = p.Version
+		}
+	}
+	if entries, err := client.ListFiles(serverID, pluginsDir); err == nil {
+		for _, f := range entries {
+			if f.IsFile && strings.HasSuffix(strings.ToLower(f.Name), ".jar") {
+				index.jars = append(index.jars, normalizePluginName(strings.TrimSuffix(f.Name, path.Ext(f.Name))))
+			}
+		}
+	}
+	return index
+}
+
+func (i *installedIndex) Has(source, slug, name string) bool {
+	if slug != "" {
+		if _, ok := i.tracked[source+"/"+strings.ToLower(slug)]; ok {
+			return true
+		}
+	}
+	for _, n := range []string{name, slug} {
+		n = normalizePluginName(n)
+		if n == "" {
+			continue
+		}
+		for _, jar := range i.jars {
+			if strings.HasPrefix(jar, n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// modrinthProjectSlug turns a Modrinth project id into its slug and title
+func modrinthProjectSlug(id string) (string, string, error) {
+	var project struct {
+		Slug  string `json:"slug"`
+		Title string `json:"title"`
+	}
+	if err := getJSON("https://api.modrinth.com/v2/project/"+url.PathEscape(id), &project); err != nil {
+		return "", "", err
+	}
+	return project.Slug, project.Title, nil
+}
+
+// buildInstallPlan resolves a plugin and, transitively, its required
+// dependencies for a server. Optional dependencies are only followed when
+// includeOptional is set or their slug is listed in optional. Anything that
+// keeps the set from working (a required dependency that cannot be
+// resolved, an incompatible plugin already installed) is reported in
+// Problems.
+func buildInstallPlan(db *sql.DB, client *PteroClient, serverID string, target *ServerPlatform,
+	source, slug, version string, includeOptional bool, optional []string) (*InstallPlan, error) {
+
+	installed := loadInstalledIndex(db, client, serverID)
+	wantOptional := map[string]bool{}
+	for _, o := range optional {
+		wantOptional[strings.ToLower(o)] = true
+	}
+
+	plan := &InstallPlan{Items: []*PlanItem{}, Problems: []string{}}
+	root := &PlanItem{Source: source, Slug: slug, Name: slug, Version: version, Type: "requested"}
+	seen := map[string]*PlanItem{source + "/" + strings.ToLower(slug): root}
+	queue := []*PlanItem{root}
+
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		if len(plan.Items) >= maxPlanItems {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("More than %d plugins are needed; stopped at %s", maxPlanItems, item.Name))
+			break
+		}
+
+		artifact, err := resolvePluginDownload(item.Source, item.Slug, item.Version, target)
+		if err != nil {
+			if item.Type == "requested" {
+				return nil, err
+			}
+			item.Status = PlanMissing
+			item.Message = err.Error()
+			if item.Type == DependencyRequired {
+				plan.Problems = append(plan.Problems, fmt.Sprintf("%s requires %s, which could not be resolved: %v", item.RequiredBy, item.Name, err))
+			}
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+		item.artifact = artifact
+		item.Version = artifact.Version
+		item.Status = PlanInstall
+		item.Message = artifact.Warning
+		plan.Items = append(plan.Items, item)
+
+		deps := artifact.Dependencies
+		if source, err := GetPluginSource(artifact.Source); err == nil {
+			if named, err := source.Dependencies(artifact); err == nil {
+				deps = named
+			}
+		}
+		for _, dep := range deps {
+			if dep.Type == DependencyEmbedded {
+				continue
+			}
+
+			key := dep.Source + "/" + strings.ToLower(dep.Slug)
+			if dep.Slug == "" {
+				key = "external/" + normalizePluginName(dep.Name)
+			}
+			present := installed.Has(dep.Source, dep.Slug, dep.Name)
+
+			if dep.Type == DependencyIncompatible {
+				if present {
+					plan.Problems = append(plan.Problems, fmt.Sprintf("%s is incompatible with %s, which is installed", item.Name, dep.Name))
+					plan.Items = append(plan.Items, &PlanItem{Source: dep.Source, Slug: dep.Slug, Name: dep.Name,
+						Type: dep.Type, RequiredBy: item.Name, Status: PlanIncompatible})
+				}
+				continue
+			}
+			if dep.Slug != "" {
+				plan.edges = append(plan.edges, planEdge{From: item.Slug, To: dep.Slug, Source: dep.Source, Required: dep.Type == DependencyRequired})
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+
+			next := &PlanItem{Source: dep.Source, Slug: dep.Slug, Name: dep.Name, Type: dep.Type, RequiredBy: item.Name}
+			seen[key] = next
+			switch {
+			case present:
+				next.Status = PlanInstalled
+				plan.Items = append(plan.Items, next)
+			case dep.Slug == "":
+				next.Status = PlanMissing
+				next.Message = "Hosted outside " + dep.Source
+				if dep.ExternalURL != "" {
+					next.Message += ": " + dep.ExternalURL
+				}
+				if dep.Type == DependencyRequired {
+					plan.Problems = append(plan.Problems, fmt.Sprintf("%s requires %s, which must be installed manually", item.Name, dep.Name))
+				}
+				plan.Items = append(plan.Items, next)
+			case dep.Type == DependencyRequired || includeOptional || wantOptional[strings.ToLower(dep.Slug)]:
+				queue = append(queue, next)
+			default:
+				next.Status = PlanSkipped
+				next.Message = "Optional dependency, not selected"
+				plan.Items = append(plan.Items, next)
+			}
+		}
+	}
+
+	// Dependencies were discovered after the plugins needing them; install
+	// in reverse so every plugin's dependencies are in place first
+	for i, j := 0, len(plan.Items)-1; i < j; i, j = i+1, j-1 {
+		plan.Items[i], plan.Items[j] = plan.Items[j], plan.Items[i]
+	}
+	return plan, nil
+}
+
+// replacedPlugin is a plugin version an install plan upgraded, kept so a
+// failed plan can put it back
+type replacedPlugin struct {
+	name, source, version, fileName, fileHash string
+	backup                                    string
+}
+
+// installPlan installs every item marked for install. Jars it replaces are
+// moved to the backup folder first. If one install fails, the plugins
+// installed before it are removed and the replaced versions restored, so the
+// server is left as it was.
+func installPlan(db *sql.DB, client *PteroClient, serverID string, plan *InstallPlan) ([]*InstalledPlugin, error) {
+	var installed []*InstalledPlugin
+	var replaced []replacedPlugin
+	rollback := func() {
+		for _, p := range installed {
+			if err := client.DeleteFiles(serverID, pluginsDir, []string{p.FileName}); err != nil && pteroStatus(err) != http.StatusNotFound {
+				log.Printf("[WARN] Rollback could not remove %s from %s: %v", p.FileName, serverID, err)
+			}
+			db.Exec("DELETE FROM installed_plugins WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, p.Name, p.Source)
+		}
+		for _, r := range replaced {
+			if r.backup != "" {
+				if err := restorePluginJar(client, serverID, r.backup, r.fileName); err != nil {
+					log.Printf("[WARN] Rollback could not restore %s on %s from %s: %v", r.fileName, serverID, r.backup, err)
+				}
+			}
+			db.Exec("DELETE FROM installed_plugins WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, r.name, r.source)
+			db.Exec("INSERT INTO installed_plugins (server_id, plugin_name, plugin_version, source, file_name, file_hash) VALUES (?, ?, ?, ?, ?, ?)",
+				serverID, r.name, r.version, r.source, r.fileName, r.fileHash)
+		}
+	}
+
+	for _, item := range plan.Items {
+		if item.Status != PlanInstall {
+			continue
+		}
+
+		r := replacedPlugin{name: item.artifact.Slug, source: item.artifact.Source}
+		err := db.QueryRow("SELECT plugin_version, file_name, file_hash FROM installed_plugins WHERE server_id = ? AND plugin_name = ? AND source = ?",
+			serverID, r.name, r.source).Scan(&r.version, &r.fileName, &r.fileHash)
+		if err == nil {
+			if r.fileName != "" {
+				if r.backup, err = backupPluginJar(client, serverID, r.fileName); err != nil {
+					rollback()
+					return nil, fmt.Errorf("installing %s failed, nothing was installed: %v", item.Name, err)
+				}
+			}
+			replaced = append(replaced, r)
+		}
+
+		plugin, err := installPluginJar(db, client, serverID, item.artifact)
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("installing %s failed, nothing was installed: %v", item.Name, err)
+		}
+		installed = append(installed, plugin)
+	}
+
+	for _, plugin := range installed {
+		db.Exec("DELETE FROM plugin_dependencies WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, plugin.Name, plugin.Source)
+	}
+	for _, edge := range plan.edges {
+		for _, plugin := range installed {
+			if plugin.Name != edge.From {
+				continue
+			}
+			db.Exec("INSERT OR REPLACE INTO plugin_dependencies (server_id, plugin_name, source, depends_on, depends_on_source, required) VALUES (?, ?, ?, ?, ?, ?)",
+				serverID, plugin.Name, plugin.Source, edge.To, edge.Source, edge.Required)
+		}
+	}
+
+	return installed, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Above this many cells the LCS table gets too big and the changed middle
+// of the file is shown as one replaced block instead
+const maxDiffCells = 4_000_000
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+func splitDiffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	return lines
+}
+
+// diffLines computes a line diff via the longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	// Common prefix and suffix don't need the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(midA), len(midB)
+	if n*m > maxDiffCells {
+		for _, line := range midA {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range midB {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of midA[i:] and midB[j:]
+		lcs := make([][]int32, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && midA[i] == midB[j]:
+				ops = append(ops, diffOp{' ', midA[i]})
+				i++
+				j++
+			case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+				ops = append(ops, diffOp{'+', midB[j]})
+				j++
+			default:
+				ops = append(ops, diffOp{'-', midA[i]})
+				i++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// unifiedDiff renders the difference between two texts in unified diff
+// format with the given number of context lines. Identical texts give "".
+func unifiedDiff(fromName, toName, from, to string, context int) string {
+	ops := diffLines(splitDiffLines(from), splitDiffLines(to))
+
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Line numbers (1-based) in each file at every op
+	aLine, bLine := make([]int, len(ops)), make([]int, len(ops))
+	ai, bi := 1, 1
+	for k, op := range ops {
+		aLine[k], bLine[k] = ai, bi
+		if op.kind != '+' {
+			ai++
+		}
+		if op.kind != '-' {
+			bi++
+		}
+	}
+
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+
+		// Grow the hunk while the next change is within 2*context lines
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end += context
+				if end > run {
+					end = run
+				}
+				break
+			}
+			end = run
+		}
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		aStart, bStart := aLine[start], bLine[start]
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.text)
+			b.WriteByte('\n')
+		}
+		k = end
+	}
+	return b.String()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default size cap for files opened in the editor, overridable through the
+// editor_max_file_kb setting
+const defaultEditorMaxFileKB = 1024
+
+// ReadFile fetches the raw contents of a file, failing with errFileTooLarge
+// when it is bigger than limit bytes
+func (p *PteroClient) ReadFile(serverID, file string, limit int64) ([]byte, error) {
+	resp, err := p.Stream("GET", "/api/client/servers/"+serverID+"/files/contents?file="+url.QueryEscape(file), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
+
+// WriteFile replaces (or creates) a file with the given contents
+func (p *PteroClient) WriteFile(serverID, file string, content []byte) error {
+	resp, err := p.Stream("POST", "/api/client/servers/"+serverID+"/files/write?file="+url.QueryEscape(file),
+		bytes.NewReader(content), "text/plain")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// contentHash is the version token handed to editors for optimistic locking
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// isBinaryContent reports whether content looks like something other than
+// text. Non UTF-8 files count as binary since they can't round-trip through JSON.
+func isBinaryContent(content []byte) bool {
+	return bytes.IndexByte(content, 0) >= 0 || !utf8.Valid(content)
+}
+
+// ReadFileContentsHandler returns a text file for editing along with the hash
+// and modification time needed to save it back safely
+func ReadFileContentsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		file, err := normalizeServerPath(c.Query("file"))
+		if err != nil || file == "/" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A file path is required"})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		stat, err := client.StatFile(id, file)
+		if err == errFileNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !stat.IsFile {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Path is a directory"})
+			return
+		}
+
+		limit := GetSettingInt(db, "editor_max_file_kb", defaultEditorMaxFileKB) << 10
+		if stat.Size > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is too large to edit (max %d KB)", limit>>10), "size": stat.Size})
+			return
+		}
+
+		content, err := client.ReadFile(id, file, limit)
+		if err == errFileTooLarge {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is too large to edit (max %d KB)", limit>>10)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if isBinaryContent(content) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Binary files cannot be edited", "mimetype": stat.Mimetype})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"file":        file,
+			"content":     string(content),
+			"size":        len(content),
+			"hash":        contentHash(content),
+			"modified_at": stat.ModifiedAt,
+			"mimetype":    stat.Mimetype,
+		})
+	}
+}
+
+// WriteFileContentsHandler saves a file from the editor. When expected_hash or
+// expected_modified_at is sent, the write is refused if the file changed
+// since it was read. create only allows writing a file that does not exist yet.
+func WriteFileContentsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			File               string     `json:"file" binding:"required"`
+			Content            *string    `json:"content" binding:"required"`
+			ExpectedHash       string     `json:"expected_hash"`
+			ExpectedModifiedAt *time.Time `json:"expected_modified_at"`
+			Create             bool       `json:"create"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		file, err := normalizeServerPath(req.File)
+		if err != nil || file == "/" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A file path is required"})
+			return
+		}
+
+		content := []byte(*req.Content)
+		limit := GetSettingInt(db, "editor_max_file_kb", defaultEditorMaxFileKB) << 10
+		if int64(len(content)) > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Content is too large (max %d KB)", limit>>10)})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		stat, err := client.StatFile(id, file)
+		if err != nil && err != errFileNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		exists := err == nil
+
+		switch {
+		case req.Create && exists:
+			c.JSON(http.StatusConflict, gin.H{"error": "File already exists"})
+			return
+		case !req.Create && !exists:
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found, set create to make a new file"})
+			return
+		case exists && !stat.IsFile:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Path is a directory"})
+			return
+		}
+
+		if exists {
+			if req.ExpectedModifiedAt != nil && !stat.ModifiedAt.Equal(*req.ExpectedModifiedAt) {
+				c.JSON(http.StatusConflict, gin.H{
+					"error":       "File was modified since it was opened",
+					"modified_at": stat.ModifiedAt,
+				})
+				return
+			}
+			if req.ExpectedHash != "" {
+				current, err := client.ReadFile(id, file, limit)
+				if err != nil && err != errFileTooLarge {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				if err == errFileTooLarge || contentHash(current) != req.ExpectedHash {
+					c.JSON(http.StatusConflict, gin.H{
+						"error":       "File was modified since it was opened",
+						"modified_at": stat.ModifiedAt,
+					})
+					return
+				}
+			}
+		}
+
+		if err := writeFileWithHistory(db, client, id, file, content, CurrentUsername(c, db)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := gin.H{
+			"message": "File saved",
+			"file":    file,
+			"size":    len(content),
+			"hash":    contentHash(content),
+			"created": !exists,
+		}
+		if saved, err := client.StatFile(id, file); err == nil {
+			response["modified_at"] = saved.ModifiedAt
+		}
+		if !exists {
+			c.JSON(http.StatusCreated, response)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var fileModePattern = regexp.MustCompile(`^[0-7]{3,4}$`)
+
+type fileOpResult struct {
+	File    string `json:"file"`
+	Target  string `json:"target,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// relativeToRoot turns a normalized absolute path into the form Wings expects
+// in file operation payloads
+func relativeToRoot(p string) string {
+	return strings.TrimPrefix(p, "/")
+}
+
+// CreateFolder makes a directory named name inside root
+func (p *PteroClient) CreateFolder(serverID, root, name string) error {
+	_, err := p.Request("POST", "/api/client/servers/"+serverID+"/files/create-folder", map[string]string{
+		"root": root,
+		"name": name,
+	})
+	return err
+}
+
+// RenameFiles moves each from path to its to path. Paths are relative to the server root.
+func (p *PteroClient) RenameFiles(serverID string, moves []map[string]string) error {
+	_, err := p.Request("PUT", "/api/client/servers/"+serverID+"/files/rename", map[string]interface{}{
+		"root":  "/",
+		"files": moves,
+	})
+	return err
+}
+
+// CopyFile duplicates a file next to itself; Wings picks the copy's name
+func (p *PteroClient) CopyFile(serverID, location string) error {
+	_, err := p.Request("POST", "/api/client/servers/"+serverID+"/files/copy", map[string]string{
+		"location": location,
+	})
+	return err
+}
+
+// ChmodFiles applies octal modes to files relative to the server root
+func (p *PteroClient) ChmodFiles(serverID string, files []map[string]string) error {
+	_, err := p.Request("POST", "/api/client/servers/"+serverID+"/files/chmod", map[string]interface{}{
+		"root":  "/",
+		"files": files,
+	})
+	return err
+}
+
+// DeleteFiles removes names inside root
+func (p *PteroClient) DeleteFiles(serverID, root string, files []string) error {
+	_, err := p.Request("POST", "/api/client/servers/"+serverID+"/files/delete", map[string]interface{}{
+		"root":  root,
+		"files": files,
+	})
+	return err
+}
+
+// CreateFolderHandler creates a directory; name may contain several levels
+func CreateFolderHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			Root string `json:"root"`
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		root, err := normalizeServerPath(req.Root)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		folder, err := resolveInRoot(root, req.Name)
+		if err != nil || folder == root {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder name"})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := client.CreateFolder(id, root, strings.TrimPrefix(strings.TrimPrefix(folder, root), "/")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Folder created", "path": folder})
+	}
+}
+
+// RenameFilesHandler renames or moves a batch of files. from and to are
+// resolved against root, so "../x" moves a file up a level as long as it
+// stays inside the server.
+func RenameFilesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			Root  string `json:"root"`
+			Files []struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+			} `json:"files" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		root, err := normalizeServerPath(req.Root)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		moves := make([]map[string]string, 0, len(req.Files))
+		results := make([]fileOpResult, 0, len(req.Files))
+		for _, f := range req.Files {
+			from, err := normalizeServerPath(root + "/" + f.From)
+			if err != nil || from == "/" || f.From == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source path: " + f.From})
+				return
+			}
+			to, err := normalizeServerPath(root + "/" + f.To)
+			if err != nil || to == "/" || f.To == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target path: " + f.To})
+				return
+			}
+			if to == from || strings.HasPrefix(to, from+"/") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot move " + from + " into itself"})
+				return
+			}
+			moves = append(moves, map[string]string{"from": relativeToRoot(from), "to": relativeToRoot(to)})
+			results = append(results, fileOpResult{File: from, Target: to, Success: true})
+		}
+		if len(moves) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No files to rename"})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Wings applies the whole batch in one call and stops at the first failure
+		if err := client.RenameFiles(id, moves); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Files renamed", "results": results})
+	}
+}
+
+// CopyFilesHandler duplicates each file in place
+func CopyFilesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			Root  string   `json:"root"`
+			Files []string `json:"files" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		root, err := normalizeServerPath(req.Root)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		locations := make([]string, 0, len(req.Files))
+		for _, f := range req.Files {
+			location, err := resolveInRoot(root, f)
+			if err != nil || location == "/" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path: " + f})
+				return
+			}
+			locations = append(locations, location)
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		results := make([]fileOpResult, 0, len(locations))
+		failed := 0
+		for _, location := range locations {
+			result := fileOpResult{File: location, Success: true}
+			if err := client.CopyFile(id, location); err != nil {
+				result.Success = false
+				result.Error = err.Error()
+				failed++
+			}
+			results = append(results, result)
+		}
+
+		status := http.StatusOK
+		if failed > 0 && failed == len(results) {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"copied": len(results) - failed, "failed": failed, "results": results})
+	}
+}
+
+// ChmodFilesHandler changes permissions on a batch of files. Modes are octal
+// strings such as "644" or "0755".
+func ChmodFilesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			Root  string `json:"root"`
+			Files []struct {
+				File string `json:"file"`
+				Mode string `json:"mode"`
+			} `json:"files" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		root, err := normalizeServerPath(req.Root)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		files := make([]map[string]string, 0, len(req.Files))
+		for _, f := range req.Files {
+			file, err := resolveInRoot(root, f.File)
+			if err != nil || file == "/" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path: " + f.File})
+				return
+			}
+			if !fileModePattern.MatchString(f.Mode) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode for " + f.File + ": " + f.Mode})
+				return
+			}
+			files = append(files, map[string]string{"file": relativeToRoot(file), "mode": f.Mode})
+		}
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No files given"})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := client.ChmodFiles(id, files); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Permissions updated"})
+	}
+}
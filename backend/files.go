@@ -0,0 +1,671 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default upload limits, overridable through the upload_max_file_mb and
+// upload_max_total_mb settings
+const (
+	defaultUploadMaxFileMB  = 512
+	defaultUploadMaxTotalMB = 2048
+)
+
+var (
+	errFileTooLarge = errors.New("file exceeds the upload size limit")
+	errFileNotFound = errors.New("file not found")
+)
+
+type UploadResult struct {
+	Name      string `json:"name"`
+	Directory string `json:"directory"`
+	Size      int64  `json:"size"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
+
+// normalizeServerPath cleans a path inside a server's file system and rejects
+// anything that would climb above the server root
+func normalizeServerPath(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("invalid path")
+	}
+	p = strings.ReplaceAll(p, "\\", "/")
+
+	depth := 0
+	for _, segment := range strings.Split(p, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if depth == 0 {
+				return "", fmt.Errorf("path %q escapes the server root", p)
+			}
+			depth--
+		default:
+			depth++
+		}
+	}
+
+	return path.Clean("/" + p), nil
+}
+
+// FileObject mirrors the file attributes returned by the Pterodactyl client API
+type FileObject struct {
+	Name       string    `json:"name"`
+	Mode       string    `json:"mode"`
+	ModeBits   string    `json:"mode_bits"`
+	Size       int64     `json:"size"`
+	IsFile     bool      `json:"is_file"`
+	IsSymlink  bool      `json:"is_symlink"`
+	Mimetype   string    `json:"mimetype"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// ListFiles returns the entries of a directory on the server
+func (p *PteroClient) ListFiles(serverID, directory string) ([]FileObject, error) {
+	data, err := p.Request("GET", "/api/client/servers/"+serverID+"/files/list?directory="+url.QueryEscape(directory), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Data []struct {
+			Attributes FileObject `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse file list: %v", err)
+	}
+
+	files := make([]FileObject, 0, len(result.Data))
+	for _, f := range result.Data {
+		files = append(files, f.Attributes)
+	}
+	return files, nil
+}
+
+// StatFile looks a single path up by listing its parent directory
+func (p *PteroClient) StatFile(serverID, file string) (*FileObject, error) {
+	if file == "/" {
+		return &FileObject{Name: "/", Mode: "drwxr-xr-x"}, nil
+	}
+
+	files, err := p.ListFiles(serverID, path.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		if files[i].Name == path.Base(file) {
+			return &files[i], nil
+		}
+	}
+	return nil, errFileNotFound
+}
+
+// GetDownloadURL returns a signed Wings URL for downloading a single file
+func (p *PteroClient) GetDownloadURL(serverID, file string) (string, error) {
+	data, err := p.Request("GET", "/api/client/servers/"+serverID+"/files/download?file="+url.QueryEscape(file), nil)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Attributes struct {
+			URL string `json:"url"`
+		} `json:"attributes"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result.Attributes.URL == "" {
+		return "", fmt.Errorf("failed to get download URL")
+	}
+
+	return result.Attributes.URL, nil
+}
+
+// OpenFile starts a download of file from Wings. rangeHeader is forwarded
+// as-is; Wings may ignore it and answer with the whole file.
+func (p *PteroClient) OpenFile(serverID, file, rangeHeader string) (*http.Response, error) {
+	signedURL, err := p.GetDownloadURL(serverID, file)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", signedURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %v", err)
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %v", err)
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, errFileNotFound
+		}
+		return nil, fmt.Errorf("wings download failed (status %d)", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// pathWithin reports whether p stays inside root once normalized
+func pathWithin(root, p string) bool {
+	clean, err := normalizeServerPath(p)
+	if err != nil {
+		return false
+	}
+	return root == "/" || clean == root || strings.HasPrefix(clean, root+"/")
+}
+
+// resolveInRoot joins name onto root and fails if the result leaves root
+func resolveInRoot(root, name string) (string, error) {
+	full, err := normalizeServerPath(root + "/" + name)
+	if err != nil || !pathWithin(root, full) {
+		return "", fmt.Errorf("path %q is outside %s", name, root)
+	}
+	return full, nil
+}
+
+// GetUploadURL returns a signed Wings URL for uploading files to a server.
+// Wings only accepts each URL once.
+func (p *PteroClient) GetUploadURL(serverID string) (string, error) {
+	data, err := p.Request("GET", "/api/client/servers/"+serverID+"/files/upload", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Attributes struct {
+			URL string `json:"url"`
+		} `json:"attributes"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result.Attributes.URL == "" {
+		return "", fmt.Errorf("failed to get upload URL")
+	}
+
+	return result.Attributes.URL, nil
+}
+
+// UploadFile streams r into directory on the server as name. The multipart
+// body is produced on the fly so the file is never buffered in full.
+func (p *PteroClient) UploadFile(serverID, directory, name string, r io.Reader) error {
+	signedURL, err := p.GetUploadURL(serverID)
+	if err != nil {
+		return err
+	}
+
+	target, err := url.Parse(signedURL)
+	if err != nil {
+		return fmt.Errorf("invalid upload URL: %v", err)
+	}
+	query := target.Query()
+	query.Set("directory", directory)
+	target.RawQuery = query.Encode()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("files", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest("POST", target.String(), pr)
+	if err != nil {
+		pr.Close()
+		return fmt.Errorf("failed to create upload request: %v", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("upload failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return fmt.Errorf("wings rejected upload (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// sizeLimitedReader counts bytes read and fails once the limit is exceeded
+type sizeLimitedReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (l *sizeLimitedReader) Read(b []byte) (int, error) {
+	n, err := l.r.Read(b)
+	l.n += int64(n)
+	if l.n > l.limit {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// partialFileName is the hidden name a file is written under until it is
+// complete, so a failed transfer never touches the file it replaces
+func partialFileName(name string) string {
+	return fmt.Sprintf(".%s.%d.part", name, time.Now().UnixNano())
+}
+
+// replaceFile moves the finished partial file in dir over name. Wings will
+// not rename onto an existing file, so the old one is moved aside first and
+// put back if the swap fails.
+func replaceFile(client *PteroClient, serverID, dir, partial, name string) error {
+	target := path.Join(dir, name)
+	existing, err := client.StatFile(serverID, target)
+	if err != nil && !errors.Is(err, errFileNotFound) {
+		return err
+	}
+	if existing != nil && !existing.IsFile {
+		return fmt.Errorf("%s is a directory", target)
+	}
+
+	aside := path.Join(dir, partialFileName(name)+".old")
+	if existing != nil {
+		if err := client.RenameFiles(serverID, []map[string]string{{"from": relativeToRoot(target), "to": relativeToRoot(aside)}}); err != nil {
+			return fmt.Errorf("failed to move %s aside: %v", target, err)
+		}
+	}
+	if err := client.RenameFiles(serverID, []map[string]string{{"from": relativeToRoot(path.Join(dir, partial)), "to": relativeToRoot(target)}}); err != nil {
+		if existing != nil {
+			client.RenameFiles(serverID, []map[string]string{{"from": relativeToRoot(aside), "to": relativeToRoot(target)}})
+		}
+		return fmt.Errorf("failed to move %s into place: %v", target, err)
+	}
+	if existing != nil {
+		client.DeleteFiles(serverID, dir, []string{path.Base(aside)})
+	}
+	return nil
+}
+
+// UploadFileHandler accepts a multipart upload with any number of "files"
+// parts and streams each one to Wings. The target directory comes from the
+// "directory" query parameter or a form field sent before the files.
+func UploadFileHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		directory, err := normalizeServerPath(c.DefaultQuery("directory", "/"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		maxFile := GetSettingInt(db, "upload_max_file_mb", defaultUploadMaxFileMB) << 20
+		maxTotal := GetSettingInt(db, "upload_max_total_mb", defaultUploadMaxTotalMB) << 20
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxTotal)
+
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart/form-data upload"})
+			return
+		}
+
+		author := CurrentUsername(c, db)
+		results := []UploadResult{}
+		failed := 0
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Malformed upload: " + err.Error(), "results": results})
+				return
+			}
+
+			if part.FileName() == "" {
+				if part.FormName() == "directory" {
+					value, _ := io.ReadAll(io.LimitReader(part, 4096))
+					directory, err = normalizeServerPath(string(value))
+					if err != nil {
+						part.Close()
+						c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "results": results})
+						return
+					}
+				}
+				part.Close()
+				continue
+			}
+
+			name := path.Base(strings.ReplaceAll(part.FileName(), "\\", "/"))
+			if name == "" || name == "." || name == ".." || name == "/" {
+				part.Close()
+				failed++
+				results = append(results, UploadResult{Name: part.FileName(), Directory: directory, Error: "Invalid file name"})
+				continue
+			}
+
+			// The upload goes to a partial file first so a failure never
+			// destroys a file of the same name
+			partial := partialFileName(name)
+			counter := &sizeLimitedReader{r: part, limit: maxFile}
+			err = client.UploadFile(id, directory, partial, counter)
+			part.Close()
+			if counter.n > maxFile {
+				err = errFileTooLarge
+			}
+			if err == nil {
+				err = keepCurrentRevision(db, client, id, path.Join(directory, name), author)
+			}
+			if err == nil {
+				err = replaceFile(client, id, directory, partial, name)
+			}
+
+			result := UploadResult{Name: name, Directory: directory, Size: counter.n, Success: err == nil}
+			if err != nil {
+				failed++
+				result.Error = err.Error()
+				if errors.Is(err, errFileTooLarge) {
+					result.Error = fmt.Sprintf("%s (max %d MB)", err.Error(), maxFile>>20)
+				}
+				client.DeleteFiles(id, directory, []string{partial})
+			}
+			results = append(results, result)
+		}
+
+		if len(results) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No files in upload"})
+			return
+		}
+
+		status := http.StatusOK
+		if failed == len(results) {
+			status = http.StatusBadGateway
+		}
+		c.JSON(status, gin.H{
+			"uploaded": len(results) - failed,
+			"failed":   failed,
+			"results":  results,
+		})
+	}
+}
+
+// DownloadFileHandler streams files from Wings to the browser. A single file
+// is proxied with Range support; several files, or a directory, are packed
+// into a zip archive on the fly.
+func DownloadFileHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		requested := c.QueryArray("file")
+		if len(requested) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+			return
+		}
+
+		files := make([]string, 0, len(requested))
+		for _, f := range requested {
+			clean, err := normalizeServerPath(f)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			files = append(files, clean)
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		stats := make([]*FileObject, 0, len(files))
+		for _, f := range files {
+			stat, err := client.StatFile(id, f)
+			if err == errFileNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "File not found: " + f})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			stats = append(stats, stat)
+		}
+
+		if len(files) == 1 && stats[0].IsFile {
+			streamSingleFile(c, client, id, files[0], stats[0])
+			return
+		}
+
+		archiveName := c.Query("archive")
+		if archiveName == "" {
+			archiveName = "files.zip"
+			if len(files) == 1 && files[0] != "/" {
+				archiveName = path.Base(files[0]) + ".zip"
+			}
+		}
+		streamZip(c, client, id, files, archiveName)
+	}
+}
+
+func attachmentHeader(name string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
+
+func streamSingleFile(c *gin.Context, client *PteroClient, serverID, file string, stat *FileObject) {
+	rangeHeader := c.GetHeader("Range")
+	resp, err := client.OpenFile(serverID, file, rangeHeader)
+	if err == errFileNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found: " + file})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	size := resp.ContentLength
+	if size < 0 && resp.StatusCode == http.StatusOK {
+		size = stat.Size
+	}
+
+	contentType := stat.Mimetype
+	if contentType == "" || contentType == "inode/x-empty" {
+		contentType = "application/octet-stream"
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", attachmentHeader(path.Base(file)))
+	header.Set("Accept-Ranges", "bytes")
+	if !stat.ModifiedAt.IsZero() {
+		header.Set("Last-Modified", stat.ModifiedAt.UTC().Format(http.TimeFormat))
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Wings handled the range itself
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			header.Set("Content-Range", cr)
+		}
+		if resp.ContentLength >= 0 {
+			header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+		}
+		c.Status(resp.StatusCode)
+		io.Copy(c.Writer, resp.Body)
+
+	case rangeHeader != "" && size >= 0:
+		start, end, ok := parseByteRange(rangeHeader, size)
+		if !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if start < 0 {
+			// Multiple or unparseable ranges: fall back to the whole file
+			header.Set("Content-Length", strconv.FormatInt(size, 10))
+			c.Status(http.StatusOK)
+			io.Copy(c.Writer, resp.Body)
+			return
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Download interrupted: " + err.Error()})
+			return
+		}
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		header.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		c.Status(http.StatusPartialContent)
+		io.CopyN(c.Writer, resp.Body, end-start+1)
+
+	default:
+		if size >= 0 {
+			header.Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		c.Status(http.StatusOK)
+		io.Copy(c.Writer, resp.Body)
+	}
+}
+
+// parseByteRange parses a single "bytes=" range against a file of the given
+// size. ok is false when the range cannot be satisfied; start is -1 when the
+// header should be ignored (multiple ranges or unknown units).
+func parseByteRange(header string, size int64) (start, end int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return -1, -1, true
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return -1, -1, true
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	if first == "" {
+		// Suffix range: the last N bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+// streamZip writes the requested files and directories into a zip archive
+// directly on the response. Entries are named relative to each item's parent.
+func streamZip(c *gin.Context, client *PteroClient, serverID string, files []string, archiveName string) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", attachmentHeader(archiveName))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	for _, f := range files {
+		base := path.Dir(f)
+		if f == "/" {
+			base = "/"
+		}
+		if err := addToZip(zw, client, serverID, f, base); err != nil {
+			// Headers are already sent, so all we can do is cut the archive short
+			log.Printf("[ERROR] Zip download of %s on %s failed: %v", f, serverID, err)
+			zw.Close()
+			return
+		}
+	}
+	zw.Close()
+}
+
+func addToZip(zw *zip.Writer, client *PteroClient, serverID, file, base string) error {
+	stat, err := client.StatFile(serverID, file)
+	if err != nil {
+		return err
+	}
+	return addEntryToZip(zw, client, serverID, file, base, stat)
+}
+
+func addEntryToZip(zw *zip.Writer, client *PteroClient, serverID, file, base string, stat *FileObject) error {
+	name := strings.TrimPrefix(strings.TrimPrefix(file, base), "/")
+
+	if !stat.IsFile {
+		if name != "" {
+			header := &zip.FileHeader{Name: name + "/", Modified: stat.ModifiedAt}
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+		}
+		entries, err := client.ListFiles(serverID, file)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			if entries[i].IsSymlink {
+				continue
+			}
+			if err := addEntryToZip(zw, client, serverID, path.Join(file, entries[i].Name), base, &entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	resp, err := client.OpenFile(serverID, file, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: stat.ModifiedAt}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header     string
+		size       int64
+		start, end int64
+		ok         bool
+	}{
+		{"bytes=0-99", 1000, 0, 99, true},
+		{"bytes=100-", 1000, 100, 999, true},
+		{"bytes=900-2000", 1000, 900, 999, true},
+		{"bytes=-100", 1000, 900, 999, true},
+		{"bytes=-5000", 1000, 0, 999, true},
+		{"bytes= 10 - 20 ", 1000, 10, 20, true},
+		{"bytes=999-999", 1000, 999, 999, true},
+
+		// Unsatisfiable
+		{"bytes=1000-", 1000, 0, 0, false},
+		{"bytes=50-10", 1000, 0, 0, false},
+		{"bytes=-0", 1000, 0, 0, false},
+		{"bytes=-10", 0, 0, 0, false},
+		{"bytes=0-", 0, 0, 0, false},
+		{"bytes=abc-", 1000, 0, 0, false},
+		{"bytes=10-abc", 1000, 0, 0, false},
+
+		// Ignored: the whole file is served
+		{"items=0-10", 1000, -1, -1, true},
+		{"bytes=0-10,20-30", 1000, -1, -1, true},
+		{"bytes=10", 1000, -1, -1, true},
+	}
+
+	for _, tt := range tests {
+		start, end, ok := parseByteRange(tt.header, tt.size)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("parseByteRange(%q, %d) = %d, %d, %v; want %d, %d, %v",
+				tt.header, tt.size, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"os/exec"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -29,6 +32,9 @@ func GetSettingsHandler(db *sql.DB) gin.HandlerFunc {
 		if key, _ := GetSetting(db, "ptero_client_key"); key != "" {
 			hasClientKey = true
 		}
+
+		curseForgeKey, _ := GetSetting(db, "curseforge_api_key")
+		curseForgeURL, _ := GetSetting(db, "curseforge_api_url")
 		
 		c.JSON(http.StatusOK, gin.H{
 			"ptero_url":      pteroURL,
@@ -36,6 +42,16 @@ func GetSettingsHandler(db *sql.DB) gin.HandlerFunc {
 			"has_client_key": hasClientKey,
 			"debug_mode":     debugMode == "true",
 			"registration":   !HasAdmin(db),
+
+			"upload_max_file_mb":           GetSettingInt(db, "upload_max_file_mb", defaultUploadMaxFileMB),
+			"upload_max_total_mb":          GetSettingInt(db, "upload_max_total_mb", defaultUploadMaxTotalMB),
+			"editor_max_file_kb":           GetSettingInt(db, "editor_max_file_kb", defaultEditorMaxFileKB),
+			"history_max_revisions":        GetSettingInt(db, "history_max_revisions", defaultHistoryMaxRevisions),
+			"plugin_update_interval_hours": GetSettingInt(db, "plugin_update_interval_hours", defaultPluginUpdateIntervalHours),
+			"pull_allowed_hosts":           pullAllowedHosts(db),
+			"has_curseforge_key":           curseForgeKey != "",
+			"curseforge_api_url":           curseForgeURL,
+			"artifact_cache_mb":            GetSettingInt(db, "artifact_cache_mb", defaultArtifactCacheMB),
 		})
 	}
 }
@@ -47,6 +63,18 @@ func SaveSettingsHandler(db *sql.DB) gin.HandlerFunc {
 			PteroKey      string `json:"ptero_key"`       // Application API key
 			PteroClientKey string `json:"ptero_client_key"` // Client API key
 			DebugMode     *bool  `json:"debug_mode"`
+
+			UploadMaxFileMB           *int `json:"upload_max_file_mb"`
+			UploadMaxTotalMB          *int `json:"upload_max_total_mb"`
+			EditorMaxFileKB           *int `json:"editor_max_file_kb"`
+			HistoryMaxRevisions       *int `json:"history_max_revisions"`
+			PluginUpdateIntervalHours *int `json:"plugin_update_interval_hours"`
+			ArtifactCacheMB           *int `json:"artifact_cache_mb"` // 0 turns the cache off
+
+			PullAllowedHosts []string `json:"pull_allowed_hosts"`
+
+			CurseForgeAPIKey string  `json:"curseforge_api_key"`
+			CurseForgeAPIURL *string `json:"curseforge_api_url"` // empty to use the real API
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -70,6 +98,34 @@ func SaveSettingsHandler(db *sql.DB) gin.HandlerFunc {
 			}
 		}
 
+		if req.UploadMaxFileMB != nil && *req.UploadMaxFileMB > 0 {
+			SetSetting(db, "upload_max_file_mb", strconv.Itoa(*req.UploadMaxFileMB))
+		}
+		if req.UploadMaxTotalMB != nil && *req.UploadMaxTotalMB > 0 {
+			SetSetting(db, "upload_max_total_mb", strconv.Itoa(*req.UploadMaxTotalMB))
+		}
+		if req.EditorMaxFileKB != nil && *req.EditorMaxFileKB > 0 {
+			SetSetting(db, "editor_max_file_kb", strconv.Itoa(*req.EditorMaxFileKB))
+		}
+		if req.HistoryMaxRevisions != nil && *req.HistoryMaxRevisions > 0 {
+			SetSetting(db, "history_max_revisions", strconv.Itoa(*req.HistoryMaxRevisions))
+		}
+		if req.PluginUpdateIntervalHours != nil && *req.PluginUpdateIntervalHours > 0 {
+			SetSetting(db, "plugin_update_interval_hours", strconv.Itoa(*req.PluginUpdateIntervalHours))
+		}
+		if req.ArtifactCacheMB != nil && *req.ArtifactCacheMB >= 0 {
+			SetSetting(db, "artifact_cache_mb", strconv.Itoa(*req.ArtifactCacheMB))
+		}
+		if req.PullAllowedHosts != nil {
+			SetSetting(db, "pull_allowed_hosts", strings.Join(req.PullAllowedHosts, ","))
+		}
+		if req.CurseForgeAPIKey != "" {
+			SetSetting(db, "curseforge_api_key", req.CurseForgeAPIKey)
+		}
+		if req.CurseForgeAPIURL != nil {
+			SetSetting(db, "curseforge_api_url", strings.TrimSpace(*req.CurseForgeAPIURL))
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": "Settings saved"})
 	}
 }
@@ -227,36 +283,18 @@ func ConsoleWSHandler(db *sql.DB) gin.HandlerFunc {
 func ListFilesHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		dir := c.DefaultQuery("directory", "/")
-		client, err := NewPteroClient(db)
+		dir, err := normalizeServerPath(c.DefaultQuery("directory", "/"))
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		data, err := client.Request("GET", "/api/client/servers/"+id+"/files/list?directory="+dir, nil)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		var result map[string]interface{}
-		json.Unmarshal(data, &result)
-		c.JSON(http.StatusOK, result)
-	}
-}
-
-func UploadFileHandler(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		id := c.Param("id")
-		client, err := NewPteroClient(db)
+		client, err := NewPteroClientAPI(db)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Get upload URL from Pterodactyl
-		data, err := client.Request("GET", "/api/client/servers/"+id+"/files/upload", nil)
+		data, err := client.Request("GET", "/api/client/servers/"+id+"/files/list?directory="+url.QueryEscape(dir), nil)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -277,41 +315,31 @@ func DeleteFileHandler(db *sql.DB) gin.HandlerFunc {
 		}
 		c.ShouldBindJSON(&req)
 
-		client, err := NewPteroClient(db)
+		root, err := normalizeServerPath(req.Root)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		_, err = client.Request("POST", "/api/client/servers/"+id+"/files/delete", req)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		for _, f := range req.Files {
+			if full, err := resolveInRoot(root, f); err != nil || full == root {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path: " + f})
+				return
+			}
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Files deleted"})
-	}
-}
-
-func DownloadFileHandler(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		id := c.Param("id")
-		file := c.Query("file")
-		client, err := NewPteroClient(db)
+		client, err := NewPteroClientAPI(db)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		data, err := client.Request("GET", "/api/client/servers/"+id+"/files/download?file="+file, nil)
+		err = client.DeleteFiles(id, root, req.Files)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		var result map[string]interface{}
-		json.Unmarshal(data, &result)
-		c.JSON(http.StatusOK, result)
+		c.JSON(http.StatusOK, gin.H{"message": "Files deleted"})
 	}
 }
 
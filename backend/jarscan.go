@@ -0,0 +1,530 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Source recorded for jars that could not be matched to a plugin source
+const SourceManual = "manual"
+
+// Descriptors checked in each jar, most specific first
+var jarDescriptors = []string{"paper-plugin.yml", "plugin.yml", "velocity-plugin.json", "fabric.mod.json", "quilt.mod.json"}
+
+// ScannedJar describes one jar found in the plugins folder
+type ScannedJar struct {
+	FileName   string   `json:"file_name"`
+	Size       int64    `json:"size"`
+	SHA256     string   `json:"sha256"`
+	Descriptor string   `json:"descriptor,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	Version    string   `json:"version,omitempty"`
+	Authors    []string `json:"authors"`
+	APIVersion string   `json:"api_version,omitempty"`
+	Source     string   `json:"source"`
+	Slug       string   `json:"slug"`
+	MatchedBy  string   `json:"matched_by,omitempty"` // hash
+	Status     string   `json:"status"`               // tracked, updated, added or unreadable
+	Error      string   `json:"error,omitempty"`
+	// Project with the same name as an unmatched jar; the jar stays manual
+	// because a name alone does not prove it is the same plugin
+	Suggested *PluginResultRef `json:"suggested,omitempty"`
+
+	sha1 string
+	main string
+}
+
+// yamlText returns a descriptor value as written. Versions such as 1.20
+// must not go through number parsing.
+func yamlText(cfg *parsedConfig, key string) string {
+	e, ok := cfg.byKey[key]
+	if !ok || e.Value == nil {
+		return ""
+	}
+	if s, ok := e.Value.(string); ok {
+		return s
+	}
+	line := cfg.lines[e.line]
+	if e.Type == "list" || len(e.prefix)+len(e.suffix) > len(line) {
+		return fmt.Sprint(e.Value)
+	}
+	return strings.TrimSpace(line[len(e.prefix) : len(line)-len(e.suffix)])
+}
+
+func yamlList(cfg *parsedConfig, key string) []string {
+	e, ok := cfg.byKey[key]
+	if !ok {
+		return nil
+	}
+	items, ok := e.Value.([]interface{})
+	if !ok {
+		if s := yamlText(cfg, key); s != "" {
+			return []string{s}
+		}
+		return nil
+	}
+	var out []string
+	for _, item := range items {
+		if item != nil {
+			out = append(out, fmt.Sprint(item))
+		}
+	}
+	return out
+}
+
+// jsonAuthors reads an authors array whose entries are either names or
+// objects with a name, as Fabric and Quilt allow
+func jsonAuthors(raw []json.RawMessage) []string {
+	var out []string
+	for _, r := range raw {
+		var name string
+		if json.Unmarshal(r, &name) == nil {
+			out = append(out, name)
+			continue
+		}
+		var person struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(r, &person) == nil && person.Name != "" {
+			out = append(out, person.Name)
+		}
+	}
+	return out
+}
+
+// readJarDescriptor fills in a jar's name, version, authors and API version
+// from the first descriptor it contains
+func readJarDescriptor(jar *ScannedJar, content []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return fmt.Errorf("not a valid jar: %v", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	for _, name := range jarDescriptors {
+		f, ok := files[name]
+		if !ok {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		jar.Descriptor = name
+
+		switch name {
+		case "paper-plugin.yml", "plugin.yml":
+			cfg := parseConfig("yaml", string(data))
+			jar.Name = yamlText(cfg, "name")
+			jar.Version = yamlText(cfg, "version")
+			jar.APIVersion = yamlText(cfg, "api-version")
+			jar.main = yamlText(cfg, "main")
+			jar.Authors = append(yamlList(cfg, "author"), yamlList(cfg, "authors")...)
+		case "velocity-plugin.json", "fabric.mod.json":
+			var desc struct {
+				ID      string            `json:"id"`
+				Name    string            `json:"name"`
+				Version string            `json:"version"`
+				Authors []json.RawMessage `json:"authors"`
+			}
+			if err := json.Unmarshal(data, &desc); err != nil {
+				return fmt.Errorf("invalid %s: %v", name, err)
+			}
+			jar.Name, jar.Version, jar.Authors = desc.Name, desc.Version, jsonAuthors(desc.Authors)
+			if jar.Name == "" {
+				jar.Name = desc.ID
+			}
+		case "quilt.mod.json":
+			var desc struct {
+				QuiltLoader struct {
+					ID       string `json:"id"`
+					Version  string `json:"version"`
+					Metadata struct {
+						Name         string                     `json:"name"`
+						Contributors map[string]json.RawMessage `json:"contributors"`
+					} `json:"metadata"`
+				} `json:"quilt_loader"`
+			}
+			if err := json.Unmarshal(data, &desc); err != nil {
+				return fmt.Errorf("invalid %s: %v", name, err)
+			}
+			q := desc.QuiltLoader
+			jar.Name, jar.Version = q.Metadata.Name, q.Version
+			if jar.Name == "" {
+				jar.Name = q.ID
+			}
+			for person := range q.Metadata.Contributors {
+				jar.Authors = append(jar.Authors, person)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no plugin descriptor found")
+}
+
+// scanJar downloads a jar from the server, hashing it on the way, and reads
+// its descriptor
+func scanJar(client *PteroClient, serverID string, f FileObject, limit int64) *ScannedJar {
+	jar := &ScannedJar{FileName: f.Name, Size: f.Size, Authors: []string{}}
+	if f.Size > limit {
+		jar.Status, jar.Error = "unreadable", fmt.Sprintf("larger than %d MB", limit>>20)
+		return jar
+	}
+
+	resp, err := client.OpenFile(serverID, path.Join(pluginsDir, f.Name), "")
+	if err != nil {
+		jar.Status, jar.Error = "unreadable", err.Error()
+		return jar
+	}
+	defer resp.Body.Close()
+
+	h1, h256 := sha1.New(), sha256.New()
+	content, err := io.ReadAll(io.TeeReader(io.LimitReader(resp.Body, limit), io.MultiWriter(h1, h256)))
+	if err != nil {
+		jar.Status, jar.Error = "unreadable", err.Error()
+		return jar
+	}
+	jar.Size = int64(len(content))
+	jar.sha1 = hex.EncodeToString(h1.Sum(nil))
+	jar.SHA256 = hex.EncodeToString(h256.Sum(nil))
+
+	if err := readJarDescriptor(jar, content); err != nil {
+		jar.Error = err.Error()
+	}
+	if jar.Authors == nil {
+		jar.Authors = []string{}
+	}
+	return jar
+}
+
+// matchModrinthHashes looks up every jar's sha1 on Modrinth in one request
+func matchModrinthHashes(jars []*ScannedJar) {
+	var hashes []string
+	byHash := map[string]*ScannedJar{}
+	for _, jar := range jars {
+		if jar.sha1 != "" && jar.Source == "" {
+			hashes = append(hashes, jar.sha1)
+			byHash[jar.sha1] = jar
+		}
+	}
+	if len(hashes) == 0 {
+		return
+	}
+
+	versions, err := modrinthVersionsByHash(hashes)
+	if err != nil {
+		log.Printf("[WARN] Modrinth hash lookup failed: %v", err)
+		return
+	}
+
+	for hash, v := range versions {
+		jar := byHash[hash]
+		if jar == nil {
+			continue
+		}
+		slug, _, err := modrinthProjectSlug(v.ProjectID)
+		if err != nil {
+			continue
+		}
+		jar.Source, jar.Slug, jar.MatchedBy = "modrinth", slug, "hash"
+		jar.Version = v.VersionNumber
+	}
+}
+
+// matchHangarHash looks a jar's sha256 up on Hangar
+func matchHangarHash(jar *ScannedJar) {
+	var version struct {
+		Name      string `json:"name"`
+		ProjectID int    `json:"projectId"`
+	}
+	if err := getJSON("https://hangar.papermc.io/api/v1/versions/hash/"+jar.SHA256, &version); err != nil || version.ProjectID == 0 {
+		return
+	}
+	var project struct {
+		Name      string `json:"name"`
+		Namespace struct {
+			Slug string `json:"slug"`
+		} `json:"namespace"`
+	}
+	if err := getJSON(fmt.Sprintf("https://hangar.papermc.io/api/v1/projects/%d", version.ProjectID), &project); err != nil {
+		return
+	}
+	slug := project.Namespace.Slug
+	if slug == "" {
+		slug = project.Name
+	}
+	jar.Source, jar.Slug, jar.MatchedBy = "hangar", slug, "hash"
+	if version.Name != "" {
+		jar.Version = version.Name
+	}
+}
+
+// suggestByName looks for a project whose name equals the descriptor name,
+// on Hangar and then Spigot, which cannot be searched by hash. A match is
+// only suggested, so updates never come from a project that merely shares
+// the name.
+func suggestByName(jar *ScannedJar) {
+	want := normalizePluginName(jar.Name)
+	if want == "" {
+		return
+	}
+
+	var project struct {
+		Name      string `json:"name"`
+		Namespace struct {
+			Slug string `json:"slug"`
+		} `json:"namespace"`
+	}
+	if err := getJSON("https://hangar.papermc.io/api/v1/projects/"+url.PathEscape(jar.Name), &project); err == nil &&
+		normalizePluginName(project.Name) == want && project.Namespace.Slug != "" {
+		jar.Suggested = &PluginResultRef{Source: "hangar", Slug: project.Namespace.Slug}
+		return
+	}
+
+	var resources []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	endpoint := "https://api.spiget.org/v2/search/resources/" + url.PathEscape(jar.Name) + "?field=name&size=10"
+	if err := getJSON(endpoint, &resources); err != nil {
+		return
+	}
+	for _, r := range resources {
+		// Spigot resource names often carry a tagline after the plugin name
+		name := r.Name
+		if i := strings.IndexAny(name, "|-[("); i > 0 {
+			name = name[:i]
+		}
+		if normalizePluginName(name) == want {
+			jar.Suggested = &PluginResultRef{Source: "spigot", Slug: fmt.Sprint(r.ID)}
+			return
+		}
+	}
+}
+
+// reconcilePlugins scans the jars in a server's plugins folder and brings
+// installed_plugins in line with them: jars nobody tracked are added, rows
+// whose jar changed are updated and rows whose jar is gone are removed.
+// With dryRun set nothing is written.
+func reconcilePlugins(job *Job, db *sql.DB, client *PteroClient, serverID string, dryRun bool) (map[string]interface{}, error) {
+	entries, err := client.ListFiles(serverID, pluginsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", pluginsDir, err)
+	}
+	rows, err := installedPluginRows(db, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileObject
+	for _, f := range entries {
+		if f.IsFile && strings.HasSuffix(strings.ToLower(f.Name), ".jar") {
+			files = append(files, f)
+		}
+	}
+
+	limit := int64(GetSettingInt(db, "upload_max_file_mb", defaultUploadMaxFileMB)) << 20
+	jars := []*ScannedJar{}
+	for i, f := range files {
+		job.SetProgress(int64(i), int64(len(files)), "Reading "+f.Name)
+		jars = append(jars, scanJar(client, serverID, f, limit))
+	}
+
+	// Jars already tracked with an unchanged hash need no lookup
+	byFile := map[string]*installedPluginRow{}
+	for i := range rows {
+		if rows[i].FileName != "" {
+			byFile[rows[i].FileName] = &rows[i]
+		}
+	}
+	var lookup []*ScannedJar
+	for _, jar := range jars {
+		if row := byFile[jar.FileName]; row != nil && row.Source != SourceManual && row.FileHash == jar.SHA256 {
+			jar.Source, jar.Slug, jar.Version, jar.Status = row.Source, row.Name, row.Version, "tracked"
+			continue
+		}
+		if jar.SHA256 != "" {
+			lookup = append(lookup, jar)
+		}
+	}
+
+	job.SetProgress(int64(len(files)), int64(len(files)), "Matching jars to plugin sources")
+	matchModrinthHashes(lookup)
+	for _, jar := range lookup {
+		if jar.Source == "" {
+			matchHangarHash(jar)
+		}
+		if jar.Source == "" {
+			suggestByName(jar)
+		}
+	}
+
+	var tx *sql.Tx
+	if !dryRun {
+		if tx, err = db.Begin(); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+	}
+	exec := func(query string, args ...interface{}) error {
+		if tx == nil {
+			return nil
+		}
+		_, err := tx.Exec(query, args...)
+		return err
+	}
+
+	claimed := map[*installedPluginRow]bool{}
+	for _, jar := range jars {
+		if jar.Status == "tracked" {
+			claimed[byFile[jar.FileName]] = true
+			continue
+		}
+		if jar.SHA256 == "" {
+			jar.Status = "unreadable"
+			continue
+		}
+		if jar.Source == "" {
+			jar.Source = SourceManual
+			jar.Slug = jar.Name
+			if jar.Slug == "" {
+				jar.Slug = strings.TrimSuffix(jar.FileName, path.Ext(jar.FileName))
+			}
+		}
+
+		// Prefer the row tracking this file, then one tracking the same
+		// project under a file name that is gone
+		row := byFile[jar.FileName]
+		if row == nil {
+			for i := range rows {
+				r := &rows[i]
+				if !claimed[r] && r.Source == jar.Source && strings.EqualFold(r.Name, jar.Slug) {
+					row = r
+					break
+				}
+			}
+		}
+
+		if row == nil {
+			jar.Status = "added"
+			err = exec("INSERT INTO installed_plugins (server_id, plugin_name, plugin_version, source, file_name, file_hash) VALUES (?, ?, ?, ?, ?, ?)",
+				serverID, jar.Slug, jar.Version, jar.Source, jar.FileName, jar.SHA256)
+		} else {
+			claimed[row] = true
+			jar.Status = "updated"
+			if row.Source != SourceManual && jar.Source == SourceManual {
+				// Keep what the panel knows about a plugin it installed
+				jar.Source, jar.Slug = row.Source, row.Name
+			}
+			err = exec("UPDATE installed_plugins SET plugin_name = ?, plugin_version = ?, source = ?, file_name = ?, file_hash = ? WHERE id = ?",
+				jar.Slug, jar.Version, jar.Source, jar.FileName, jar.SHA256, row.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	gone := map[*installedPluginRow]bool{}
+	for i := range rows {
+		r := &rows[i]
+		if claimed[r] {
+			continue
+		}
+		if r.FileName != "" && findScannedJar(jars, r.FileName) != nil {
+			// Its jar is still there but could not be read; leave it be
+			continue
+		}
+		gone[r] = true
+	}
+
+	removed := []map[string]string{}
+	for i := range rows {
+		r := &rows[i]
+		if !gone[r] {
+			continue
+		}
+		removed = append(removed, map[string]string{"name": r.Name, "source": r.Source, "file_name": r.FileName})
+		if err := exec("DELETE FROM installed_plugins WHERE id = ?", r.ID); err != nil {
+			return nil, err
+		}
+		if pluginStillTracked(rows, gone, r) {
+			continue
+		}
+		for _, table := range []string{"plugin_dependencies", "plugin_updates"} {
+			if err := exec("DELETE FROM "+table+" WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, r.Name, r.Source); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"jars": jars, "removed": removed, "dry_run": dryRun}, nil
+}
+
+// pluginStillTracked reports whether a row that is not gone has the same name
+// and source as r, so the data kept by name must stay
+func pluginStillTracked(rows []installedPluginRow, gone map[*installedPluginRow]bool, r *installedPluginRow) bool {
+	for i := range rows {
+		if other := &rows[i]; other != r && !gone[other] && other.Name == r.Name && other.Source == r.Source {
+			return true
+		}
+	}
+	return false
+}
+
+func findScannedJar(jars []*ScannedJar, fileName string) *ScannedJar {
+	for _, jar := range jars {
+		if jar.FileName == fileName {
+			return jar
+		}
+	}
+	return nil
+}
+
+// ScanPluginsHandler reads every jar in a server's plugins folder and syncs
+// installed_plugins with what is actually there, as a background job.
+// ?dry_run=true reports the changes without saving them.
+func ScanPluginsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		dryRun := c.Query("dry_run") == "true"
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		job := StartJob("plugin-scan", serverID, func(job *Job) (map[string]interface{}, error) {
+			return reconcilePlugins(job, db, client, serverID, dryRun)
+		})
+
+		respondWithJob(c, job)
+	}
+}
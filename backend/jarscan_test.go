@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testJar builds a jar, or any zip, in memory holding the given files
+func testJar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadJarDescriptor(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		descriptor string
+		plugin     string
+		version    string
+		authors    []string
+		apiVersion string
+		main       string
+	}{
+		{
+			name: "plugin.yml",
+			files: map[string]string{"plugin.yml": `name: Essentials
+version: 2.20.1
+main: com.earth2me.essentials.Essentials
+api-version: 1.13
+authors: [Zenexer, ementalo]
+`},
+			descriptor: "plugin.yml", plugin: "Essentials", version: "2.20.1",
+			authors: []string{"Zenexer", "ementalo"}, apiVersion: "1.13", main: "com.earth2me.essentials.Essentials",
+		},
+		{
+			name: "version that looks like a number",
+			files: map[string]string{"plugin.yml": `name: Example
+version: 1.20
+author: someone
+`},
+			descriptor: "plugin.yml", plugin: "Example", version: "1.20", authors: []string{"someone"},
+		},
+		{
+			name: "paper-plugin.yml wins over plugin.yml",
+			files: map[string]string{
+				"plugin.yml":       "name: Legacy\nversion: 1.0\n",
+				"paper-plugin.yml": "name: Modern\nversion: 2.0\napi-version: '1.21'\n",
+			},
+			descriptor: "paper-plugin.yml", plugin: "Modern", version: "2.0", apiVersion: "1.21",
+		},
+		{
+			name:       "velocity-plugin.json",
+			files:      map[string]string{"velocity-plugin.json": `{"id":"luckperms","name":"LuckPerms","version":"5.4.145","authors":["Luck"]}`},
+			descriptor: "velocity-plugin.json", plugin: "LuckPerms", version: "5.4.145", authors: []string{"Luck"},
+		},
+		{
+			name:       "fabric.mod.json with author objects and no name",
+			files:      map[string]string{"fabric.mod.json": `{"id":"lithium","version":"0.14.3","authors":["JellySquid",{"name":"2No2Name"}]}`},
+			descriptor: "fabric.mod.json", plugin: "lithium", version: "0.14.3", authors: []string{"JellySquid", "2No2Name"},
+		},
+		{
+			name:       "quilt.mod.json",
+			files:      map[string]string{"quilt.mod.json": `{"quilt_loader":{"id":"qsl","version":"7.0.0","metadata":{"name":"Quilt Standard Libraries","contributors":{"Alice":"Owner","Bob":"Developer"}}}}`},
+			descriptor: "quilt.mod.json", plugin: "Quilt Standard Libraries", version: "7.0.0", authors: []string{"Alice", "Bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		jar := &ScannedJar{}
+		if err := readJarDescriptor(jar, testJar(t, tt.files)); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		sort.Strings(jar.Authors)
+		sort.Strings(tt.authors)
+		if jar.Descriptor != tt.descriptor || jar.Name != tt.plugin || jar.Version != tt.version ||
+			jar.APIVersion != tt.apiVersion || jar.main != tt.main || !reflect.DeepEqual(jar.Authors, tt.authors) {
+			t.Errorf("%s: got %s %q %q %v %q %q", tt.name, jar.Descriptor, jar.Name, jar.Version, jar.Authors, jar.APIVersion, jar.main)
+		}
+	}
+}
+
+func TestReadJarDescriptorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"not a zip", []byte("<html>not a jar</html>")},
+		{"no descriptor", testJar(t, map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n"})},
+		{"broken json", testJar(t, map[string]string{"fabric.mod.json": `{"id":`})},
+	}
+
+	for _, tt := range tests {
+		if err := readJarDescriptor(&ScannedJar{}, tt.content); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job states
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Finished jobs are kept around this long so clients can collect the result
+const jobRetention = 24 * time.Hour
+
+// JobState is the externally visible state of a background job
+type JobState struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	ServerID   string                 `json:"server_id"`
+	Status     string                 `json:"status"`
+	Message    string                 `json:"message,omitempty"`
+	Done       int64                  `json:"done,omitempty"`
+	Total      int64                  `json:"total,omitempty"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+}
+
+// Job tracks a long running operation (archiving, pulling files, ...) that
+// outlives the request which started it
+type Job struct {
+	mu    sync.Mutex
+	state JobState
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = map[string]*Job{}
+)
+
+// StartJob registers a job and runs fn in the background. fn reports
+// progress through the job and returns the result shown once it completes.
+func StartJob(jobType, serverID string, fn func(job *Job) (map[string]interface{}, error)) *Job {
+	now := time.Now()
+	job := &Job{state: JobState{
+		ID:        generateToken()[:16],
+		Type:      jobType,
+		ServerID:  serverID,
+		Status:    JobRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}
+
+	jobsMu.Lock()
+	for id, j := range jobs {
+		j.mu.Lock()
+		expired := j.state.FinishedAt != nil && now.Sub(*j.state.FinishedAt) > jobRetention
+		j.mu.Unlock()
+		if expired {
+			delete(jobs, id)
+		}
+	}
+	jobs[job.state.ID] = job
+	jobsMu.Unlock()
+
+	go func() {
+		result, err := fn(job)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		finished := time.Now()
+		job.state.FinishedAt = &finished
+		job.state.UpdatedAt = finished
+		job.state.Result = result
+		if err != nil {
+			job.state.Status = JobFailed
+			job.state.Error = err.Error()
+		} else {
+			job.state.Status = JobCompleted
+		}
+	}()
+
+	return job
+}
+
+// GetJob looks up a job by id
+func GetJob(id string) *Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return jobs[id]
+}
+
+// SetProgress updates how far along the job is. total may be 0 when unknown.
+func (j *Job) SetProgress(done, total int64, message string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state.Done = done
+	j.state.Total = total
+	if message != "" {
+		j.state.Message = message
+	}
+	j.state.UpdatedAt = time.Now()
+}
+
+// ID returns the job's identifier
+func (j *Job) ID() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state.ID
+}
+
+// Snapshot returns a copy of the job's current state
+func (j *Job) Snapshot() JobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// Wait blocks until the job finishes or timeout elapses and reports whether it finished
+func (j *Job) Wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		j.mu.Lock()
+		running := j.state.Status == JobRunning
+		j.mu.Unlock()
+		if !running {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// GetJobHandler returns the state of a background job
+func GetJobHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job := GetJob(c.Param("job"))
+		if job == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusOK, job.Snapshot())
+	}
+}
+
+// ListJobsHandler lists recent background jobs, optionally for one server
+func ListJobsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Query("server")
+
+		jobsMu.Lock()
+		list := make([]JobState, 0, len(jobs))
+		for _, j := range jobs {
+			snap := j.Snapshot()
+			if serverID == "" || snap.ServerID == serverID {
+				list = append(list, snap)
+			}
+		}
+		jobsMu.Unlock()
+
+		sort.Slice(list, func(a, b int) bool { return list[a].CreatedAt.After(list[b].CreatedAt) })
+		c.JSON(http.StatusOK, gin.H{"jobs": list})
+	}
+}
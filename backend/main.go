@@ -16,6 +16,10 @@ func main() {
 	// Try to auto-integrate with local Pterodactyl installation
 	CheckAutoIntegration(db)
 
+	InitPluginSources(db)
+	InitArtifactCache(db)
+	StartPluginUpdateChecker(db)
+
 	r := gin.Default()
 	r.Use(CORSMiddleware())
 
@@ -52,12 +56,64 @@ func main() {
 		api.POST("/servers/:id/files/upload", UploadFileHandler(db))
 		api.DELETE("/servers/:id/files", DeleteFileHandler(db))
 		api.GET("/servers/:id/files/download", DownloadFileHandler(db))
+		api.GET("/servers/:id/files/contents", ReadFileContentsHandler(db))
+		api.PUT("/servers/:id/files/contents", WriteFileContentsHandler(db))
+		api.POST("/servers/:id/files/compress", CompressFilesHandler(db))
+		api.POST("/servers/:id/files/decompress", DecompressFileHandler(db))
+		api.POST("/servers/:id/files/folder", CreateFolderHandler(db))
+		api.PUT("/servers/:id/files/rename", RenameFilesHandler(db))
+		api.POST("/servers/:id/files/copy", CopyFilesHandler(db))
+		api.POST("/servers/:id/files/chmod", ChmodFilesHandler(db))
+		api.GET("/servers/:id/files/search", SearchFilesHandler(db))
+		api.POST("/servers/:id/files/pull", PullFileHandler(db))
+		api.POST("/servers/:id/files/sync", SyncFilesHandler(db))
+		api.GET("/servers/:id/files/revisions", ListRevisionsHandler(db))
+		api.GET("/servers/:id/files/revisions/:rev", GetRevisionHandler(db))
+		api.GET("/servers/:id/files/revisions/:rev/diff", DiffRevisionHandler(db))
+		api.POST("/servers/:id/files/revisions/:rev/restore", RestoreRevisionHandler(db))
+
+		// Background jobs
+		api.GET("/jobs", ListJobsHandler())
+		api.GET("/jobs/:job", GetJobHandler())
+
+		// Structured config editors
+		api.GET("/servers/:id/configs", ListConfigsHandler(db))
+		api.GET("/servers/:id/configs/:name", GetConfigHandler(db))
+		api.PATCH("/servers/:id/configs/:name", UpdateConfigHandler(db))
 
 		// Plugins
-		api.GET("/plugins/search", SearchPluginsHandler())
+		api.GET("/plugins/search", SearchPluginsHandler(db))
+		api.GET("/plugins/:source/:slug/versions", ListPluginVersionsHandler(db))
+		api.GET("/servers/:id/platform", GetServerPlatformHandler(db))
+		api.POST("/servers/:id/plugins/plan", PlanPluginInstallHandler(db))
 		api.POST("/servers/:id/plugins/install", InstallPluginHandler(db))
 		api.GET("/servers/:id/plugins", ListInstalledPluginsHandler(db))
+		api.POST("/servers/:id/plugins/scan", ScanPluginsHandler(db))
+		api.POST("/servers/:id/plugins/upload", UploadPluginHandler(db))
+		api.GET("/servers/:id/plugins/updates", ListPluginUpdatesHandler(db))
+		api.POST("/servers/:id/plugins/update-all", UpdateAllPluginsHandler(db))
+		api.POST("/servers/:id/plugins/:plugin/update", UpdatePluginHandler(db))
 		api.DELETE("/servers/:id/plugins/:plugin", RemovePluginHandler(db))
+		api.GET("/mods/search", SearchModsHandler(db))
+		api.POST("/servers/:id/mods/install", InstallModHandler(db))
+		api.GET("/servers/:id/mods", ListInstalledModsHandler(db))
+		api.DELETE("/servers/:id/mods/:mod", RemoveModHandler(db))
+		api.GET("/modpacks/search", SearchModpacksHandler(db))
+		api.POST("/modpacks/plan", PlanModpackHandler(db))
+		api.POST("/modpacks/install", InstallModpackHandler(db))
+
+		// Plugin bundles
+		api.GET("/bundles", ListBundlesHandler(db))
+		api.POST("/bundles", CreateBundleHandler(db))
+		api.GET("/bundles/:bundle", GetBundleHandler(db))
+		api.PUT("/bundles/:bundle", UpdateBundleHandler(db))
+		api.DELETE("/bundles/:bundle", DeleteBundleHandler(db))
+		api.POST("/bundles/:bundle/plan", PlanBundleHandler(db))
+		api.POST("/bundles/:bundle/apply", ApplyBundleHandler(db))
+
+		// Downloaded plugin, mod and modpack files
+		api.GET("/cache/artifacts", ListArtifactCacheHandler(db))
+		api.DELETE("/cache/artifacts", PurgeArtifactCacheHandler(db))
 
 		// Eggs
 		api.GET("/eggs", GetEggsHandler(db))
@@ -105,7 +161,7 @@ func main() {
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -0,0 +1,898 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const modpackIndexFile = "modrinth.index.json"
+
+// How long to wait for Pterodactyl to run the egg's install script
+const serverInstallTimeout = 20 * time.Minute
+
+// Hosts the .mrpack format allows file downloads from
+var modpackDownloadHosts = map[string]bool{
+	"cdn.modrinth.com":          true,
+	"github.com":                true,
+	"raw.githubusercontent.com": true,
+	"gitlab.com":                true,
+}
+
+// Startup variables eggs use for the game and loader versions
+var (
+	gameVersionVariables   = []string{"MINECRAFT_VERSION", "MC_VERSION"}
+	loaderVersionVariables = map[string][]string{
+		PlatformFabric:   {"FABRIC_VERSION", "FABRIC_LOADER_VERSION", "LOADER_VERSION"},
+		PlatformQuilt:    {"QUILT_VERSION", "QUILT_LOADER_VERSION", "LOADER_VERSION"},
+		PlatformForge:    {"FORGE_VERSION"},
+		PlatformNeoForge: {"NEOFORGE_VERSION"},
+	}
+)
+
+// Keys of the dependencies object in modrinth.index.json
+var modpackLoaders = map[string]string{
+	"fabric-loader": PlatformFabric,
+	"quilt-loader":  PlatformQuilt,
+	"forge":         PlatformForge,
+	"neoforge":      PlatformNeoForge,
+}
+
+type mrpackFile struct {
+	Path   string            `json:"path"`
+	Hashes map[string]string `json:"hashes"`
+	Env    *struct {
+		Client string `json:"client"`
+		Server string `json:"server"`
+	} `json:"env"`
+	Downloads []string `json:"downloads"`
+	FileSize  int64    `json:"fileSize"`
+}
+
+type mrpackIndex struct {
+	FormatVersion int               `json:"formatVersion"`
+	Game          string            `json:"game"`
+	VersionID     string            `json:"versionId"`
+	Name          string            `json:"name"`
+	Files         []mrpackFile      `json:"files"`
+	Dependencies  map[string]string `json:"dependencies"`
+}
+
+// modpack is a parsed .mrpack, or a CurseForge server pack whose contents
+// are all overrides. Overrides maps server paths to the zip entry to write
+// there; server-overrides replace overrides of the same path.
+type modpack struct {
+	Index     mrpackIndex
+	Overrides map[string]*zip.File
+
+	// A CurseForge server pack stays in a temporary file and is uploaded to
+	// the server unchanged unless its entries need new names
+	serverPack       *os.File
+	repackServerPack bool
+}
+
+// Close removes the temporary file of a CurseForge server pack
+func (m *modpack) Close() {
+	if m.serverPack != nil {
+		removeTempFile(m.serverPack)
+		m.serverPack = nil
+	}
+}
+
+// Loader returns the pack's mod loader platform and loader version
+func (m *modpack) Loader() (string, string) {
+	for key, platform := range modpackLoaders {
+		if v := m.Index.Dependencies[key]; v != "" {
+			return platform, v
+		}
+	}
+	return "", ""
+}
+
+func (m *modpack) GameVersion() string {
+	return m.Index.Dependencies["minecraft"]
+}
+
+func openModpack(content []byte) (*modpack, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("not a valid .mrpack: %v", err)
+	}
+
+	pack := &modpack{Overrides: map[string]*zip.File{}}
+	var index *zip.File
+	var serverOverrides []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		switch {
+		case f.Name == modpackIndexFile:
+			index = f
+		case strings.HasPrefix(f.Name, "overrides/"):
+			if p, err := normalizeServerPath(strings.TrimPrefix(f.Name, "overrides/")); err == nil && p != "/" {
+				pack.Overrides[p] = f
+			}
+		case strings.HasPrefix(f.Name, "server-overrides/"):
+			serverOverrides = append(serverOverrides, f)
+		}
+	}
+	for _, f := range serverOverrides {
+		if p, err := normalizeServerPath(strings.TrimPrefix(f.Name, "server-overrides/")); err == nil && p != "/" {
+			pack.Overrides[p] = f
+		}
+	}
+	if index == nil {
+		return nil, fmt.Errorf("%s not found in pack", modpackIndexFile)
+	}
+
+	rc, err := index.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(io.LimitReader(rc, 16<<20)).Decode(&pack.Index); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", modpackIndexFile, err)
+	}
+	if pack.Index.FormatVersion != 1 {
+		return nil, fmt.Errorf("unsupported pack format version %d", pack.Index.FormatVersion)
+	}
+	if pack.Index.Game != "minecraft" {
+		return nil, fmt.Errorf("pack is for %q, not minecraft", pack.Index.Game)
+	}
+	return pack, nil
+}
+
+// downloadModrinthModpack fetches the .mrpack of a Modrinth modpack version,
+// the newest release when version is empty
+func downloadModrinthModpack(slug, version string, limit int64) ([]byte, error) {
+	candidates, err := modrinthArtifacts(slug)
+	if err != nil {
+		return nil, err
+	}
+	var pick *PluginArtifact
+	for i := range candidates {
+		a := &candidates[i]
+		if version != "" && a.Version != version {
+			continue
+		}
+		if pick == nil || channelRank(a.Channel) < channelRank(pick.Channel) {
+			pick = a
+		}
+	}
+	if pick == nil {
+		if version != "" {
+			return nil, fmt.Errorf("version %s not found", version)
+		}
+		return nil, fmt.Errorf("no downloadable versions found")
+	}
+	pick.Source, pick.Slug = "modrinth", slug
+	return downloadVerified(*pick, limit)
+}
+
+// downloadVerified downloads an artifact into memory, or reads it from the
+// artifact cache, checking it against the hashes its source published
+func downloadVerified(artifact PluginArtifact, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := copyVerified(&buf, artifact, limit); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// downloadVerifiedFile is downloadVerified for packs too large to hold in
+// memory. The content goes to a temporary file, which the caller removes
+// with removeTempFile.
+func downloadVerifiedFile(artifact PluginArtifact, limit int64) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "panelmanager-*.zip")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := copyVerified(f, artifact, limit)
+	if err != nil {
+		removeTempFile(f)
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+func removeTempFile(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// copyVerified writes an artifact to dst and checks it against its hashes
+func copyVerified(dst io.Writer, artifact PluginArtifact, limit int64) (int64, error) {
+	body, err := openArtifact(&artifact, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	var verifiers []*checksumVerifier
+	writers := []io.Writer{dst}
+	for algorithm, digest := range artifact.Hashes {
+		if v, err := newChecksumVerifier(algorithm + ":" + digest); err == nil && v != nil {
+			verifiers = append(verifiers, v)
+			writers = append(writers, v)
+		}
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), io.LimitReader(body, limit+1))
+	if err != nil {
+		return 0, fmt.Errorf("download failed: %v", err)
+	}
+	if n > limit {
+		return 0, fmt.Errorf("%s is larger than %d MB", artifact.FileName, limit>>20)
+	}
+	for _, v := range verifiers {
+		if err := v.Verify(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// ModpackPlan lists what installing a pack will do, for confirmation
+type ModpackPlan struct {
+	Name          string            `json:"name"`
+	Version       string            `json:"version"`
+	GameVersion   string            `json:"game_version"`
+	Loader        string            `json:"loader"`
+	LoaderVersion string            `json:"loader_version"`
+	Server        string            `json:"server,omitempty"` // empty when a new server is created
+	Files         []ModpackPlanFile `json:"files"`
+	ClientOnly    []string          `json:"client_only"`
+	Overrides     int               `json:"overrides"`
+	Overwrites    []string          `json:"overwrites"`
+	ExtraMods     []string          `json:"extra_mods"`
+	Variables     map[string]string `json:"variables"`
+	Reinstall     bool              `json:"reinstall"`
+	Problems      []string          `json:"problems"`
+}
+
+type ModpackPlanFile struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Optional bool   `json:"optional,omitempty"`
+
+	file   *mrpackFile
+	sha256 string // of the uploaded file, once installed
+}
+
+// Blocked reports whether the plan cannot be installed as-is
+func (p *ModpackPlan) Blocked() bool {
+	return len(p.Problems) > 0
+}
+
+// modpackVariables picks the startup variables to set for a pack out of the
+// ones available on the server or egg
+func modpackVariables(pack *modpack, available map[string]bool) map[string]string {
+	vars := map[string]string{}
+	loader, loaderVersion := pack.Loader()
+	gameVersion := pack.GameVersion()
+	for _, key := range gameVersionVariables {
+		if available[key] && gameVersion != "" {
+			vars[key] = gameVersion
+		}
+	}
+	for _, key := range loaderVersionVariables[loader] {
+		if !available[key] || loaderVersion == "" {
+			continue
+		}
+		// Forge eggs take the full Minecraft-Forge version
+		if loader == PlatformForge && gameVersion != "" && !strings.HasPrefix(loaderVersion, gameVersion+"-") {
+			vars[key] = gameVersion + "-" + loaderVersion
+		} else {
+			vars[key] = loaderVersion
+		}
+	}
+	return vars
+}
+
+// serverStartupVariables returns the editable startup variables of a server
+// and their current values
+func serverStartupVariables(client *PteroClient, serverID string) (map[string]string, error) {
+	data, err := client.Request("GET", "/api/client/servers/"+serverID+"/startup", nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Data []struct {
+			Attributes struct {
+				EnvVariable string `json:"env_variable"`
+				ServerValue string `json:"server_value"`
+				IsEditable  bool   `json:"is_editable"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse startup variables: %v", err)
+	}
+	vars := map[string]string{}
+	for _, v := range result.Data {
+		if v.Attributes.IsEditable {
+			vars[v.Attributes.EnvVariable] = v.Attributes.ServerValue
+		}
+	}
+	return vars, nil
+}
+
+// eggInfo returns an egg's name and the variables it defines
+func eggInfo(client *PteroClient, eggID int) (string, map[string]bool, error) {
+	data, err := client.Request("GET", fmt.Sprintf("/api/application/nests/1/eggs/%d?include=variables", eggID), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	var egg struct {
+		Attributes struct {
+			Name          string `json:"name"`
+			Relationships struct {
+				Variables struct {
+					Data []struct {
+						Attributes struct {
+							EnvVariable string `json:"env_variable"`
+						} `json:"attributes"`
+					} `json:"data"`
+				} `json:"variables"`
+			} `json:"relationships"`
+		} `json:"attributes"`
+	}
+	if err := json.Unmarshal(data, &egg); err != nil {
+		return "", nil, fmt.Errorf("failed to parse egg: %v", err)
+	}
+	vars := map[string]bool{}
+	for _, v := range egg.Attributes.Relationships.Variables.Data {
+		vars[v.Attributes.EnvVariable] = true
+	}
+	return egg.Attributes.Name, vars, nil
+}
+
+// buildModpackPlan works out what installing pack involves. serverID is the
+// server to install into; when it is empty egg is the egg of the server that
+// will be created.
+func buildModpackPlan(client, appClient *PteroClient, pack *modpack, serverID string, egg int, reinstall bool) *ModpackPlan {
+	loader, loaderVersion := pack.Loader()
+	plan := &ModpackPlan{
+		Name:          pack.Index.Name,
+		Version:       pack.Index.VersionID,
+		GameVersion:   pack.GameVersion(),
+		Loader:        loader,
+		LoaderVersion: loaderVersion,
+		Server:        serverID,
+		Files:         []ModpackPlanFile{},
+		ClientOnly:    []string{},
+		Overrides:     len(pack.Overrides),
+		Overwrites:    []string{},
+		ExtraMods:     []string{},
+		Variables:     map[string]string{},
+		Reinstall:     reinstall,
+		Problems:      []string{},
+	}
+	if loader == "" {
+		plan.Problems = append(plan.Problems, "Pack does not name a mod loader")
+	}
+
+	for i := range pack.Index.Files {
+		f := &pack.Index.Files[i]
+		p, err := normalizeServerPath(f.Path)
+		if err != nil || p == "/" {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("Pack file %q has an unsafe path", f.Path))
+			continue
+		}
+		if f.Env != nil && f.Env.Server == "unsupported" {
+			plan.ClientOnly = append(plan.ClientOnly, p)
+			continue
+		}
+		if f.Hashes["sha1"] == "" && f.Hashes["sha512"] == "" {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("%s has no hash to verify", p))
+		}
+		allowed := false
+		for _, d := range f.Downloads {
+			if u, err := url.Parse(d); err == nil && u.Scheme == "https" && modpackDownloadHosts[u.Host] {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("%s has no download from an allowed host", p))
+		}
+		plan.Files = append(plan.Files, ModpackPlanFile{
+			Path:     p,
+			Size:     f.FileSize,
+			Optional: f.Env != nil && f.Env.Server == "optional",
+			file:     f,
+		})
+	}
+
+	if serverID == "" {
+		name, vars, err := eggInfo(appClient, egg)
+		if err != nil {
+			plan.Problems = append(plan.Problems, "Could not read the egg: "+err.Error())
+			return plan
+		}
+		if platform := platformFromName(name); platform != loader {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("Egg %s does not install %s", name, loader))
+		}
+		plan.Variables = modpackVariables(pack, vars)
+		return plan
+	}
+
+	target, err := DetectServerPlatform(client, serverID, true)
+	if err != nil {
+		plan.Problems = append(plan.Problems, "Could not detect the server's platform: "+err.Error())
+	} else if target.Platform != loader {
+		plan.Problems = append(plan.Problems, fmt.Sprintf("Server runs %q but the pack needs %s", target.Platform, loader))
+	}
+	if current, err := serverStartupVariables(client, serverID); err == nil {
+		available := map[string]bool{}
+		for k := range current {
+			available[k] = true
+		}
+		for k, v := range modpackVariables(pack, available) {
+			if current[k] != v {
+				plan.Variables[k] = v
+			}
+		}
+	}
+
+	// Existing files the pack replaces, looked up one directory at a time
+	wanted := map[string][]string{}
+	for _, f := range plan.Files {
+		wanted[path.Dir(f.Path)] = append(wanted[path.Dir(f.Path)], path.Base(f.Path))
+	}
+	for p := range pack.Overrides {
+		wanted[path.Dir(p)] = append(wanted[path.Dir(p)], path.Base(p))
+	}
+	packMods := map[string]bool{}
+	for _, name := range wanted[modsDir] {
+		packMods[name] = true
+	}
+	for dir, names := range wanted {
+		entries, err := client.ListFiles(serverID, dir)
+		if err != nil {
+			continue
+		}
+		existing := map[string]bool{}
+		for _, e := range entries {
+			if e.IsFile {
+				existing[e.Name] = true
+				if dir == modsDir && !packMods[e.Name] && strings.HasSuffix(strings.ToLower(e.Name), ".jar") {
+					plan.ExtraMods = append(plan.ExtraMods, e.Name)
+				}
+			}
+		}
+		for _, name := range names {
+			if existing[name] {
+				plan.Overwrites = append(plan.Overwrites, path.Join(dir, name))
+			}
+		}
+	}
+	sort.Strings(plan.Overwrites)
+	sort.Strings(plan.ExtraMods)
+	return plan
+}
+
+// waitForServerInstall polls a server until Pterodactyl has finished running
+// its install script
+func waitForServerInstall(job *Job, client *PteroClient, serverID string) error {
+	deadline := time.Now().Add(serverInstallTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(archivePollEvery)
+		data, err := client.Request("GET", "/api/client/servers/"+serverID, nil)
+		if err != nil {
+			continue
+		}
+		var server struct {
+			Attributes struct {
+				Status       *string `json:"status"`
+				IsInstalling bool    `json:"is_installing"`
+			} `json:"attributes"`
+		}
+		if json.Unmarshal(data, &server) != nil {
+			continue
+		}
+		status := ""
+		if server.Attributes.Status != nil {
+			status = *server.Attributes.Status
+		}
+		if status == "install_failed" {
+			return fmt.Errorf("the server's install script failed")
+		}
+		if !server.Attributes.IsInstalling && status != "installing" {
+			return nil
+		}
+		job.SetProgress(0, 0, "Waiting for the server to install")
+	}
+	return fmt.Errorf("timed out waiting for the server to install")
+}
+
+// uploadModpackOverrides uploads the override files to the server root as
+// one zip and has Wings extract it there. A CurseForge server pack is
+// uploaded as it is when possible; otherwise the override entries are copied,
+// still compressed, into a zip that is written while it uploads.
+func uploadModpackOverrides(job *Job, client *PteroClient, serverID string, pack *modpack) error {
+	if len(pack.Overrides) == 0 {
+		return nil
+	}
+
+	var body io.Reader
+	if pack.serverPack != nil && !pack.repackServerPack {
+		if _, err := pack.serverPack.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = pack.serverPack
+	} else {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeOverridesZip(pw, pack.Overrides))
+		}()
+		defer pr.Close()
+		body = pr
+	}
+
+	archive := fmt.Sprintf(".panelmanager-overrides-%d.zip", time.Now().UnixNano())
+	if err := client.UploadFile(serverID, "/", archive, body); err != nil {
+		return fmt.Errorf("failed to upload overrides: %v", err)
+	}
+	defer client.DeleteFiles(serverID, "/", []string{archive})
+
+	job.SetProgress(0, 0, fmt.Sprintf("Extracting %d override files", len(pack.Overrides)))
+	if err := client.DecompressFile(serverID, "/", archive); err != nil {
+		if !archiveStillRunning(err) {
+			return fmt.Errorf("failed to extract overrides: %v", err)
+		}
+		if _, err := waitForStableDirectory(job, client, serverID, "/"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOverridesZip writes the override entries into a zip under their
+// server paths without decompressing them
+func writeOverridesZip(w io.Writer, overrides map[string]*zip.File) error {
+	zw := zip.NewWriter(w)
+	for p, f := range overrides {
+		header := f.FileHeader
+		header.Name = strings.TrimPrefix(p, "/")
+		out, err := zw.CreateRaw(&header)
+		if err != nil {
+			return err
+		}
+		in, err := f.OpenRaw()
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			return fmt.Errorf("failed to repack overrides: %v", err)
+		}
+	}
+	return zw.Close()
+}
+
+// recordModpackMods tracks the pack's mods in installed_mods, identified on
+// Modrinth by their sha1 hashes
+func recordModpackMods(db *sql.DB, serverID, loader string, files []ModpackPlanFile) {
+	byHash := map[string]ModpackPlanFile{}
+	var hashes []string
+	for _, f := range files {
+		if path.Dir(f.Path) == modsDir && f.file.Hashes["sha1"] != "" {
+			byHash[f.file.Hashes["sha1"]] = f
+			hashes = append(hashes, f.file.Hashes["sha1"])
+		}
+	}
+	if len(hashes) == 0 {
+		return
+	}
+
+	versions, err := modrinthVersionsByHash(hashes)
+	if err != nil {
+		log.Printf("[WARN] Modrinth hash lookup failed: %v", err)
+		return
+	}
+
+	var ids []string
+	for _, v := range versions {
+		ids = append(ids, v.ProjectID)
+	}
+	encoded, _ := json.Marshal(ids)
+	var projects []modProject
+	if err := getJSON("https://api.modrinth.com/v2/projects?ids="+url.QueryEscape(string(encoded)), &projects); err != nil {
+		return
+	}
+	byID := map[string]modProject{}
+	for _, p := range projects {
+		byID[p.ID] = p
+	}
+
+	for hash, v := range versions {
+		project, ok := byID[v.ProjectID]
+		if !ok {
+			continue
+		}
+		f := byHash[hash]
+		db.Exec(`INSERT OR REPLACE INTO installed_mods (server_id, source, slug, title, version, loader, file_name, file_hash, client_side, server_side)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			serverID, "modrinth", project.Slug, project.Title, v.VersionNumber, loader, path.Base(f.Path), f.sha256, project.ClientSide, project.ServerSide)
+	}
+}
+
+// installModpack carries out a plan: it creates or prepares the server,
+// downloads every server-side file with hash verification, applies the
+// overrides and records the mods. Text files it replaces are kept as
+// revisions on behalf of author.
+func installModpack(job *Job, db *sql.DB, client, appClient *PteroClient, pack *modpack, plan *ModpackPlan, newServer *CreateServerRequest, author string) (map[string]interface{}, error) {
+	serverID := plan.Server
+	result := map[string]interface{}{"plan": plan}
+
+	if newServer != nil {
+		job.SetProgress(0, 0, "Creating server "+newServer.Name)
+		allocationID, err := getOrCreateAllocation(appClient, newServer.NodeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get an allocation: %v", err)
+		}
+		data, err := createServer(appClient, *newServer, allocationID, plan.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create server: %v", err)
+		}
+		var created struct {
+			Attributes struct {
+				Identifier string `json:"identifier"`
+			} `json:"attributes"`
+		}
+		if json.Unmarshal(data, &created) != nil || created.Attributes.Identifier == "" {
+			return nil, fmt.Errorf("failed to parse the created server")
+		}
+		serverID = created.Attributes.Identifier
+		result["server"] = serverID
+		if err := waitForServerInstall(job, client, serverID); err != nil {
+			return nil, err
+		}
+	} else {
+		for key, value := range plan.Variables {
+			_, err := client.Request("PUT", "/api/client/servers/"+serverID+"/startup/variable", map[string]string{"key": key, "value": value})
+			if err != nil {
+				return nil, fmt.Errorf("failed to set %s: %v", key, err)
+			}
+		}
+		if plan.Reinstall {
+			job.SetProgress(0, 0, "Reinstalling the server")
+			if _, err := client.Request("POST", "/api/client/servers/"+serverID+"/settings/reinstall", nil); err != nil {
+				return nil, fmt.Errorf("failed to reinstall: %v", err)
+			}
+			if err := waitForServerInstall(job, client, serverID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var total int64
+	for _, f := range plan.Files {
+		total += f.Size
+	}
+	var done int64
+	for i, f := range plan.Files {
+		job.SetProgress(done, total, "Downloading "+f.Path)
+		err := keepCurrentRevision(db, client, serverID, f.Path, author)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Path, err)
+		}
+		for _, d := range f.file.Downloads {
+			u, perr := url.Parse(d)
+			if perr != nil || u.Scheme != "https" || !modpackDownloadHosts[u.Host] {
+				continue
+			}
+			artifact := &PluginArtifact{URL: d, FileName: path.Base(f.Path), Hashes: map[string]string{}}
+			for _, algorithm := range []string{"sha1", "sha512"} {
+				if h := f.file.Hashes[algorithm]; h != "" {
+					artifact.Hashes[algorithm] = h
+				}
+			}
+			var hash string
+			if _, hash, err = uploadArtifact(db, client, serverID, path.Dir(f.Path), path.Base(f.Path), artifact); err == nil {
+				plan.Files[i].sha256 = hash
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Path, err)
+		}
+		done += f.Size
+	}
+
+	if err := uploadModpackOverrides(job, client, serverID, pack); err != nil {
+		return nil, err
+	}
+
+	job.SetProgress(total, total, "Recording mods")
+	recordModpackMods(db, serverID, plan.Loader, plan.Files)
+
+	platformCache.Lock()
+	delete(platformCache.entries, serverID)
+	platformCache.Unlock()
+
+	result["files"] = len(plan.Files)
+	result["overrides"] = len(pack.Overrides)
+	return result, nil
+}
+
+type modpackRequest struct {
+	Source    string               `json:"source"` // modrinth or curseforge
+	Slug      string               `json:"slug"`
+	Version   string               `json:"version"`
+	Server    string               `json:"server"`
+	NewServer *CreateServerRequest `json:"new_server"`
+	Reinstall bool                 `json:"reinstall"`
+	Force     bool                 `json:"force"`
+}
+
+// modpackFromRequest reads a modpack request, either JSON naming a Modrinth
+// or CurseForge modpack or a multipart upload with the .mrpack in "file" and the request
+// as JSON in "options", and builds its plan. It writes the error response
+// itself when that fails.
+func modpackFromRequest(c *gin.Context, db *sql.DB) (*modpackRequest, *PteroClient, *PteroClient, *modpack, *ModpackPlan, bool) {
+	var req modpackRequest
+	limit := int64(GetSettingInt(db, "upload_max_file_mb", defaultUploadMaxFileMB)) << 20
+	var content []byte
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		if options := c.PostForm("options"); options != "" {
+			if err := json.Unmarshal([]byte(options), &req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid options: " + err.Error()})
+				return nil, nil, nil, nil, nil, false
+			}
+		}
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No .mrpack file uploaded"})
+			return nil, nil, nil, nil, nil, false
+		}
+		if header.Size > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Pack is larger than %d MB", limit>>20)})
+			return nil, nil, nil, nil, nil, false
+		}
+		f, err := header.Open()
+		if err == nil {
+			content, err = io.ReadAll(f)
+			f.Close()
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, nil, nil, nil, nil, false
+		}
+	} else {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, nil, nil, nil, nil, false
+		}
+		if req.Slug == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "slug or an uploaded .mrpack is required"})
+			return nil, nil, nil, nil, nil, false
+		}
+	}
+
+	if (req.Server == "") == (req.NewServer == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Give either server or new_server"})
+		return nil, nil, nil, nil, nil, false
+	}
+
+	client, err := NewPteroClientAPI(db)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, nil, nil, nil, false
+	}
+	var appClient *PteroClient
+	if req.NewServer != nil {
+		if appClient, err = NewPteroClient(db); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, nil, nil, nil, nil, false
+		}
+	}
+
+	var pack *modpack
+	switch {
+	case content != nil:
+		if pack, err = openModpack(content); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, nil, nil, nil, nil, false
+		}
+	case req.Source == "curseforge":
+		if pack, err = openCurseForgeModpack(db, req.Slug, req.Version, limit); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return nil, nil, nil, nil, nil, false
+		}
+	default:
+		if content, err = downloadModrinthModpack(req.Slug, req.Version, limit); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return nil, nil, nil, nil, nil, false
+		}
+		if pack, err = openModpack(content); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, nil, nil, nil, nil, false
+		}
+	}
+
+	egg := 0
+	if req.NewServer != nil {
+		egg = req.NewServer.EggID
+	}
+	plan := buildModpackPlan(client, appClient, pack, req.Server, egg, req.Reinstall)
+	return &req, client, appClient, pack, plan, true
+}
+
+// SearchModpacksHandler searches Modrinth, or another source with ?source=
+// (all for every source), for modpacks
+func SearchModpacksHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		source := c.DefaultQuery("source", "modrinth")
+		q, err := searchQueryFromRequest(c, KindModpack, c.Query("version"), &ServerPlatform{Platform: c.Query("loader")})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		page, failures, err := searchSources(source, q)
+		if err != nil {
+			c.JSON(searchErrorStatus(source), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"results": page.Results,
+			"total":   page.Total,
+			"offset":  q.Offset,
+			"limit":   q.Limit,
+			"errors":  failures,
+		})
+	}
+}
+
+// PlanModpackHandler shows what installing a modpack would do without
+// changing anything
+func PlanModpackHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, _, _, pack, plan, ok := modpackFromRequest(c, db)
+		if !ok {
+			return
+		}
+		pack.Close()
+		c.JSON(http.StatusOK, gin.H{"plan": plan})
+	}
+}
+
+// InstallModpackHandler installs a Modrinth modpack into an existing server
+// or a new one as a background job. Plans with problems are refused with
+// 409 unless force is set.
+func InstallModpackHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, client, appClient, pack, plan, ok := modpackFromRequest(c, db)
+		if !ok {
+			return
+		}
+		if plan.Blocked() && !req.Force {
+			pack.Close()
+			c.JSON(http.StatusConflict, gin.H{"error": "Modpack cannot be installed as planned", "plan": plan})
+			return
+		}
+
+		author := CurrentUsername(c, db)
+		job := StartJob("modpack-install", req.Server, func(job *Job) (map[string]interface{}, error) {
+			defer pack.Close()
+			return installModpack(job, db, client, appClient, pack, plan, req.NewServer, author)
+		})
+
+		respondWithJob(c, job)
+	}
+}
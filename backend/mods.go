@@ -0,0 +1,301 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const modsDir = "/mods"
+
+type InstalledMod struct {
+	Source      string `json:"source"`
+	Slug        string `json:"slug"`
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Loader      string `json:"loader"`
+	FileName    string `json:"file_name"`
+	FileHash    string `json:"file_hash"`
+	Size        int64  `json:"size,omitempty"`
+	ClientSide  string `json:"client_side"`
+	ServerSide  string `json:"server_side"`
+	InstalledAt string `json:"installed_at,omitempty"`
+}
+
+// modProject describes a mod project the way Modrinth does. CurseForge
+// projects are mapped onto it, using the project id as slug.
+type modProject struct {
+	ID          string `json:"id"`
+	Slug        string `json:"slug"`
+	Title       string `json:"title"`
+	ProjectType string `json:"project_type"`
+	ClientSide  string `json:"client_side"`
+	ServerSide  string `json:"server_side"`
+}
+
+func getModrinthProject(slug string) (*modProject, error) {
+	var project modProject
+	if err := getJSON("https://api.modrinth.com/v2/project/"+url.PathEscape(slug), &project); err != nil {
+		return nil, fmt.Errorf("modrinth project %s: %w", slug, err)
+	}
+	return &project, nil
+}
+
+// SearchModsHandler searches Modrinth, or another source with ?source= (all
+// for every source), for mods. With ?server= the server's loader and
+// Minecraft version are used as filters; ?loader= and ?version= override
+// them. Paging, sorting and categories work as for plugins.
+func SearchModsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target := serverPlatformOrNil(db, c.Query("server"))
+		if target == nil {
+			target = &ServerPlatform{}
+		}
+		if loader := c.Query("loader"); loader != "" {
+			target = &ServerPlatform{Platform: loader, Version: target.Version}
+		}
+		version := c.Query("version")
+		if version == "" {
+			version = target.Version
+		}
+
+		source := c.DefaultQuery("source", "modrinth")
+		q, err := searchQueryFromRequest(c, KindMod, version, target)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		page, failures, err := searchSources(source, q)
+		if err != nil {
+			c.JSON(searchErrorStatus(source), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"results": page.Results,
+			"total":   page.Total,
+			"offset":  q.Offset,
+			"limit":   q.Limit,
+			"errors":  failures,
+			"version": version,
+			"loader":  target.Platform,
+		})
+	}
+}
+
+// missingModDependencies lists the required dependencies of a mod version
+// that are not installed on the server. Mods are not resolved recursively;
+// the caller is told what else to install.
+func missingModDependencies(db *sql.DB, serverID string, artifact *PluginArtifact) []map[string]string {
+	missing := []map[string]string{}
+	deps := artifact.Dependencies
+	if source, err := GetPluginSource(artifact.Source); err == nil {
+		if named, err := source.Dependencies(artifact); err == nil {
+			deps = named
+		}
+	}
+	for _, dep := range deps {
+		if dep.Type != DependencyRequired || dep.Slug == "" {
+			continue
+		}
+		var found int
+		db.QueryRow("SELECT COUNT(*) FROM installed_mods WHERE server_id = ? AND slug = ?", serverID, dep.Slug).Scan(&found)
+		if found == 0 {
+			missing = append(missing, map[string]string{"source": dep.Source, "slug": dep.Slug, "title": dep.Name})
+		}
+	}
+	return missing
+}
+
+// InstallModHandler installs a Modrinth or CurseForge mod into /mods of a
+// Fabric, Quilt, Forge or NeoForge server. The version is picked for the
+// server's loader and game version. Client-only mods (unsupported on servers
+// on Modrinth, files tagged only Client on CurseForge) are refused unless
+// force is set.
+func InstallModHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		var req struct {
+			Source  string `json:"source"`
+			Slug    string `json:"slug" binding:"required"`
+			Version string `json:"version"`
+			Force   bool   `json:"force"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		target, err := DetectServerPlatform(client, serverID, false)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Could not detect platform: " + err.Error()})
+			return
+		}
+		if !target.IsModded() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Server runs %q, which does not load mods", target.Platform)})
+			return
+		}
+
+		var project *modProject
+		switch req.Source {
+		case "", "modrinth":
+			req.Source = "modrinth"
+			project, err = getModrinthProject(req.Slug)
+		case "curseforge":
+			var name string
+			if name, err = curseForgeModName(db, req.Slug); err != nil {
+				err = fmt.Errorf("curseforge project %s: %w", req.Slug, err)
+			}
+			project = &modProject{ID: req.Slug, Slug: req.Slug, Title: name}
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown mod source " + req.Source})
+			return
+		}
+		if err != nil {
+			// Offline installs from the artifact cache go ahead without
+			// the project's details
+			if !sourceUnavailable(err) || cachedFallback(req.Source, req.Slug, req.Version, target, err) == nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+				return
+			}
+			project = &modProject{ID: req.Slug, Slug: req.Slug, Title: req.Slug}
+		}
+		if project.ProjectType != "" && project.ProjectType != "mod" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is a %s, not a mod", project.Title, project.ProjectType)})
+			return
+		}
+
+		artifact, err := resolvePluginDownload(req.Source, project.Slug, req.Version, target)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		if artifact.ClientOnly {
+			project.ClientSide, project.ServerSide = "required", "unsupported"
+		}
+		if project.ServerSide == "unsupported" && !req.Force {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":       fmt.Sprintf("%s is client-only and does nothing on a server", project.Title),
+				"client_only": true,
+			})
+			return
+		}
+
+		mod, err := installModJar(db, client, serverID, target, project, artifact)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Mod install failed: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":              "Mod installed",
+			"mod":                  mod,
+			"warning":              artifact.Warning,
+			"missing_dependencies": missingModDependencies(db, serverID, artifact),
+		})
+	}
+}
+
+// installModJar uploads a mod into /mods under the file name its author gave
+// it and records it, removing the jar of a previously installed version
+func installModJar(db *sql.DB, client *PteroClient, serverID string, target *ServerPlatform, project *modProject, artifact *PluginArtifact) (*InstalledMod, error) {
+	fileName := unsafeFileNameChars.ReplaceAllString(artifact.FileName, "_")
+	if !strings.HasSuffix(strings.ToLower(fileName), ".jar") {
+		fileName = pluginFileName(project.Slug, artifact.Version)
+	}
+	mod := &InstalledMod{
+		Source:     artifact.Source,
+		Slug:       project.Slug,
+		Title:      project.Title,
+		Version:    artifact.Version,
+		Loader:     target.Platform,
+		FileName:   fileName,
+		ClientSide: project.ClientSide,
+		ServerSide: project.ServerSide,
+	}
+
+	size, hash, err := uploadArtifact(db, client, serverID, modsDir, fileName, artifact)
+	if err != nil {
+		return nil, err
+	}
+	mod.Size, mod.FileHash = size, hash
+
+	var previous string
+	db.QueryRow("SELECT file_name FROM installed_mods WHERE server_id = ? AND slug = ?", serverID, mod.Slug).Scan(&previous)
+	if previous != "" && previous != fileName {
+		if err := client.DeleteFiles(serverID, modsDir, []string{previous}); err != nil && pteroStatus(err) != http.StatusNotFound {
+			log.Printf("[WARN] Failed to remove old jar %s from %s: %v", previous, serverID, err)
+		}
+	}
+
+	_, err = db.Exec(`INSERT OR REPLACE INTO installed_mods (server_id, source, slug, title, version, loader, file_name, file_hash, client_side, server_side)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		serverID, mod.Source, mod.Slug, mod.Title, mod.Version, mod.Loader, mod.FileName, mod.FileHash, mod.ClientSide, mod.ServerSide)
+	if err != nil {
+		return nil, err
+	}
+	return mod, nil
+}
+
+func ListInstalledModsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rows, err := db.Query(`SELECT source, slug, title, version, loader, file_name, file_hash, client_side, server_side, installed_at
+			FROM installed_mods WHERE server_id = ? ORDER BY title`, c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		mods := []InstalledMod{}
+		for rows.Next() {
+			var m InstalledMod
+			rows.Scan(&m.Source, &m.Slug, &m.Title, &m.Version, &m.Loader, &m.FileName, &m.FileHash, &m.ClientSide, &m.ServerSide, &m.InstalledAt)
+			mods = append(mods, m)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"mods": mods})
+	}
+}
+
+// RemoveModHandler deletes a mod's jar from /mods and forgets it
+func RemoveModHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		slug := c.Param("mod")
+
+		var fileName string
+		err := db.QueryRow("SELECT file_name FROM installed_mods WHERE server_id = ? AND slug = ?", serverID, slug).Scan(&fileName)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Mod is not installed"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := client.DeleteFiles(serverID, modsDir, []string{fileName}); err != nil && pteroStatus(err) != http.StatusNotFound {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to delete " + fileName + ": " + err.Error()})
+			return
+		}
+
+		db.Exec("DELETE FROM installed_mods WHERE server_id = ? AND slug = ?", serverID, slug)
+		c.JSON(http.StatusOK, gin.H{"message": "Mod removed", "file_name": fileName})
+	}
+}
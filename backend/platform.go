@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a detected platform is reused before the server is inspected again
+const platformCacheTTL = 10 * time.Minute
+
+// Server platforms PanelManager knows how to install plugins and mods for
+const (
+	PlatformPaper    = "paper"
+	PlatformPurpur   = "purpur"
+	PlatformFolia    = "folia"
+	PlatformSpigot   = "spigot"
+	PlatformVelocity = "velocity"
+	PlatformFabric   = "fabric"
+	PlatformQuilt    = "quilt"
+	PlatformForge    = "forge"
+	PlatformNeoForge = "neoforge"
+)
+
+// Checked in order, so forks come before the platform they are based on
+var platformNames = []struct {
+	match    string
+	platform string
+}{
+	{"purpur", PlatformPurpur},
+	{"folia", PlatformFolia},
+	{"paper", PlatformPaper},
+	{"spigot", PlatformSpigot},
+	{"bukkit", PlatformSpigot},
+	{"velocity", PlatformVelocity},
+	{"quilt", PlatformQuilt},
+	{"fabric", PlatformFabric},
+	{"neoforge", PlatformNeoForge},
+	{"forge", PlatformForge},
+}
+
+// Files in the server root that only a particular platform creates
+var platformMarkers = []struct {
+	file     string
+	platform string
+}{
+	{"purpur.yml", PlatformPurpur},
+	{"velocity.toml", PlatformVelocity},
+	{".fabric", PlatformFabric},
+	{"user_jvm_args.txt", PlatformForge},
+	{"paper.yml", PlatformPaper},
+	{"spigot.yml", PlatformSpigot},
+}
+
+var (
+	mcVersionPattern = regexp.MustCompile(`(?:^|[^\d.]|mc\.)(1\.\d{1,2}(?:\.\d{1,2})?)(?:[^\d.]|\.[a-z]|$)`)
+	neoForgeVersion  = regexp.MustCompile(`neoforge-(\d{2})\.(\d+)\.`)
+	// "git-Purpur-2062 (MC: 1.20.4)", or without the fork name since Paper
+	// 1.20.5: "1.21.4-232-main@a0fbb5d (MC: 1.21.4)"
+	versionHistoryPattern = regexp.MustCompile(`(?:git-(\w+)-)?\S+ \(MC: ([\d.]+)\)`)
+)
+
+type ServerPlatform struct {
+	ServerID   string    `json:"server_id"`
+	Platform   string    `json:"platform"`
+	Version    string    `json:"version"`
+	Build      string    `json:"build,omitempty"`
+	Egg        string    `json:"egg,omitempty"`
+	Evidence   []string  `json:"evidence"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// IsProxy reports whether the server is a proxy rather than a game server
+func (s *ServerPlatform) IsProxy() bool {
+	return s.Platform == PlatformVelocity
+}
+
+// IsModded reports whether the server loads mods from /mods rather than
+// plugins
+func (s *ServerPlatform) IsModded() bool {
+	switch s.Platform {
+	case PlatformFabric, PlatformQuilt, PlatformForge, PlatformNeoForge:
+		return true
+	}
+	return false
+}
+
+// Loaders lists the Modrinth loader categories whose plugins run on this
+// platform, best match first. Forks fall back to their upstream.
+func (s *ServerPlatform) Loaders() []string {
+	switch s.Platform {
+	case PlatformPurpur:
+		return []string{"purpur", "paper", "spigot", "bukkit"}
+	case PlatformPaper:
+		return []string{"paper", "spigot", "bukkit"}
+	case PlatformFolia:
+		return []string{"folia"}
+	case PlatformSpigot:
+		return []string{"spigot", "bukkit"}
+	case PlatformQuilt:
+		return []string{"quilt", "fabric"}
+	case "":
+		return nil
+	}
+	return []string{s.Platform}
+}
+
+// HangarPlatform is the platform name Hangar uses for this server, or ""
+// for modded servers, which Hangar has nothing for
+func (s *ServerPlatform) HangarPlatform() string {
+	if s.IsProxy() {
+		return "VELOCITY"
+	}
+	if s.IsModded() {
+		return ""
+	}
+	return "PAPER"
+}
+
+func platformFromName(name string) string {
+	name = strings.ToLower(name)
+	for _, p := range platformNames {
+		if strings.Contains(name, p.match) {
+			return p.platform
+		}
+	}
+	return ""
+}
+
+func mcVersionFromName(name string) string {
+	name = strings.ToLower(name)
+	// NeoForge versions drop the leading "1." of the game version: 21.1.x is 1.21.1
+	if m := neoForgeVersion.FindStringSubmatch(name); m != nil {
+		if m[2] == "0" {
+			return "1." + m[1]
+		}
+		return "1." + m[1] + "." + m[2]
+	}
+	if m := mcVersionPattern.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+var platformCache = struct {
+	sync.Mutex
+	entries map[string]*ServerPlatform
+}{entries: map[string]*ServerPlatform{}}
+
+// DetectServerPlatform works out which Minecraft version and server platform
+// a server runs. The version_history.json Paper-based servers write on start
+// is trusted most; after that the version comes from the MINECRAFT_VERSION or
+// MC_VERSION variable, then jar names, and the platform from jar names, the
+// egg name and platform-specific files. Results are cached per server unless
+// refresh is set.
+func DetectServerPlatform(client *PteroClient, serverID string, refresh bool) (*ServerPlatform, error) {
+	platformCache.Lock()
+	cached := platformCache.entries[serverID]
+	platformCache.Unlock()
+	if cached != nil && !refresh && time.Since(cached.DetectedAt) < platformCacheTTL {
+		return cached, nil
+	}
+
+	data, err := client.Request("GET", "/api/client/servers/"+serverID+"?include=egg,variables", nil)
+	if err != nil {
+		return nil, err
+	}
+	var server struct {
+		Attributes struct {
+			Relationships struct {
+				Egg struct {
+					Attributes struct {
+						Name string `json:"name"`
+					} `json:"attributes"`
+				} `json:"egg"`
+				Variables struct {
+					Data []struct {
+						Attributes struct {
+							EnvVariable string `json:"env_variable"`
+							ServerValue string `json:"server_value"`
+						} `json:"attributes"`
+					} `json:"data"`
+				} `json:"variables"`
+			} `json:"relationships"`
+		} `json:"attributes"`
+	}
+	if err := json.Unmarshal(data, &server); err != nil {
+		return nil, fmt.Errorf("failed to parse server details: %v", err)
+	}
+
+	result := &ServerPlatform{
+		ServerID:   serverID,
+		Egg:        server.Attributes.Relationships.Egg.Attributes.Name,
+		Evidence:   []string{},
+		DetectedAt: time.Now(),
+	}
+	setPlatform := func(platform, evidence string) {
+		if result.Platform == "" && platform != "" {
+			result.Platform = platform
+			result.Evidence = append(result.Evidence, "platform from "+evidence)
+		}
+	}
+	setVersion := func(version, evidence string) {
+		if result.Version == "" && version != "" {
+			result.Version = version
+			result.Evidence = append(result.Evidence, "version from "+evidence)
+		}
+	}
+
+	// Only Paper and its forks write version_history.json
+	paperHistory := false
+	if content, err := client.ReadFile(serverID, "/version_history.json", 64<<10); err == nil {
+		var history struct {
+			CurrentVersion string `json:"currentVersion"`
+		}
+		json.Unmarshal(content, &history)
+		if m := versionHistoryPattern.FindStringSubmatch(history.CurrentVersion); m != nil {
+			setPlatform(platformFromName(m[1]), "version_history.json")
+			setVersion(m[2], "version_history.json")
+			paperHistory = true
+		}
+	}
+
+	vars := map[string]string{}
+	for _, v := range server.Attributes.Relationships.Variables.Data {
+		vars[v.Attributes.EnvVariable] = strings.TrimSpace(v.Attributes.ServerValue)
+	}
+	for _, key := range []string{"MINECRAFT_VERSION", "MC_VERSION"} {
+		if v := vars[key]; v != "" && !strings.EqualFold(v, "latest") {
+			setVersion(v, key)
+		}
+	}
+	if build := vars["BUILD_NUMBER"]; build != "" && !strings.EqualFold(build, "latest") {
+		result.Build = build
+	}
+
+	root, _ := client.ListFiles(serverID, "/")
+	present := map[string]bool{}
+	for _, f := range root {
+		present[f.Name] = true
+		if !f.IsFile || !strings.HasSuffix(strings.ToLower(f.Name), ".jar") {
+			continue
+		}
+		platform := platformFromName(f.Name)
+		if platform == "" {
+			continue
+		}
+		setPlatform(platform, f.Name)
+		if platform != PlatformVelocity {
+			setVersion(mcVersionFromName(f.Name), f.Name)
+		}
+	}
+	if jar := vars["SERVER_JARFILE"]; jar != "" {
+		setPlatform(platformFromName(jar), "SERVER_JARFILE")
+	}
+
+	setPlatform(platformFromName(result.Egg), "egg "+result.Egg)
+	for _, m := range platformMarkers {
+		if present[m.file] {
+			setPlatform(m.platform, m.file)
+		}
+	}
+	if present["config"] && result.Platform == "" {
+		if _, err := client.StatFile(serverID, "/config/paper-global.yml"); err == nil {
+			setPlatform(PlatformPaper, "config/paper-global.yml")
+		}
+	}
+	if paperHistory {
+		setPlatform(PlatformPaper, "version_history.json")
+	}
+
+	if result.IsProxy() {
+		result.Version = ""
+	}
+
+	platformCache.Lock()
+	platformCache.entries[serverID] = result
+	platformCache.Unlock()
+
+	return result, nil
+}
+
+// serverPlatformOrNil detects a server's platform for callers that can carry
+// on without it
+func serverPlatformOrNil(db *sql.DB, serverID string) *ServerPlatform {
+	if serverID == "" {
+		return nil
+	}
+	client, err := NewPteroClientAPI(db)
+	if err != nil {
+		return nil
+	}
+	platform, err := DetectServerPlatform(client, serverID, false)
+	if err != nil {
+		return nil
+	}
+	return platform
+}
+
+// GetServerPlatformHandler reports the detected Minecraft version and
+// platform of a server. ?refresh=true skips the cache.
+func GetServerPlatformHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		platform, err := DetectServerPlatform(client, c.Param("id"), c.Query("refresh") == "true")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, platform)
+	}
+}
@@ -1,58 +1,191 @@
 package main
 
 import (
+	"bufio"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type PluginResult struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Downloads   int    `json:"downloads"`
-	Version     string `json:"version"`
-	Source      string `json:"source"`
-	Slug        string `json:"slug"`
-	IconURL     string `json:"icon_url"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Downloads   int      `json:"downloads"`
+	Version     string   `json:"version"`
+	Source      string   `json:"source"`
+	Slug        string   `json:"slug"`
+	IconURL     string   `json:"icon_url"`
+	ClientSide  string   `json:"client_side,omitempty"` // Modrinth only: required, optional or unsupported
+	ServerSide  string   `json:"server_side,omitempty"`
+	Categories  []string `json:"categories,omitempty"`
+	UpdatedAt   string   `json:"updated_at,omitempty"`
+	// Other sources the same project was found on when searching them all
+	AlsoOn []PluginResultRef `json:"also_on,omitempty"`
+	// Results elsewhere with the same name that could not be confirmed as
+	// the same project
+	SameName []PluginResultRef `json:"same_name,omitempty"`
+
+	// Hashes of the latest files as "algorithm:digest", used to recognise
+	// the same project across sources
+	fileHashes    []string
+	latestVersion string
 }
 
-func SearchPluginsHandler() gin.HandlerFunc {
+type PluginResultRef struct {
+	Source string `json:"source"`
+	Slug   string `json:"slug"`
+}
+
+// SearchPluginsHandler searches a plugin source, or every source at once
+// with source=all. When ?server= is given the server's detected Minecraft
+// version and platform are used as filters unless the query overrides the
+// version. Results are paged with ?offset= and ?limit=, ordered by ?sort=
+// (relevance, downloads or updated) and filtered by ?category=; total is -1
+// when the source does not report one.
+func SearchPluginsHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		query := c.Query("q")
 		source := c.DefaultQuery("source", "hangar")
-		mcVersion := c.DefaultQuery("version", "1.21.4")
+		mcVersion := c.Query("version")
 
-		var results []PluginResult
+		target := serverPlatformOrNil(db, c.Query("server"))
+		if target == nil {
+			target = &ServerPlatform{Platform: c.Query("platform")}
+		}
+		if mcVersion == "" {
+			mcVersion = target.Version
+		}
 
-		switch source {
-		case "hangar":
-			results = searchHangar(query, mcVersion)
-		case "modrinth":
-			results = searchModrinth(query, mcVersion, "plugin")
-		case "spigot":
-			results = searchSpigot(query)
+		q, err := searchQueryFromRequest(c, KindPlugin, mcVersion, target)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		page, failures, err := searchSources(source, q)
+		if err != nil {
+			c.JSON(searchErrorStatus(source), gin.H{"error": err.Error()})
+			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"results": results})
+		c.JSON(http.StatusOK, gin.H{
+			"results":  page.Results,
+			"total":    page.Total,
+			"offset":   q.Offset,
+			"limit":    q.Limit,
+			"errors":   failures,
+			"version":  mcVersion,
+			"platform": target.Platform,
+		})
 	}
 }
 
-func searchHangar(query, version string) []PluginResult {
-	url := fmt.Sprintf("https://hangar.papermc.io/api/v1/projects?q=%s&limit=20&platform=PAPER&version=%s",
-		url.QueryEscape(query), version)
+// PluginVersion is a version of a project as listed to the user
+type PluginVersion struct {
+	PluginArtifact
+	// Compatible reports whether the version supports the server's loader
+	// and game version
+	Compatible bool `json:"compatible"`
+}
+
+// ListPluginVersionsHandler lists the versions a source offers for a plugin,
+// mod or modpack, newest first, with the game versions and loaders each
+// supports. With ?server= (or ?platform= and ?version=) each is marked
+// compatible or not and the version an install would pick is named.
+func ListPluginVersionsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		source, slug := c.Param("source"), c.Param("slug")
+		s, err := GetPluginSource(source)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		target := serverPlatformOrNil(db, c.Query("server"))
+		if target == nil {
+			target = &ServerPlatform{Platform: c.Query("platform")}
+		}
+		if v := c.Query("version"); v != "" {
+			// The detected platform is shared through the cache; override a copy
+			t := *target
+			t.Version = v
+			target = &t
+		}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil
+		candidates, err := s.Versions(slug, target)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+
+		loaders := target.Loaders()
+		versions := []PluginVersion{}
+		for _, a := range candidates {
+			a.Source, a.Slug = source, slug
+			compatible := loadersOverlap(a.Loaders, loaders)
+			if d, ok := closestGameVersion(a.GameVersions, target.Version); target.Version != "" && len(a.GameVersions) > 0 && (!ok || d != 0) {
+				compatible = false
+			}
+			versions = append(versions, PluginVersion{PluginArtifact: a, Compatible: compatible})
+		}
+
+		response := gin.H{"versions": versions, "platform": target}
+		if pick, err := selectArtifact(candidates, "", target); err == nil {
+			response["recommended"] = pick.Version
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// searchErrorStatus is the status a failed search is reported with: the
+// caller's fault for an unknown source, the source's otherwise
+func searchErrorStatus(source string) int {
+	if _, err := GetPluginSource(source); err != nil && source != "all" {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// Hangar sort orders by search sort; relevance is Hangar's default
+var hangarSorts = map[string]string{
+	SortDownloads: "-downloads",
+	SortUpdated:   "-updated",
+}
+
+func searchHangar(q SearchQuery) (*SearchPage, error) {
+	params := url.Values{}
+	params.Set("q", q.Text)
+	params.Set("offset", strconv.Itoa(q.Offset))
+	params.Set("limit", strconv.Itoa(min(q.Limit, 25)))
+	if platform := q.Target.HangarPlatform(); platform != "" {
+		params.Set("platform", platform)
+	}
+	if q.Version != "" {
+		params.Set("version", q.Version)
+	}
+	if sort := hangarSorts[q.Sort]; sort != "" {
+		params.Set("sort", sort)
+	}
+	for _, category := range q.Categories {
+		params.Add("category", category)
 	}
-	defer resp.Body.Close()
 
 	var data struct {
+		Pagination struct {
+			Count int `json:"count"`
+		} `json:"pagination"`
 		Result []struct {
 			Name        string `json:"name"`
 			Description string `json:"description"`
@@ -62,69 +195,158 @@ func searchHangar(query, version string) []PluginResult {
 			Namespace struct {
 				Slug string `json:"slug"`
 			} `json:"namespace"`
-			AvatarURL string `json:"avatarUrl"`
+			AvatarURL   string `json:"avatarUrl"`
+			Category    string `json:"category"`
+			LastUpdated string `json:"lastUpdated"`
 		} `json:"result"`
 	}
-	json.NewDecoder(resp.Body).Decode(&data)
+	if err := getJSON("https://hangar.papermc.io/api/v1/projects?"+params.Encode(), &data); err != nil {
+		return nil, err
+	}
 
-	var results []PluginResult
+	page := &SearchPage{Results: []PluginResult{}, Total: data.Pagination.Count}
 	for _, p := range data.Result {
-		results = append(results, PluginResult{
+		page.Results = append(page.Results, PluginResult{
 			Name:        p.Name,
 			Description: p.Description,
 			Downloads:   p.Stats.Downloads,
 			Source:      "hangar",
 			Slug:        p.Namespace.Slug,
 			IconURL:     p.AvatarURL,
+			Categories:  []string{p.Category},
+			UpdatedAt:   p.LastUpdated,
 		})
 	}
-	return results
+	return page, nil
 }
 
-func searchModrinth(query, version, projectType string) []PluginResult {
-	facets := fmt.Sprintf(`[["project_type:%s"],["versions:%s"]]`, projectType, version)
-	url := fmt.Sprintf("https://api.modrinth.com/v2/search?query=%s&facets=%s&limit=20",
-		url.QueryEscape(query), url.QueryEscape(facets))
+// modrinthFacets builds a search facet list. Entries inside one group are
+// ORed, groups are ANDed, so a project must be in every category asked for.
+func modrinthFacets(projectType, version string, loaders, categories []string) string {
+	facets := [][]string{{"project_type:" + projectType}}
+	if version != "" {
+		facets = append(facets, []string{"versions:" + version})
+	}
+	if len(loaders) > 0 {
+		group := make([]string, len(loaders))
+		for i, l := range loaders {
+			group[i] = "categories:" + l
+		}
+		facets = append(facets, group)
+	}
+	for _, category := range categories {
+		facets = append(facets, []string{"categories:" + category})
+	}
+	encoded, _ := json.Marshal(facets)
+	return string(encoded)
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil
+func searchModrinth(q SearchQuery, loaders []string) (*SearchPage, error) {
+	params := url.Values{}
+	params.Set("query", q.Text)
+	params.Set("facets", modrinthFacets(q.Kind, q.Version, loaders, q.Categories))
+	params.Set("offset", strconv.Itoa(q.Offset))
+	params.Set("limit", strconv.Itoa(q.Limit))
+	if q.Sort != "" {
+		params.Set("index", q.Sort) // relevance, downloads and updated are Modrinth's own names
 	}
-	defer resp.Body.Close()
 
 	var data struct {
-		Hits []struct {
-			Title       string `json:"title"`
-			Description string `json:"description"`
-			Downloads   int    `json:"downloads"`
-			Slug        string `json:"slug"`
-			IconURL     string `json:"icon_url"`
+		TotalHits int `json:"total_hits"`
+		Hits      []struct {
+			Title         string   `json:"title"`
+			Description   string   `json:"description"`
+			Downloads     int      `json:"downloads"`
+			Slug          string   `json:"slug"`
+			IconURL       string   `json:"icon_url"`
+			ClientSide    string   `json:"client_side"`
+			ServerSide    string   `json:"server_side"`
+			Categories    []string `json:"display_categories"`
+			DateModified  string   `json:"date_modified"`
+			LatestVersion string   `json:"latest_version"`
 		} `json:"hits"`
 	}
-	json.NewDecoder(resp.Body).Decode(&data)
+	if err := getJSON("https://api.modrinth.com/v2/search?"+params.Encode(), &data); err != nil {
+		return nil, err
+	}
 
-	var results []PluginResult
+	page := &SearchPage{Results: []PluginResult{}, Total: data.TotalHits}
 	for _, p := range data.Hits {
-		results = append(results, PluginResult{
+		page.Results = append(page.Results, PluginResult{
 			Name:        p.Title,
 			Description: p.Description,
 			Downloads:   p.Downloads,
 			Source:      "modrinth",
 			Slug:        p.Slug,
 			IconURL:     p.IconURL,
+			ClientSide:  p.ClientSide,
+			ServerSide:  p.ServerSide,
+			Categories:  p.Categories,
+			UpdatedAt:   p.DateModified,
+
+			latestVersion: p.LatestVersion,
 		})
 	}
-	return results
+	return page, nil
 }
 
-func searchSpigot(query string) []PluginResult {
-	url := fmt.Sprintf("https://api.spiget.org/v2/search/resources/%s?size=20", url.QueryEscape(query))
-
-	resp, err := http.Get(url)
-	if err != nil {
+// fillModrinthHashes looks up the files of the latest version of each
+// Modrinth result in one request
+func fillModrinthHashes(results []PluginResult) error {
+	var ids []string
+	for _, r := range results {
+		if r.Source == "modrinth" && r.latestVersion != "" {
+			ids = append(ids, r.latestVersion)
+		}
+	}
+	if len(ids) == 0 {
 		return nil
 	}
-	defer resp.Body.Close()
+	encoded, _ := json.Marshal(ids)
+	var versions []struct {
+		ID    string `json:"id"`
+		Files []struct {
+			Hashes map[string]string `json:"hashes"`
+		} `json:"files"`
+	}
+	if err := getJSON("https://api.modrinth.com/v2/versions?ids="+url.QueryEscape(string(encoded)), &versions); err != nil {
+		return err
+	}
+	hashes := map[string][]string{}
+	for _, v := range versions {
+		for _, f := range v.Files {
+			if sha1 := f.Hashes["sha1"]; sha1 != "" {
+				hashes[v.ID] = append(hashes[v.ID], "sha1:"+sha1)
+			}
+		}
+	}
+	for i := range results {
+		if results[i].Source == "modrinth" {
+			results[i].fileHashes = hashes[results[i].latestVersion]
+		}
+	}
+	return nil
+}
+
+// Spiget sort orders by search sort; relevance is Spiget's default
+var spigotSorts = map[string]string{
+	SortDownloads: "-downloads",
+	SortUpdated:   "-updateDate",
+}
+
+// searchSpigot searches SpigotMC through Spiget. Spiget pages by page
+// number, so the offset is rounded down to a whole page, and its search
+// cannot filter by category or report a total.
+func searchSpigot(q SearchQuery) (*SearchPage, error) {
+	if len(q.Categories) > 0 {
+		return nil, fmt.Errorf("category filters are not supported")
+	}
+	params := url.Values{}
+	params.Set("size", strconv.Itoa(q.Limit))
+	params.Set("page", strconv.Itoa(q.Offset/q.Limit+1))
+	if sort := spigotSorts[q.Sort]; sort != "" {
+		params.Set("sort", sort)
+	}
 
 	var data []struct {
 		Name string `json:"name"`
@@ -133,129 +355,266 @@ func searchSpigot(query string) []PluginResult {
 		Icon struct {
 			URL string `json:"url"`
 		} `json:"icon"`
-		Downloads int `json:"downloads"`
+		Downloads  int   `json:"downloads"`
+		UpdateDate int64 `json:"updateDate"`
+	}
+	err := getJSON(fmt.Sprintf("https://api.spiget.org/v2/search/resources/%s?%s", url.PathEscape(q.Text), params.Encode()), &data)
+	if err != nil && !errors.Is(err, errNotFound) { // Spiget answers 404 when nothing matches
+		return nil, err
 	}
-	json.NewDecoder(resp.Body).Decode(&data)
 
-	var results []PluginResult
+	page := &SearchPage{Results: []PluginResult{}, Total: -1}
 	for _, p := range data {
-		results = append(results, PluginResult{
+		page.Results = append(page.Results, PluginResult{
 			Name:        p.Name,
 			Description: p.Tag,
 			Downloads:   p.Downloads,
 			Source:      "spigot",
 			Slug:        fmt.Sprintf("%d", p.ID),
 			IconURL:     "https://www.spigotmc.org/" + p.Icon.URL,
+			UpdatedAt:   time.Unix(p.UpdateDate, 0).UTC().Format(time.RFC3339),
 		})
 	}
-	return results
+	return page, nil
 }
 
-func InstallPluginHandler(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		serverID := c.Param("id")
-		var req struct {
-			Source  string `json:"source"`
-			Slug    string `json:"slug"`
-			Version string `json:"version"`
-		}
-		c.ShouldBindJSON(&req)
-
-		// Get download URL based on source
-		var downloadURL string
-		switch req.Source {
-		case "hangar":
-			downloadURL = getHangarDownload(req.Slug, req.Version)
-		case "modrinth":
-			downloadURL = getModrinthDownload(req.Slug, req.Version)
-		case "spigot":
-			downloadURL = getSpigotDownload(req.Slug)
-		}
-
-		if downloadURL == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not get download URL"})
-			return
-		}
+// Directory plugins are installed into
+const pluginsDir = "/plugins"
 
-		// Download the plugin
-		resp, err := http.Get(downloadURL)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+type InstalledPlugin struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Source   string `json:"source"`
+	FileName string `json:"file_name"`
+	FileHash string `json:"file_hash"`
+	Size     int64  `json:"size"`
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// pluginFileName builds the deterministic jar name a plugin is stored under
+func pluginFileName(slug, version string) string {
+	name := unsafeFileNameChars.ReplaceAllString(slug, "_")
+	if version != "" {
+		name += "-" + unsafeFileNameChars.ReplaceAllString(version, "_")
+	}
+	return strings.Trim(name, "._-") + ".jar"
+}
+
+// uploadArtifact downloads an artifact, or takes it from the artifact cache,
+// and uploads it into dir on the server as fileName, then checks the upload landed and matches the hashes
+// the source published. It returns the uploaded size and sha256.
+func uploadArtifact(db *sql.DB, client *PteroClient, serverID, dir, fileName string, artifact *PluginArtifact) (int64, string, error) {
+	var verifiers []*checksumVerifier
+	writers := []io.Writer{}
+	for algorithm, digest := range artifact.Hashes {
+		v, err := newChecksumVerifier(algorithm + ":" + digest)
+		if err != nil || v == nil {
+			continue
 		}
-		defer resp.Body.Close()
+		verifiers = append(verifiers, v)
+		writers = append(writers, v)
+	}
 
-		pluginData, _ := io.ReadAll(resp.Body)
+	limit := GetSettingInt(db, "upload_max_file_mb", defaultUploadMaxFileMB) << 20
+	content, err := openArtifact(artifact, int64(limit))
+	if err != nil {
+		return 0, "", err
+	}
+	defer content.Close()
 
-		// Upload to server via Pterodactyl
-		client, err := NewPteroClient(db)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+	// Jars are zip files; anything else is usually an HTML page for an
+	// externally hosted download
+	body := bufio.NewReader(content)
+	if magic, err := body.Peek(4); strings.HasSuffix(strings.ToLower(fileName), ".jar") && (err != nil || string(magic) != "PK\x03\x04") {
+		return 0, "", fmt.Errorf("download from %s is not a jar file", artifact.URL)
+	}
+
+	hash := sha256.New()
+	writers = append(writers, hash)
+	counted := &sizeLimitedReader{r: io.TeeReader(body, io.MultiWriter(writers...)), limit: limit}
+	if err := client.UploadFile(serverID, dir, fileName, counted); err != nil {
+		if err == errFileTooLarge || counted.n > limit {
+			client.DeleteFiles(serverID, dir, []string{fileName})
+			return 0, "", fmt.Errorf("file is larger than the %d MB upload limit", limit>>20)
 		}
+		client.DeleteFiles(serverID, dir, []string{fileName})
+		return 0, "", fmt.Errorf("upload failed: %v", err)
+	}
 
-		// Get upload URL
-		uploadData, _ := client.Request("GET", "/api/client/servers/"+serverID+"/files/upload", nil)
-		var uploadResp struct {
-			Attributes struct {
-				URL string `json:"url"`
-			} `json:"attributes"`
+	// Never leave a partial jar behind for the server to load
+	stat, err := client.StatFile(serverID, path.Join(dir, fileName))
+	if err != nil {
+		client.DeleteFiles(serverID, dir, []string{fileName})
+		return 0, "", fmt.Errorf("could not verify upload: %v", err)
+	}
+	if stat.Size != counted.n {
+		client.DeleteFiles(serverID, dir, []string{fileName})
+		return 0, "", fmt.Errorf("uploaded %s is %d bytes, expected %d", fileName, stat.Size, counted.n)
+	}
+	for _, v := range verifiers {
+		if err := v.Verify(); err != nil {
+			client.DeleteFiles(serverID, dir, []string{fileName})
+			return 0, "", err
 		}
-		json.Unmarshal(uploadData, &uploadResp)
+	}
+	return counted.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-		// Upload plugin (simplified - actual implementation needs multipart form)
-		_ = pluginData
-		_ = uploadResp
+// installPluginJar uploads a plugin jar into the plugins folder and records
+// it. A previously installed jar of the same plugin under another name is
+// removed.
+func installPluginJar(db *sql.DB, client *PteroClient, serverID string, artifact *PluginArtifact) (*InstalledPlugin, error) {
+	source, slug, version := artifact.Source, artifact.Slug, artifact.Version
+	plugin := &InstalledPlugin{
+		Name:     slug,
+		Version:  version,
+		Source:   source,
+		FileName: pluginFileName(slug, version),
+	}
 
-		// Save to database
-		db.Exec("INSERT INTO installed_plugins (server_id, plugin_name, plugin_version, source) VALUES (?, ?, ?, ?)",
-			serverID, req.Slug, req.Version, req.Source)
+	size, hash, err := uploadArtifact(db, client, serverID, pluginsDir, plugin.FileName, artifact)
+	if err != nil {
+		return nil, err
+	}
+	plugin.Size, plugin.FileHash = size, hash
+
+	var previous string
+	db.QueryRow("SELECT file_name FROM installed_plugins WHERE server_id = ? AND plugin_name = ? AND source = ?",
+		serverID, slug, source).Scan(&previous)
+	if previous != "" && previous != plugin.FileName {
+		if err := client.DeleteFiles(serverID, pluginsDir, []string{previous}); err != nil && pteroStatus(err) != http.StatusNotFound {
+			log.Printf("[WARN] Failed to remove old jar %s from %s: %v", previous, serverID, err)
+		}
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Plugin installed"})
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	tx.Exec("DELETE FROM installed_plugins WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, slug, source)
+	_, err = tx.Exec("INSERT INTO installed_plugins (server_id, plugin_name, plugin_version, source, file_name, file_hash) VALUES (?, ?, ?, ?, ?, ?)",
+		serverID, slug, version, source, plugin.FileName, plugin.FileHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
+
+	return plugin, nil
 }
 
-func getHangarDownload(slug, version string) string {
-	url := fmt.Sprintf("https://hangar.papermc.io/api/v1/projects/%s/versions/%s/PAPER/download", slug, version)
-	return url
+type pluginInstallRequest struct {
+	Source          string   `json:"source" binding:"required"`
+	Slug            string   `json:"slug" binding:"required"`
+	Version         string   `json:"version"`
+	IncludeOptional bool     `json:"include_optional"`
+	Optional        []string `json:"optional"`
+	Force           bool     `json:"force"`
 }
 
-func getModrinthDownload(slug, version string) string {
-	resp, _ := http.Get(fmt.Sprintf("https://api.modrinth.com/v2/project/%s/version", slug))
-	defer resp.Body.Close()
+// planFromRequest binds an install request and builds its plan, writing the
+// error response itself when that fails
+func planFromRequest(c *gin.Context, db *sql.DB) (*pluginInstallRequest, *PteroClient, *ServerPlatform, *InstallPlan, bool) {
+	serverID := c.Param("id")
+	var req pluginInstallRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, nil, nil, false
+	}
 
-	var versions []struct {
-		Files []struct {
-			URL string `json:"url"`
-		} `json:"files"`
+	client, err := NewPteroClientAPI(db)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, nil, nil, false
 	}
-	json.NewDecoder(resp.Body).Decode(&versions)
 
-	if len(versions) > 0 && len(versions[0].Files) > 0 {
-		return versions[0].Files[0].URL
+	target, err := DetectServerPlatform(client, serverID, false)
+	if err != nil {
+		log.Printf("[WARN] Could not detect platform of %s: %v", serverID, err)
+	}
+
+	plan, err := buildInstallPlan(db, client, serverID, target, req.Source, req.Slug, req.Version, req.IncludeOptional, req.Optional)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not get download URL: " + err.Error()})
+		return nil, nil, nil, nil, false
+	}
+	return &req, client, target, plan, true
+}
+
+// PlanPluginInstallHandler shows what installing a plugin would do: the
+// version picked and every dependency, whether required or optional and
+// whether it is already installed
+func PlanPluginInstallHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, _, target, plan, ok := planFromRequest(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"plan": plan, "blocked": plan.Blocked(), "platform": target})
 	}
-	return ""
 }
 
-func getSpigotDownload(id string) string {
-	return fmt.Sprintf("https://api.spiget.org/v2/resources/%s/download", id)
+// InstallPluginHandler installs a plugin together with the dependencies it
+// needs. Plans with problems are refused unless force is set; if any plugin
+// in the set fails to install, none of them stay installed.
+func InstallPluginHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		req, client, target, plan, ok := planFromRequest(c, db)
+		if !ok {
+			return
+		}
+		if plan.Blocked() && !req.Force {
+			c.JSON(http.StatusConflict, gin.H{"error": "Plugin cannot be installed as planned", "plan": plan})
+			return
+		}
+
+		installed, err := installPlan(db, client, serverID, plan)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Plugin install failed: " + err.Error(), "plan": plan})
+			return
+		}
+
+		root := plan.Items[len(plan.Items)-1]
+		response := gin.H{
+			"message":   "Plugin installed",
+			"plugin":    installed[len(installed)-1],
+			"installed": installed,
+			"plan":      plan,
+			"platform":  target,
+		}
+		if root.Message != "" {
+			response["warning"] = root.Message
+		}
+		c.JSON(http.StatusOK, response)
+	}
 }
 
 func ListInstalledPluginsHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		serverID := c.Param("id")
-		rows, _ := db.Query("SELECT plugin_name, plugin_version, source, installed_at FROM installed_plugins WHERE server_id = ?", serverID)
+		rows, _ := db.Query(`SELECT p.plugin_name, p.plugin_version, p.source, p.file_name, p.file_hash, p.installed_at, COALESCE(u.latest_version, '')
+			FROM installed_plugins p LEFT JOIN plugin_updates u
+			ON u.server_id = p.server_id AND u.plugin_name = p.plugin_name AND u.source = p.source
+			WHERE p.server_id = ?`, serverID)
 		defer rows.Close()
 
 		var plugins []map[string]interface{}
 		for rows.Next() {
-			var name, version, source, installedAt string
-			rows.Scan(&name, &version, &source, &installedAt)
+			var name, version, source, fileName, fileHash, installedAt, latest string
+			rows.Scan(&name, &version, &source, &fileName, &fileHash, &installedAt, &latest)
 			plugins = append(plugins, map[string]interface{}{
-				"name":         name,
-				"version":      version,
-				"source":       source,
-				"installed_at": installedAt,
+				"name":             name,
+				"version":          version,
+				"source":           source,
+				"file_name":        fileName,
+				"file_hash":        fileHash,
+				"installed_at":     installedAt,
+				"update_available": latest != "",
+				"latest_version":   latest,
 			})
 		}
 
@@ -263,12 +622,173 @@ func ListInstalledPluginsHandler(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
+// pluginDataFolder finds the folder a plugin keeps its data in. Plugins name
+// it after themselves rather than their project slug, so folders are matched
+// loosely against the slug.
+func pluginDataFolder(client *PteroClient, serverID, slug string) string {
+	entries, err := client.ListFiles(serverID, pluginsDir)
+	if err != nil {
+		return ""
+	}
+	want := normalizePluginName(slug)
+	for _, e := range entries {
+		if !e.IsFile && normalizePluginName(e.Name) == want {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+// RemovePluginHandler deletes a plugin's jar and forgets it. ?data=archive
+// packs its data folder into the backup folder and ?data=delete removes it;
+// the default keeps it. Plugins other installed plugins require are only
+// removed with ?force=true.
 func RemovePluginHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		serverID := c.Param("id")
-		plugin := c.Param("plugin")
+		name := c.Param("plugin")
+		dataMode := c.DefaultQuery("data", "keep")
+		if dataMode != "keep" && dataMode != "archive" && dataMode != "delete" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "data must be keep, archive or delete"})
+			return
+		}
+		dataFolder := c.Query("data_folder")
+		if strings.ContainsAny(dataFolder, "/\\") || dataFolder == "." || dataFolder == ".." {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "data_folder must be a folder name inside /plugins"})
+			return
+		}
+
+		plugins, err := installedPluginRows(db, serverID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var plugin *installedPluginRow
+		for i := range plugins {
+			if plugins[i].Name == name && (c.Query("source") == "" || plugins[i].Source == c.Query("source")) {
+				plugin = &plugins[i]
+				break
+			}
+		}
+		if plugin == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plugin is not installed"})
+			return
+		}
+
+		var dependents []string
+		rows, err := db.Query(`SELECT d.plugin_name FROM plugin_dependencies d
+			JOIN installed_plugins p ON p.server_id = d.server_id AND p.plugin_name = d.plugin_name AND p.source = d.source
+			WHERE d.server_id = ? AND d.depends_on = ? AND d.depends_on_source = ? AND d.required = 1`,
+			serverID, plugin.Name, plugin.Source)
+		if err == nil {
+			for rows.Next() {
+				var dependent string
+				rows.Scan(&dependent)
+				dependents = append(dependents, dependent)
+			}
+			rows.Close()
+		}
+		if len(dependents) > 0 && c.Query("force") != "true" {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":      "Other installed plugins require " + plugin.Name,
+				"dependents": dependents,
+			})
+			return
+		}
 
-		db.Exec("DELETE FROM installed_plugins WHERE server_id = ? AND plugin_name = ?", serverID, plugin)
-		c.JSON(http.StatusOK, gin.H{"message": "Plugin removed"})
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		p := *plugin
+		job := StartJob("plugin-remove", serverID, func(job *Job) (map[string]interface{}, error) {
+			result := map[string]interface{}{"plugin": p.Name, "source": p.Source, "dependents": dependents}
+
+			if p.FileName != "" {
+				job.SetProgress(0, 0, "Deleting "+p.FileName)
+				err := client.DeleteFiles(serverID, pluginsDir, []string{p.FileName})
+				if err != nil && pteroStatus(err) != http.StatusNotFound {
+					return nil, fmt.Errorf("failed to delete %s: %v", p.FileName, err)
+				}
+				result["jar"] = path.Join(pluginsDir, p.FileName)
+			}
+
+			if dataMode != "keep" {
+				folder := dataFolder
+				if folder == "" {
+					folder = pluginDataFolder(client, serverID, p.Name)
+				}
+				if folder == "" {
+					result["data_error"] = "Data folder not found; pass data_folder to name it"
+				} else if err := removePluginData(job, client, serverID, folder, dataMode, result); err != nil {
+					result["data_error"] = err.Error()
+				}
+			}
+
+			db.Exec("DELETE FROM installed_plugins WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, p.Name, p.Source)
+			db.Exec("DELETE FROM plugin_dependencies WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, p.Name, p.Source)
+			db.Exec("DELETE FROM plugin_updates WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, p.Name, p.Source)
+
+			job.SetProgress(1, 1, "Plugin removed")
+			return result, nil
+		})
+
+		respondWithJob(c, job)
+	}
+}
+
+// removePluginData archives a plugin data folder into the backup folder
+// before deleting it, or just deletes it
+func removePluginData(job *Job, client *PteroClient, serverID, folder, mode string, result map[string]interface{}) error {
+	if mode == "archive" {
+		job.SetProgress(0, 0, "Archiving "+folder)
+		before := map[string]bool{}
+		if entries, err := client.ListFiles(serverID, pluginsDir); err == nil {
+			for _, e := range entries {
+				before[e.Name] = true
+			}
+		}
+		started := time.Now()
+
+		archive, err := client.CompressFiles(serverID, pluginsDir, []string{folder})
+		if err != nil {
+			if !archiveStillRunning(err) {
+				return fmt.Errorf("failed to archive %s: %v", folder, err)
+			}
+			if archive, err = waitForNewArchive(job, client, serverID, pluginsDir, before, started); err != nil {
+				return err
+			}
+		}
+
+		backup := path.Join(pluginBackupDir, fmt.Sprintf("%s-%s%s", folder, started.Format("20060102-150405"), archiveExtension(archive.Name)))
+		client.CreateFolder(serverID, path.Dir(pluginBackupDir), path.Base(pluginBackupDir))
+		err = client.RenameFiles(serverID, []map[string]string{{
+			"from": relativeToRoot(path.Join(pluginsDir, archive.Name)),
+			"to":   relativeToRoot(backup),
+		}})
+		if err != nil {
+			return fmt.Errorf("archived %s but could not move the archive: %v", folder, err)
+		}
+		result["data_archive"] = backup
+	}
+
+	job.SetProgress(0, 0, "Deleting "+folder)
+	if err := client.DeleteFiles(serverID, pluginsDir, []string{folder}); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", folder, err)
+	}
+	result["data_deleted"] = path.Join(pluginsDir, folder)
+	return nil
+}
+
+// archiveExtension returns an archive name's extension, keeping ".tar.gz" whole
+func archiveExtension(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tar.xz"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[len(name)-len(ext):]
+		}
 	}
+	return path.Ext(name)
 }
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Replaced jars are moved here; Bukkit-based servers only load jars directly
+// inside /plugins
+const pluginBackupDir = "/plugins/.backups"
+
+// Hours between background update checks unless plugin_update_interval_hours says otherwise
+const defaultPluginUpdateIntervalHours = 12
+
+type PluginUpdate struct {
+	Name           string `json:"name"`
+	Source         string `json:"source"`
+	CurrentVersion string `json:"current_version"`
+	LatestVersion  string `json:"latest_version"`
+	Channel        string `json:"channel"`
+	CheckedAt      string `json:"checked_at,omitempty"`
+}
+
+type installedPluginRow struct {
+	ID       int64
+	Name     string
+	Version  string
+	Source   string
+	FileName string
+	FileHash string
+}
+
+func installedPluginRows(db *sql.DB, serverID string) ([]installedPluginRow, error) {
+	rows, err := db.Query("SELECT id, plugin_name, plugin_version, source, file_name, file_hash FROM installed_plugins WHERE server_id = ?", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plugins []installedPluginRow
+	for rows.Next() {
+		var p installedPluginRow
+		rows.Scan(&p.ID, &p.Name, &p.Version, &p.Source, &p.FileName, &p.FileHash)
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// newerArtifact returns the version a plugin should be updated to, or nil
+// when the installed one is current. Only versions that fit the server
+// without a compatibility warning count, and only if they are listed ahead
+// of the installed version.
+func newerArtifact(candidates []PluginArtifact, current string, target *ServerPlatform) *PluginArtifact {
+	best, err := selectArtifact(candidates, "", target)
+	if err != nil || best.Warning != "" || best.Version == current {
+		return nil
+	}
+
+	bestIndex, currentIndex := -1, -1
+	for i := range candidates {
+		if &candidates[i] == best {
+			bestIndex = i
+		}
+		if candidates[i].Version == current && currentIndex < 0 {
+			currentIndex = i
+		}
+	}
+	if currentIndex >= 0 && bestIndex > currentIndex {
+		return nil
+	}
+	return best
+}
+
+// checkPluginUpdates asks each installed plugin's source for a newer
+// compatible version and stores the result in plugin_updates
+func checkPluginUpdates(db *sql.DB, client *PteroClient, serverID string) ([]PluginUpdate, error) {
+	plugins, err := installedPluginRows(db, serverID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := DetectServerPlatform(client, serverID, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not detect platform: %v", err)
+	}
+
+	updates := []PluginUpdate{}
+	for _, p := range plugins {
+		if p.Source == SourceManual || p.Source == SourceUpload {
+			continue
+		}
+		candidates, err := pluginArtifacts(p.Source, p.Name, target)
+		if err != nil {
+			log.Printf("[WARN] Update check for %s on %s failed: %v", p.Name, serverID, err)
+			continue
+		}
+		if latest := newerArtifact(candidates, p.Version, target); latest != nil {
+			updates = append(updates, PluginUpdate{
+				Name:           p.Name,
+				Source:         p.Source,
+				CurrentVersion: p.Version,
+				LatestVersion:  latest.Version,
+				Channel:        latest.Channel,
+			})
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	tx.Exec("DELETE FROM plugin_updates WHERE server_id = ?", serverID)
+	for _, u := range updates {
+		_, err := tx.Exec("INSERT INTO plugin_updates (server_id, plugin_name, source, current_version, latest_version, channel) VALUES (?, ?, ?, ?, ?, ?)",
+			serverID, u.Name, u.Source, u.CurrentVersion, u.LatestVersion, u.Channel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return updates, tx.Commit()
+}
+
+// StartPluginUpdateChecker periodically checks every server with installed
+// plugins for updates
+func StartPluginUpdateChecker(db *sql.DB) {
+	go func() {
+		// Give the panel connection a moment after startup
+		time.Sleep(time.Minute)
+		for {
+			client, err := NewPteroClientAPI(db)
+			if err == nil {
+				rows, err := db.Query("SELECT DISTINCT server_id FROM installed_plugins")
+				if err == nil {
+					var servers []string
+					for rows.Next() {
+						var id string
+						rows.Scan(&id)
+						servers = append(servers, id)
+					}
+					rows.Close()
+
+					for _, id := range servers {
+						if updates, err := checkPluginUpdates(db, client, id); err != nil {
+							log.Printf("[WARN] Plugin update check for %s failed: %v", id, err)
+						} else if len(updates) > 0 {
+							log.Printf("[INFO] %d plugin update(s) available on %s", len(updates), id)
+						}
+					}
+				}
+			}
+
+			hours := GetSettingInt(db, "plugin_update_interval_hours", defaultPluginUpdateIntervalHours)
+			time.Sleep(time.Duration(hours) * time.Hour)
+		}
+	}()
+}
+
+// backupPluginJar moves a jar from the plugins folder into the backup folder
+// and returns its new path, or "" when the jar was not there
+func backupPluginJar(client *PteroClient, serverID, fileName string) (string, error) {
+	backup := path.Join(pluginBackupDir, fmt.Sprintf("%s.%s.bak", fileName, time.Now().Format("20060102-150405")))
+	client.CreateFolder(serverID, path.Dir(pluginBackupDir), path.Base(pluginBackupDir))
+	err := client.RenameFiles(serverID, []map[string]string{{
+		"from": relativeToRoot(path.Join(pluginsDir, fileName)),
+		"to":   relativeToRoot(backup),
+	}})
+	if err != nil && pteroStatus(err) == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to back up %s: %v", fileName, err)
+	}
+	return backup, nil
+}
+
+// restorePluginJar moves a jar saved by backupPluginJar back into place
+func restorePluginJar(client *PteroClient, serverID, backup, fileName string) error {
+	return client.RenameFiles(serverID, []map[string]string{{
+		"from": relativeToRoot(backup),
+		"to":   relativeToRoot(path.Join(pluginsDir, fileName)),
+	}})
+}
+
+// updatePlugin installs the newest compatible version of an installed
+// plugin. The old jar is moved to the backup folder first and put back if
+// the install fails. It returns the backup path.
+func updatePlugin(db *sql.DB, client *PteroClient, serverID string, p installedPluginRow, version string) (*InstalledPlugin, string, error) {
+	target, err := DetectServerPlatform(client, serverID, false)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not detect platform: %v", err)
+	}
+
+	var artifact *PluginArtifact
+	if version != "" {
+		artifact, err = resolvePluginDownload(p.Source, p.Name, version, target)
+	} else {
+		var candidates []PluginArtifact
+		candidates, err = pluginArtifacts(p.Source, p.Name, target)
+		if err == nil {
+			if artifact = newerArtifact(candidates, p.Version, target); artifact == nil {
+				return nil, "", fmt.Errorf("%s is already up to date", p.Name)
+			}
+			artifact.Source, artifact.Slug = p.Source, p.Name
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var backup string
+	if p.FileName != "" {
+		if backup, err = backupPluginJar(client, serverID, p.FileName); err != nil {
+			return nil, "", err
+		}
+	}
+
+	plugin, err := installPluginJar(db, client, serverID, artifact)
+	if err != nil {
+		if backup != "" {
+			restorePluginJar(client, serverID, backup, p.FileName)
+		}
+		return nil, "", err
+	}
+
+	db.Exec("DELETE FROM plugin_updates WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, p.Name, p.Source)
+	return plugin, backup, nil
+}
+
+// ListPluginUpdatesHandler lists available plugin updates for a server from
+// the last check. ?refresh=true checks the sources now.
+func ListPluginUpdatesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+
+		if c.Query("refresh") == "true" {
+			client, err := NewPteroClientAPI(db)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if _, err := checkPluginUpdates(db, client, serverID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		rows, err := db.Query("SELECT plugin_name, source, current_version, latest_version, channel, checked_at FROM plugin_updates WHERE server_id = ? ORDER BY plugin_name", serverID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		updates := []PluginUpdate{}
+		for rows.Next() {
+			var u PluginUpdate
+			rows.Scan(&u.Name, &u.Source, &u.CurrentVersion, &u.LatestVersion, &u.Channel, &u.CheckedAt)
+			updates = append(updates, u)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"updates": updates})
+	}
+}
+
+// UpdatePluginHandler updates one installed plugin, to the newest compatible
+// version or to the version given in the body
+func UpdatePluginHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		name := c.Param("plugin")
+		var req struct {
+			Source  string `json:"source"`
+			Version string `json:"version"`
+		}
+		c.ShouldBindJSON(&req)
+
+		plugins, err := installedPluginRows(db, serverID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var found *installedPluginRow
+		for i := range plugins {
+			if plugins[i].Name == name && (req.Source == "" || plugins[i].Source == req.Source) {
+				found = &plugins[i]
+				break
+			}
+		}
+		if found == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plugin is not installed"})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		plugin, backup, err := updatePlugin(db, client, serverID, *found, req.Version)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Plugin update failed: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":          "Plugin updated",
+			"plugin":           plugin,
+			"previous_version": found.Version,
+			"backup":           backup,
+		})
+	}
+}
+
+// UpdateAllPluginsHandler updates every plugin with a known update as a
+// background job
+func UpdateAllPluginsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		job := StartJob("plugin-update", serverID, func(job *Job) (map[string]interface{}, error) {
+			job.SetProgress(0, 0, "Checking for updates")
+			updates, err := checkPluginUpdates(db, client, serverID)
+			if err != nil {
+				return nil, err
+			}
+			plugins, err := installedPluginRows(db, serverID)
+			if err != nil {
+				return nil, err
+			}
+
+			type result struct {
+				Name    string `json:"name"`
+				From    string `json:"from"`
+				To      string `json:"to,omitempty"`
+				Backup  string `json:"backup,omitempty"`
+				Success bool   `json:"success"`
+				Error   string `json:"error,omitempty"`
+			}
+			results := []result{}
+			for i, u := range updates {
+				job.SetProgress(int64(i), int64(len(updates)), "Updating "+u.Name)
+				r := result{Name: u.Name, From: u.CurrentVersion}
+				for _, p := range plugins {
+					if p.Name != u.Name || p.Source != u.Source {
+						continue
+					}
+					plugin, backup, err := updatePlugin(db, client, serverID, p, "")
+					if err != nil {
+						r.Error = err.Error()
+					} else {
+						r.To, r.Backup, r.Success = plugin.Version, backup, true
+					}
+				}
+				results = append(results, r)
+			}
+
+			job.SetProgress(int64(len(updates)), int64(len(updates)), "Updates finished")
+			return map[string]interface{}{"results": results}, nil
+		})
+
+		respondWithJob(c, job)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Source recorded for plugin jars uploaded from the user's machine
+const SourceUpload = "upload"
+
+// Oldest api-version Bukkit accepts; plugins without one load in legacy mode
+var minPluginAPIVersion = [3]int{1, 13, 0}
+
+// checkPluginAPIVersion tells whether a plugin's api-version suits a server
+// running Minecraft serverVersion. A mismatch the user may override is
+// returned as a warning; one no server accepts is an error.
+func checkPluginAPIVersion(descriptor, apiVersion, serverVersion string) (warning string, err error) {
+	if apiVersion == "" {
+		if descriptor == "paper-plugin.yml" {
+			return "", fmt.Errorf("paper-plugin.yml has no api-version")
+		}
+		return "No api-version is set; the server will load it as a legacy plugin", nil
+	}
+	api, ok := parseGameVersion(apiVersion)
+	if !ok {
+		return "", fmt.Errorf("api-version %q is not a Minecraft version", apiVersion)
+	}
+	if compareGameVersions(api, minPluginAPIVersion) < 0 {
+		return "", fmt.Errorf("api-version %s is older than 1.13, which servers refuse", apiVersion)
+	}
+	if server, ok := parseGameVersion(serverVersion); ok && compareGameVersions(api, server) > 0 {
+		return fmt.Sprintf("Plugin targets Minecraft %s but the server runs %s and will refuse to load it", apiVersion, serverVersion), nil
+	}
+	return "", nil
+}
+
+func compareGameVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// UploadPluginHandler installs a plugin jar uploaded from the user's machine
+// (multipart field "file"). The jar must carry a plugin.yml or
+// paper-plugin.yml with a name, version and main class, and an api-version
+// the server can load; an api-version newer than the server is refused
+// unless force is set. The jar is stored like any installed plugin and
+// recorded with source "upload", replacing an earlier copy of the same
+// plugin.
+func UploadPluginHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		force := c.PostForm("force") == "true"
+		limit := int64(GetSettingInt(db, "upload_max_file_mb", defaultUploadMaxFileMB)) << 20
+
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No jar file uploaded"})
+			return
+		}
+		if !strings.HasSuffix(strings.ToLower(header.Filename), ".jar") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only .jar files can be uploaded as plugins"})
+			return
+		}
+		if header.Size > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Jar is larger than %d MB", limit>>20)})
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		jar := &ScannedJar{FileName: header.Filename, Size: int64(len(content)), Authors: []string{}}
+		if err := readJarDescriptor(jar, content); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Not a plugin: " + err.Error()})
+			return
+		}
+		if jar.Descriptor != "plugin.yml" && jar.Descriptor != "paper-plugin.yml" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Not a Bukkit or Paper plugin (found %s)", jar.Descriptor)})
+			return
+		}
+		if jar.Name == "" || jar.Version == "" || jar.main == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": jar.Descriptor + " must set name, version and main"})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		target, err := DetectServerPlatform(client, serverID, false)
+		if err != nil {
+			log.Printf("[WARN] Could not detect platform of %s: %v", serverID, err)
+			target = &ServerPlatform{}
+		}
+		if target.IsModded() || target.IsProxy() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Server runs %q, which does not load Bukkit plugins", target.Platform)})
+			return
+		}
+
+		warning, err := checkPluginAPIVersion(jar.Descriptor, jar.APIVersion, target.Version)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "jar": jar})
+			return
+		}
+		// Legacy plugins only get the warning; a too new api-version needs
+		// confirming
+		if warning != "" && jar.APIVersion != "" && !force {
+			c.JSON(http.StatusConflict, gin.H{"error": warning, "jar": jar})
+			return
+		}
+
+		plugin, err := installUploadedPlugin(db, client, serverID, jar, content)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Plugin upload failed: " + err.Error()})
+			return
+		}
+
+		response := gin.H{"message": "Plugin installed", "plugin": plugin, "jar": jar, "platform": target}
+		if warning != "" {
+			response["warning"] = warning
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// installUploadedPlugin uploads a checked jar into the plugins folder and
+// records it. Any tracked jar of the same plugin, from whatever source, is
+// removed and forgotten.
+func installUploadedPlugin(db *sql.DB, client *PteroClient, serverID string, jar *ScannedJar, content []byte) (*InstalledPlugin, error) {
+	sum := sha256.Sum256(content)
+	plugin := &InstalledPlugin{
+		Name:     jar.Name,
+		Version:  jar.Version,
+		Source:   SourceUpload,
+		FileName: pluginFileName(jar.Name, jar.Version),
+		FileHash: hex.EncodeToString(sum[:]),
+		Size:     int64(len(content)),
+	}
+
+	if err := client.UploadFile(serverID, pluginsDir, plugin.FileName, bytes.NewReader(content)); err != nil {
+		client.DeleteFiles(serverID, pluginsDir, []string{plugin.FileName})
+		return nil, fmt.Errorf("upload failed: %v", err)
+	}
+	stat, err := client.StatFile(serverID, path.Join(pluginsDir, plugin.FileName))
+	if err != nil {
+		client.DeleteFiles(serverID, pluginsDir, []string{plugin.FileName})
+		return nil, fmt.Errorf("could not verify upload: %v", err)
+	}
+	if stat.Size != plugin.Size {
+		client.DeleteFiles(serverID, pluginsDir, []string{plugin.FileName})
+		return nil, fmt.Errorf("uploaded %s is %d bytes, expected %d", plugin.FileName, stat.Size, plugin.Size)
+	}
+
+	rows, err := installedPluginRows(db, serverID)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// old jars are only removed once the records pointing at them are gone
+	var oldFiles []string
+	for _, row := range rows {
+		if !strings.EqualFold(row.Name, jar.Name) && normalizePluginName(row.Name) != normalizePluginName(jar.Name) {
+			continue
+		}
+		if row.FileName != "" && row.FileName != plugin.FileName {
+			oldFiles = append(oldFiles, row.FileName)
+		}
+		if _, err := tx.Exec("DELETE FROM installed_plugins WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, row.Name, row.Source); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM plugin_updates WHERE server_id = ? AND plugin_name = ? AND source = ?", serverID, row.Name, row.Source); err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.Exec("INSERT INTO installed_plugins (server_id, plugin_name, plugin_version, source, file_name, file_hash) VALUES (?, ?, ?, ?, ?, ?)",
+		serverID, plugin.Name, plugin.Version, plugin.Source, plugin.FileName, plugin.FileHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, name := range oldFiles {
+		if err := client.DeleteFiles(serverID, pluginsDir, []string{name}); err != nil && pteroStatus(err) != http.StatusNotFound {
+			log.Printf("[WARN] Failed to remove old jar %s from %s: %v", name, serverID, err)
+		}
+	}
+	return plugin, nil
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -64,6 +65,32 @@ func NewPteroClient(db *sql.DB) (*PteroClient, error) {
 	}, nil
 }
 
+// NewPteroClientAPI returns a client for the /api/client endpoints (files, power,
+// websocket). Those require the client API key; the application key is used
+// as a fallback for older setups that only saved one key.
+func NewPteroClientAPI(db *sql.DB) (*PteroClient, error) {
+	url, err := GetSetting(db, "ptero_url")
+	if err != nil || url == "" {
+		return nil, fmt.Errorf("pterodactyl URL not configured")
+	}
+
+	apiKey, _ := GetSetting(db, "ptero_client_key")
+	if apiKey == "" {
+		apiKey, _ = GetSetting(db, "ptero_key")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("pterodactyl client API key not configured")
+	}
+
+	debug, _ := GetSetting(db, "debug_mode")
+
+	return &PteroClient{
+		BaseURL: strings.TrimSuffix(url, "/"),
+		APIKey:  apiKey,
+		Debug:   debug == "true",
+	}, nil
+}
+
 func (p *PteroClient) Request(method, endpoint string, body interface{}) ([]byte, error) {
 	var reqBody io.Reader
 	var bodyBytes []byte
@@ -109,16 +136,84 @@ func (p *PteroClient) Request(method, endpoint string, body interface{}) ([]byte
 
 	// Check for error responses
 	if resp.StatusCode >= 400 {
-		var pteroErr PteroError
-		if json.Unmarshal(respBody, &pteroErr) == nil && len(pteroErr.Errors) > 0 {
-			return nil, fmt.Errorf("pterodactyl error: %s", pteroErr.Errors[0].Detail)
-		}
-		return nil, fmt.Errorf("pterodactyl API error (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, pteroStatusError(resp.StatusCode, respBody)
 	}
 
 	return respBody, nil
 }
 
+// Stream performs a request with a raw body and hands back the open response
+// so large payloads never have to be held in memory. The caller closes the body.
+func (p *PteroClient) Stream(method, endpoint string, body io.Reader, contentType string) (*http.Response, error) {
+	fullURL := p.BaseURL + endpoint
+
+	if p.Debug {
+		log.Printf("[DEBUG] %s %s (stream)", method, fullURL)
+	}
+
+	req, err := http.NewRequest(method, fullURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+
+	if p.Debug {
+		log.Printf("[DEBUG] Response Status: %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, pteroStatusError(resp.StatusCode, respBody)
+	}
+
+	return resp, nil
+}
+
+// PteroStatusError is returned for error responses from the panel so callers
+// can react to specific status codes
+type PteroStatusError struct {
+	Status int
+	Detail string
+	Body   string
+}
+
+func (e *PteroStatusError) Error() string {
+	if e.Detail != "" {
+		return "pterodactyl error: " + e.Detail
+	}
+	return fmt.Sprintf("pterodactyl API error (status %d): %s", e.Status, e.Body)
+}
+
+// pteroStatusError turns an error response from the panel into a readable error
+func pteroStatusError(status int, respBody []byte) error {
+	statusErr := &PteroStatusError{Status: status, Body: string(respBody)}
+	var pteroErr PteroError
+	if json.Unmarshal(respBody, &pteroErr) == nil && len(pteroErr.Errors) > 0 {
+		statusErr.Detail = pteroErr.Errors[0].Detail
+	}
+	return statusErr
+}
+
+// pteroStatus returns the HTTP status behind err, or 0 if it wasn't an error response
+func pteroStatus(err error) int {
+	var statusErr *PteroStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status
+	}
+	return 0
+}
+
 // ReadPterodactylEnv reads and parses the Pterodactyl .env file
 func ReadPterodactylEnv() (*PteroEnvConfig, error) {
 	envPath := "/var/www/pterodactyl/.env"
@@ -377,61 +472,7 @@ func CreateServerHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Get egg info for startup command and docker image
-		eggData, err := client.Request("GET", fmt.Sprintf("/api/application/nests/1/eggs/%d?include=variables", req.EggID), nil)
-		if err != nil {
-			// Use defaults if egg fetch fails
-			log.Printf("[DEBUG] Failed to fetch egg: %v, using defaults", err)
-		}
-		
-		dockerImage := "ghcr.io/pterodactyl/yolks:java_21"
-		startup := "java -Xms128M -Xmx{{SERVER_MEMORY}}M -jar server.jar"
-		
-		if eggData != nil {
-			var eggResult struct {
-				Attributes struct {
-					DockerImage string `json:"docker_image"`
-					Startup     string `json:"startup"`
-				} `json:"attributes"`
-			}
-			if json.Unmarshal(eggData, &eggResult) == nil {
-				if eggResult.Attributes.DockerImage != "" {
-					dockerImage = eggResult.Attributes.DockerImage
-				}
-				if eggResult.Attributes.Startup != "" {
-					startup = eggResult.Attributes.Startup
-				}
-			}
-		}
-
-		serverData := map[string]interface{}{
-			"name":         req.Name,
-			"user":         1,
-			"egg":          req.EggID,
-			"docker_image": dockerImage,
-			"startup":      startup,
-			"environment": map[string]string{
-				"SERVER_JARFILE": "server.jar",
-				"BUILD_NUMBER":   "latest",
-			},
-			"limits": map[string]int{
-				"memory": req.Memory,
-				"swap":   0,
-				"disk":   req.Disk,
-				"io":     500,
-				"cpu":    req.CPU,
-			},
-			"feature_limits": map[string]int{
-				"databases":   req.Databases,
-				"allocations": req.Allocations,
-				"backups":     3,
-			},
-			"allocation": map[string]int{
-				"default": allocationID,
-			},
-		}
-
-		data, err := client.Request("POST", "/api/application/servers", serverData)
+		data, err := createServer(client, req, allocationID, nil)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "debug": "Failed to create server"})
 			return
@@ -443,6 +484,84 @@ func CreateServerHandler(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
+// createServer creates a server from an egg on the given allocation. The
+// egg's variable defaults are used for the environment, overridden by
+// environment.
+func createServer(client *PteroClient, req CreateServerRequest, allocationID int, environment map[string]string) ([]byte, error) {
+	// Get egg info for startup command and docker image
+	eggData, err := client.Request("GET", fmt.Sprintf("/api/application/nests/1/eggs/%d?include=variables", req.EggID), nil)
+	if err != nil {
+		// Use defaults if egg fetch fails
+		log.Printf("[DEBUG] Failed to fetch egg: %v, using defaults", err)
+	}
+
+	dockerImage := "ghcr.io/pterodactyl/yolks:java_21"
+	startup := "java -Xms128M -Xmx{{SERVER_MEMORY}}M -jar server.jar"
+	env := map[string]string{}
+
+	if eggData != nil {
+		var eggResult struct {
+			Attributes struct {
+				DockerImage   string `json:"docker_image"`
+				Startup       string `json:"startup"`
+				Relationships struct {
+					Variables struct {
+						Data []struct {
+							Attributes struct {
+								EnvVariable  string `json:"env_variable"`
+								DefaultValue string `json:"default_value"`
+							} `json:"attributes"`
+						} `json:"data"`
+					} `json:"variables"`
+				} `json:"relationships"`
+			} `json:"attributes"`
+		}
+		if json.Unmarshal(eggData, &eggResult) == nil {
+			if eggResult.Attributes.DockerImage != "" {
+				dockerImage = eggResult.Attributes.DockerImage
+			}
+			if eggResult.Attributes.Startup != "" {
+				startup = eggResult.Attributes.Startup
+			}
+			for _, v := range eggResult.Attributes.Relationships.Variables.Data {
+				env[v.Attributes.EnvVariable] = v.Attributes.DefaultValue
+			}
+		}
+	}
+
+	env["SERVER_JARFILE"] = "server.jar"
+	env["BUILD_NUMBER"] = "latest"
+	for k, v := range environment {
+		env[k] = v
+	}
+
+	serverData := map[string]interface{}{
+		"name":         req.Name,
+		"user":         1,
+		"egg":          req.EggID,
+		"docker_image": dockerImage,
+		"startup":      startup,
+		"environment":  env,
+		"limits": map[string]int{
+			"memory": req.Memory,
+			"swap":   0,
+			"disk":   req.Disk,
+			"io":     500,
+			"cpu":    req.CPU,
+		},
+		"feature_limits": map[string]int{
+			"databases":   req.Databases,
+			"allocations": req.Allocations,
+			"backups":     3,
+		},
+		"allocation": map[string]int{
+			"default": allocationID,
+		},
+	}
+
+	return client.Request("POST", "/api/application/servers", serverData)
+}
+
 // getOrCreateAllocation finds an available allocation or creates one
 func getOrCreateAllocation(client *PteroClient, nodeID int) (int, error) {
 	// First, try to find an existing unassigned allocation
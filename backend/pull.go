@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hosts files may be pulled from unless the pull_allowed_hosts setting says otherwise.
+// A leading "." allows every subdomain.
+var defaultPullAllowedHosts = []string{
+	"cdn.modrinth.com",
+	"github.com",
+	"objects.githubusercontent.com",
+	"hangarcdn.papermc.io",
+	"api.papermc.io",
+	"edge.forgecdn.net",
+	"mediafilez.forgecdn.net",
+	"api.spiget.org",
+}
+
+const (
+	pullPollEvery   = 3 * time.Second
+	pullPollTimeout = time.Hour
+	// Polls without any file before Wings is assumed to have given up
+	pullMissingPolls = 20
+)
+
+// pullAllowedHosts returns the configured allowlist
+func pullAllowedHosts(db *sql.DB) []string {
+	value, _ := GetSetting(db, "pull_allowed_hosts")
+	if strings.TrimSpace(value) == "" {
+		return defaultPullAllowedHosts
+	}
+	var hosts []string
+	for _, h := range strings.Split(value, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// hostAllowed checks a URL host against the allowlist. Entries starting with
+// "." match the domain itself and any subdomain; "*" allows everything.
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, a := range allowed {
+		switch {
+		case a == "*":
+			return true
+		case strings.HasPrefix(a, "."):
+			if host == a[1:] || strings.HasSuffix(host, a) {
+				return true
+			}
+		case host == a:
+			return true
+		}
+	}
+	return false
+}
+
+// checksumVerifier hashes data as it streams past and compares it with an expected digest
+type checksumVerifier struct {
+	algorithm string
+	expected  string
+	hash      hash.Hash
+}
+
+// newChecksumVerifier parses "algo:hex" or a bare hex digest, guessing the
+// algorithm from its length. An empty spec returns nil.
+func newChecksumVerifier(spec string) (*checksumVerifier, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	algorithm, digest := "", spec
+	if i := strings.Index(spec, ":"); i >= 0 {
+		algorithm, digest = strings.ToLower(spec[:i]), spec[i+1:]
+	}
+	digest = strings.ToLower(digest)
+	if _, err := hex.DecodeString(digest); err != nil {
+		return nil, fmt.Errorf("checksum must be hex encoded")
+	}
+	if algorithm == "" {
+		switch len(digest) {
+		case 32:
+			algorithm = "md5"
+		case 40:
+			algorithm = "sha1"
+		case 64:
+			algorithm = "sha256"
+		case 128:
+			algorithm = "sha512"
+		}
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported checksum %q, use md5, sha1, sha256 or sha512", spec)
+	}
+	if len(digest) != h.Size()*2 {
+		return nil, fmt.Errorf("checksum has the wrong length for %s", algorithm)
+	}
+
+	return &checksumVerifier{algorithm: algorithm, expected: digest, hash: h}, nil
+}
+
+func (v *checksumVerifier) Write(b []byte) (int, error) {
+	return v.hash.Write(b)
+}
+
+// Verify compares everything written so far with the expected digest
+func (v *checksumVerifier) Verify() error {
+	actual := hex.EncodeToString(v.hash.Sum(nil))
+	if actual != v.expected {
+		return fmt.Errorf("%s checksum mismatch: expected %s, got %s", v.algorithm, v.expected, actual)
+	}
+	return nil
+}
+
+// progressReader reports bytes read to a job
+type progressReader struct {
+	r     io.Reader
+	job   *Job
+	total int64
+	n     int64
+	last  time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if time.Since(p.last) > 500*time.Millisecond || err == io.EOF {
+		p.job.SetProgress(p.n, p.total, "")
+		p.last = time.Now()
+	}
+	return n, err
+}
+
+// allowlistedHTTPClient refuses redirects to hosts outside the allowlist
+func allowlistedHTTPClient(allowed []string) *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("too many redirects")
+			}
+			if !hostAllowed(req.URL.Host, allowed) {
+				return fmt.Errorf("redirect to %s is not in the allowed hosts", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// PullFile asks Wings to download a URL straight into directory. Wings runs
+// the download in the background.
+func (p *PteroClient) PullFile(serverID, fileURL, directory, filename string) error {
+	_, err := p.Request("POST", "/api/client/servers/"+serverID+"/files/pull", map[string]interface{}{
+		"url":        fileURL,
+		"directory":  directory,
+		"filename":   filename,
+		"use_header": false,
+		"foreground": false,
+	})
+	return err
+}
+
+// pullUnsupported reports whether the panel lacks the files/pull endpoint
+// (or has it disabled) so the download has to go through PanelManager
+func pullUnsupported(err error) bool {
+	switch pteroStatus(err) {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusForbidden, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// probeRemoteFile asks the remote host for the file name and size without downloading it
+func probeRemoteFile(httpClient *http.Client, fileURL string) (name string, size int64) {
+	size = -1
+	resp, err := httpClient.Head(fileURL)
+	if err != nil {
+		return "", size
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", size
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = path.Base(resp.Request.URL.Path)
+	}
+	return name, resp.ContentLength
+}
+
+// verifyServerFile re-reads a file from Wings and checks its digest
+func verifyServerFile(client *PteroClient, serverID, file string, verifier *checksumVerifier) error {
+	resp, err := client.OpenFile(serverID, file, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(verifier, resp.Body); err != nil {
+		return fmt.Errorf("failed to read back %s: %v", file, err)
+	}
+	return verifier.Verify()
+}
+
+// waitForPulledFile polls until the file Wings is downloading reaches the
+// expected size, or stops growing when the size is unknown. file must be a
+// fresh name so an older file is never mistaken for the download.
+func waitForPulledFile(job *Job, client *PteroClient, serverID, file string, expected int64) (*FileObject, error) {
+	deadline := time.Now().Add(pullPollTimeout)
+	var lastSize int64 = -1
+	missing := 0
+	for time.Now().Before(deadline) {
+		time.Sleep(pullPollEvery)
+
+		stat, err := client.StatFile(serverID, file)
+		if err != nil {
+			missing++
+			if missing >= pullMissingPolls {
+				return nil, fmt.Errorf("Wings did not start the download")
+			}
+			job.SetProgress(0, expected, "Waiting for Wings to start the download")
+			continue
+		}
+		missing = 0
+		job.SetProgress(stat.Size, expected, "Wings is downloading")
+
+		if expected >= 0 && stat.Size >= expected {
+			return stat, nil
+		}
+		if expected < 0 && stat.Size > 0 && stat.Size == lastSize {
+			return stat, nil
+		}
+		lastSize = stat.Size
+	}
+	return nil, fmt.Errorf("timed out waiting for Wings to finish the download")
+}
+
+// PullFileHandler fetches a remote file into a server directory. Wings pulls
+// it directly when the panel supports that; otherwise PanelManager downloads
+// it and streams it to the upload URL. The work runs as a background job.
+func PullFileHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			URL       string `json:"url" binding:"required"`
+			Directory string `json:"directory"`
+			Filename  string `json:"filename"`
+			Checksum  string `json:"checksum"`
+			Method    string `json:"method"` // auto, wings or panel
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		target, err := url.Parse(req.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http(s) URL"})
+			return
+		}
+		allowed := pullAllowedHosts(db)
+		if !hostAllowed(target.Host, allowed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Host " + target.Hostname() + " is not in the allowed hosts", "allowed_hosts": allowed})
+			return
+		}
+
+		directory, err := normalizeServerPath(req.Directory)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Filename != "" && (strings.ContainsAny(req.Filename, "/\\") || req.Filename == "." || req.Filename == "..") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "filename must not contain a path"})
+			return
+		}
+		if _, err := newChecksumVerifier(req.Checksum); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		method := req.Method
+		if method == "" {
+			method = "auto"
+		}
+		if method != "auto" && method != "wings" && method != "panel" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "method must be auto, wings or panel"})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		maxSize := GetSettingInt(db, "upload_max_file_mb", defaultUploadMaxFileMB) << 20
+		author := CurrentUsername(c, db)
+
+		job := StartJob("pull", id, func(job *Job) (map[string]interface{}, error) {
+			httpClient := allowlistedHTTPClient(allowed)
+			remoteName, size := probeRemoteFile(httpClient, target.String())
+			name := req.Filename
+			if name == "" {
+				name = path.Base(strings.ReplaceAll(remoteName, "\\", "/"))
+			}
+			if name == "" || name == "." || name == "/" {
+				return nil, fmt.Errorf("could not work out a file name, please set filename")
+			}
+			file := path.Join(directory, name)
+			if size > maxSize {
+				return nil, fmt.Errorf("remote file is %d MB, the limit is %d MB", size>>20, maxSize>>20)
+			}
+
+			result := map[string]interface{}{"file": file, "url": target.String()}
+			verifier, _ := newChecksumVerifier(req.Checksum)
+
+			// Both methods download to a partial file that only replaces
+			// the target once it is complete and verified
+			partial := partialFileName(name)
+			finish := func() error {
+				err := keepCurrentRevision(db, client, id, file, author)
+				if err == nil {
+					err = replaceFile(client, id, directory, partial, name)
+				}
+				if err != nil {
+					client.DeleteFiles(id, directory, []string{partial})
+					return err
+				}
+				return nil
+			}
+
+			if method != "panel" {
+				job.SetProgress(0, size, "Asking Wings to download "+name)
+				err := client.PullFile(id, target.String(), directory, partial)
+				switch {
+				case err == nil:
+					stat, err := waitForPulledFile(job, client, id, path.Join(directory, partial), size)
+					if err != nil {
+						client.DeleteFiles(id, directory, []string{partial})
+						return nil, err
+					}
+					result["method"] = "wings"
+					result["size"] = stat.Size
+					if verifier != nil {
+						job.SetProgress(stat.Size, stat.Size, "Verifying checksum")
+						if err := verifyServerFile(client, id, path.Join(directory, partial), verifier); err != nil {
+							client.DeleteFiles(id, directory, []string{partial})
+							return nil, err
+						}
+						result["checksum_verified"] = true
+					}
+					if err := finish(); err != nil {
+						return nil, err
+					}
+					return result, nil
+				case method == "wings" || !pullUnsupported(err):
+					return nil, err
+				}
+			}
+
+			job.SetProgress(0, size, "Downloading "+name)
+			resp, err := httpClient.Get(target.String())
+			if err != nil {
+				return nil, fmt.Errorf("download failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				return nil, fmt.Errorf("download failed with status %d", resp.StatusCode)
+			}
+			if resp.ContentLength > 0 {
+				size = resp.ContentLength
+			}
+
+			counter := &sizeLimitedReader{r: resp.Body, limit: maxSize}
+			var body io.Reader = &progressReader{r: counter, job: job, total: size}
+			if verifier != nil {
+				body = io.TeeReader(body, verifier)
+			}
+			err = client.UploadFile(id, directory, partial, body)
+			if counter.n > maxSize {
+				err = errFileTooLarge
+			}
+			if err != nil {
+				client.DeleteFiles(id, directory, []string{partial})
+				return nil, err
+			}
+
+			result["method"] = "panel"
+			result["size"] = counter.n
+			if verifier != nil {
+				if err := verifier.Verify(); err != nil {
+					client.DeleteFiles(id, directory, []string{partial})
+					return nil, err
+				}
+				result["checksum_verified"] = true
+			}
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			job.SetProgress(counter.n, counter.n, "Upload finished")
+			return result, nil
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{"job": job.Snapshot()})
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Release channels, best first
+const (
+	ChannelRelease = "release"
+	ChannelBeta    = "beta"
+	ChannelAlpha   = "alpha"
+)
+
+// PluginArtifact is one downloadable file of a plugin version, picked for a
+// particular server
+type PluginArtifact struct {
+	Source       string             `json:"source"`
+	Slug         string             `json:"slug"`
+	Version      string             `json:"version"`
+	Channel      string             `json:"channel"`
+	URL          string             `json:"url"`
+	FileName     string             `json:"file_name"`
+	Hashes       map[string]string  `json:"hashes,omitempty"`
+	GameVersions []string           `json:"game_versions,omitempty"`
+	Loaders      []string           `json:"loaders,omitempty"`
+	Dependencies []PluginDependency `json:"dependencies,omitempty"`
+	ClientOnly   bool               `json:"client_only,omitempty"`
+	Warning      string             `json:"warning,omitempty"`
+}
+
+// Kinds of plugin dependency
+const (
+	DependencyRequired     = "required"
+	DependencyOptional     = "optional"
+	DependencyIncompatible = "incompatible"
+	DependencyEmbedded     = "embedded"
+)
+
+// PluginDependency is a dependency a plugin version declares. Slug is the
+// project on Source, or empty when the dependency is only known by name or
+// is hosted elsewhere (ExternalURL).
+type PluginDependency struct {
+	Source      string `json:"source"`
+	Slug        string `json:"slug,omitempty"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	ExternalURL string `json:"external_url,omitempty"`
+}
+
+func channelRank(channel string) int {
+	switch channel {
+	case ChannelRelease:
+		return 0
+	case ChannelBeta:
+		return 1
+	}
+	return 2
+}
+
+// normalizeChannel maps the channel names sources use onto release/beta/alpha
+func normalizeChannel(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case name == "" || strings.Contains(name, "release") || strings.Contains(name, "stable"):
+		return ChannelRelease
+	case strings.Contains(name, "beta") || strings.Contains(name, "snapshot"):
+		return ChannelBeta
+	}
+	return ChannelAlpha
+}
+
+// parseGameVersion splits "1.21.4" into its numbers; missing parts are 0
+func parseGameVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	fields := strings.Split(v, ".")
+	if len(fields) < 2 || len(fields) > 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// gameVersionDistance scores how far apart two game versions are. An entry
+// ending in ".x" such as "1.21.x" covers every patch release of it; a plain
+// "1.21" is 1.21.0 only. ok is false for unparseable or different major
+// versions.
+func gameVersionDistance(supported, wanted string) (int, bool) {
+	anyPatch := strings.HasSuffix(supported, ".x")
+	s, ok1 := parseGameVersion(strings.TrimSuffix(supported, ".x"))
+	w, ok2 := parseGameVersion(wanted)
+	if !ok1 || !ok2 || s[0] != w[0] {
+		return 0, false
+	}
+	minor := s[1] - w[1]
+	if minor < 0 {
+		minor = -minor
+	}
+	if minor == 0 && anyPatch {
+		return 0, true
+	}
+	patch := s[2] - w[2]
+	if patch < 0 {
+		patch = -patch
+	}
+	return minor*100 + patch, true
+}
+
+// closestGameVersion returns the smallest distance between any supported
+// version and wanted
+func closestGameVersion(supported []string, wanted string) (int, bool) {
+	best, found := 0, false
+	for _, v := range supported {
+		if d, ok := gameVersionDistance(v, wanted); ok && (!found || d < best) {
+			best, found = d, true
+		}
+	}
+	return best, found
+}
+
+func loadersOverlap(have, want []string) bool {
+	if len(want) == 0 || len(have) == 0 {
+		return true
+	}
+	for _, w := range want {
+		for _, h := range have {
+			if strings.EqualFold(h, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectArtifact picks the version to install out of candidates, which are
+// ordered newest first. A requested version is taken as-is, with a warning if
+// it does not fit the server. Otherwise the newest version supporting the
+// server's loader and game version wins, releases before betas before alphas.
+// When nothing supports the game version, the closest one is chosen and a
+// warning says so (Requirement 17.5).
+func selectArtifact(candidates []PluginArtifact, requested string, target *ServerPlatform) (*PluginArtifact, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no downloadable versions found")
+	}
+	loaders := target.Loaders()
+
+	if requested != "" {
+		for i := range candidates {
+			a := &candidates[i]
+			if a.Version != requested {
+				continue
+			}
+			if !loadersOverlap(a.Loaders, loaders) {
+				a.Warning = fmt.Sprintf("Version %s does not list %s support", a.Version, target.Platform)
+			} else if d, ok := closestGameVersion(a.GameVersions, target.Version); target.Version != "" && (!ok || d != 0) && len(a.GameVersions) > 0 {
+				a.Warning = fmt.Sprintf("Version %s does not list Minecraft %s support", a.Version, target.Version)
+			}
+			return a, nil
+		}
+		return nil, fmt.Errorf("version %s not found", requested)
+	}
+
+	var compatible []*PluginArtifact
+	for i := range candidates {
+		if loadersOverlap(candidates[i].Loaders, loaders) {
+			compatible = append(compatible, &candidates[i])
+		}
+	}
+	if len(compatible) == 0 {
+		return nil, fmt.Errorf("no versions for %s", target.Platform)
+	}
+
+	best := func(matches func(a *PluginArtifact) bool) *PluginArtifact {
+		var pick *PluginArtifact
+		for _, a := range compatible {
+			if matches(a) && (pick == nil || channelRank(a.Channel) < channelRank(pick.Channel)) {
+				pick = a
+			}
+		}
+		return pick
+	}
+
+	if target.Version == "" {
+		return best(func(*PluginArtifact) bool { return true }), nil
+	}
+
+	if pick := best(func(a *PluginArtifact) bool {
+		d, ok := closestGameVersion(a.GameVersions, target.Version)
+		return ok && d == 0
+	}); pick != nil {
+		return pick, nil
+	}
+
+	closest := -1
+	for _, a := range compatible {
+		if d, ok := closestGameVersion(a.GameVersions, target.Version); ok && (closest < 0 || d < closest) {
+			closest = d
+		}
+	}
+	if closest < 0 {
+		return nil, fmt.Errorf("no versions list Minecraft %s support", target.Version)
+	}
+	pick := best(func(a *PluginArtifact) bool {
+		d, ok := closestGameVersion(a.GameVersions, target.Version)
+		return ok && d == closest
+	})
+	pick.Warning = fmt.Sprintf("No version supports Minecraft %s; using %s, which supports %s",
+		target.Version, pick.Version, strings.Join(pick.GameVersions, ", "))
+	return pick, nil
+}
+
+// pluginArtifacts lists the versions of a plugin a source offers for target,
+// newest first
+func pluginArtifacts(source, slug string, target *ServerPlatform) ([]PluginArtifact, error) {
+	s, err := GetPluginSource(source)
+	if err != nil {
+		return nil, err
+	}
+	return s.Versions(slug, target)
+}
+
+// resolvePluginDownload finds the file to install for a plugin. version may
+// be empty to pick the best version for target, which may be nil when the
+// server's platform is unknown. When the source cannot be reached, a cached
+// version that suits target is used if there is one.
+func resolvePluginDownload(source, slug, version string, target *ServerPlatform) (*PluginArtifact, error) {
+	if target == nil {
+		target = &ServerPlatform{}
+	}
+	s, err := GetPluginSource(source)
+	if err != nil {
+		return nil, err
+	}
+	artifact, err := s.Resolve(slug, version, target)
+	if err != nil {
+		// Fall back to a cached copy only when the source cannot be reached;
+		// an answer such as "no versions for velocity" stands
+		if sourceUnavailable(err) {
+			if cached := cachedFallback(source, slug, version, target, err); cached != nil {
+				return cached, nil
+			}
+		}
+		return nil, err
+	}
+	return artifact, nil
+}
+
+// modrinthHashVersion is the part of a Modrinth version a hash lookup needs
+type modrinthHashVersion struct {
+	ProjectID     string `json:"project_id"`
+	VersionNumber string `json:"version_number"`
+}
+
+// modrinthVersionsByHash looks files up on Modrinth by sha1, returning the
+// version each known hash belongs to
+func modrinthVersionsByHash(hashes []string) (map[string]modrinthHashVersion, error) {
+	var versions map[string]modrinthHashVersion
+	body := map[string]interface{}{"hashes": hashes, "algorithm": "sha1"}
+	if err := postJSON("https://api.modrinth.com/v2/version_files", body, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func modrinthArtifacts(slug string) ([]PluginArtifact, error) {
+	var versions []struct {
+		VersionNumber string   `json:"version_number"`
+		VersionType   string   `json:"version_type"`
+		GameVersions  []string `json:"game_versions"`
+		Loaders       []string `json:"loaders"`
+		Files         []struct {
+			URL      string            `json:"url"`
+			Filename string            `json:"filename"`
+			Primary  bool              `json:"primary"`
+			Hashes   map[string]string `json:"hashes"`
+		} `json:"files"`
+		Dependencies []struct {
+			ProjectID      string `json:"project_id"`
+			FileName       string `json:"file_name"`
+			DependencyType string `json:"dependency_type"`
+		} `json:"dependencies"`
+	}
+	if err := getJSON(fmt.Sprintf("https://api.modrinth.com/v2/project/%s/version", url.PathEscape(slug)), &versions); err != nil {
+		return nil, fmt.Errorf("modrinth project %s: %w", slug, err)
+	}
+
+	var candidates []PluginArtifact
+	for _, v := range versions {
+		if len(v.Files) == 0 {
+			continue
+		}
+		file := v.Files[0]
+		for _, f := range v.Files {
+			if f.Primary {
+				file = f
+				break
+			}
+		}
+		// Dependencies name the project by id; the slug is looked up only
+		// for the version that gets picked. Pinned dependency versions are
+		// ignored in favour of the best version for the server.
+		var deps []PluginDependency
+		for _, d := range v.Dependencies {
+			if d.ProjectID == "" {
+				continue
+			}
+			deps = append(deps, PluginDependency{Source: "modrinth", Slug: d.ProjectID, Name: d.ProjectID, Type: d.DependencyType})
+		}
+		candidates = append(candidates, PluginArtifact{
+			Version:      v.VersionNumber,
+			Channel:      normalizeChannel(v.VersionType),
+			URL:          file.URL,
+			FileName:     file.Filename,
+			Hashes:       file.Hashes,
+			GameVersions: v.GameVersions,
+			Loaders:      v.Loaders,
+			Dependencies: deps,
+		})
+	}
+	return candidates, nil
+}
+
+func hangarArtifacts(slug string, target *ServerPlatform) ([]PluginArtifact, error) {
+	platform := target.HangarPlatform()
+	if platform == "" {
+		return nil, fmt.Errorf("hangar has no builds for %s servers", target.Platform)
+	}
+	var data struct {
+		Result []struct {
+			Name    string `json:"name"`
+			Channel struct {
+				Name string `json:"name"`
+			} `json:"channel"`
+			Downloads map[string]struct {
+				FileInfo *struct {
+					Name       string `json:"name"`
+					SHA256Hash string `json:"sha256Hash"`
+				} `json:"fileInfo"`
+				ExternalURL string `json:"externalUrl"`
+				DownloadURL string `json:"downloadUrl"`
+			} `json:"downloads"`
+			PlatformDependencies map[string][]string `json:"platformDependencies"`
+			PluginDependencies   map[string][]struct {
+				Name        string `json:"name"`
+				Required    bool   `json:"required"`
+				ExternalURL string `json:"externalUrl"`
+			} `json:"pluginDependencies"`
+		} `json:"result"`
+	}
+	endpoint := fmt.Sprintf("https://hangar.papermc.io/api/v1/projects/%s/versions?limit=25&platform=%s", url.PathEscape(slug), platform)
+	if err := getJSON(endpoint, &data); err != nil {
+		return nil, fmt.Errorf("hangar project %s: %w", slug, err)
+	}
+
+	// Hangar has no Folia platform; projects that run on it carry a tag and
+	// ship their Paper build
+	folia := false
+	if target.Platform == PlatformFolia {
+		var project struct {
+			Settings struct {
+				Tags []string `json:"tags"`
+			} `json:"settings"`
+		}
+		if err := getJSON("https://hangar.papermc.io/api/v1/projects/"+url.PathEscape(slug), &project); err != nil {
+			return nil, fmt.Errorf("hangar project %s: %w", slug, err)
+		}
+		for _, tag := range project.Settings.Tags {
+			folia = folia || tag == "SUPPORTS_FOLIA"
+		}
+	}
+
+	var candidates []PluginArtifact
+	for _, v := range data.Result {
+		download, ok := v.Downloads[platform]
+		if !ok {
+			continue
+		}
+		artifact := PluginArtifact{
+			Version:      v.Name,
+			Channel:      normalizeChannel(v.Channel.Name),
+			URL:          download.DownloadURL,
+			GameVersions: v.PlatformDependencies[platform],
+		}
+		for p := range v.Downloads {
+			artifact.Loaders = append(artifact.Loaders, strings.ToLower(p))
+		}
+		if folia {
+			artifact.Loaders = append(artifact.Loaders, PlatformFolia)
+		}
+		if download.FileInfo != nil {
+			artifact.FileName = download.FileInfo.Name
+			artifact.Hashes = map[string]string{"sha256": download.FileInfo.SHA256Hash}
+		}
+		if artifact.URL == "" {
+			artifact.URL = download.ExternalURL
+		}
+		for _, d := range v.PluginDependencies[platform] {
+			dep := PluginDependency{Source: "hangar", Name: d.Name, Type: DependencyOptional, ExternalURL: d.ExternalURL}
+			if d.Required {
+				dep.Type = DependencyRequired
+			}
+			if d.ExternalURL == "" {
+				dep.Slug = d.Name
+			}
+			artifact.Dependencies = append(artifact.Dependencies, dep)
+		}
+		candidates = append(candidates, artifact)
+	}
+	return candidates, nil
+}
+
+// spigotArtifacts lists the single version Spiget can serve, the latest
+func spigotArtifacts(id string) ([]PluginArtifact, error) {
+	var resource struct {
+		Premium        bool     `json:"premium"`
+		TestedVersions []string `json:"testedVersions"`
+		File           struct {
+			Type string `json:"type"`
+		} `json:"file"`
+	}
+	if err := getJSON(fmt.Sprintf("https://api.spiget.org/v2/resources/%s", url.PathEscape(id)), &resource); err != nil {
+		return nil, fmt.Errorf("spigot resource %s: %w", id, err)
+	}
+	if resource.Premium {
+		return nil, fmt.Errorf("spigot resource %s is premium and cannot be downloaded", id)
+	}
+	if resource.File.Type == "external" {
+		return nil, fmt.Errorf("spigot resource %s is hosted externally", id)
+	}
+
+	var latest struct {
+		Name string `json:"name"`
+	}
+	if err := getJSON(fmt.Sprintf("https://api.spiget.org/v2/resources/%s/versions/latest", url.PathEscape(id)), &latest); err != nil {
+		return nil, fmt.Errorf("spigot resource %s: %w", id, err)
+	}
+
+	// Spigot lists tested versions as "1.21", meaning any 1.21.x release
+	gameVersions := make([]string, len(resource.TestedVersions))
+	for i, v := range resource.TestedVersions {
+		if strings.Count(v, ".") == 1 {
+			v += ".x"
+		}
+		gameVersions[i] = v
+	}
+
+	return []PluginArtifact{{
+		Version:      latest.Name,
+		Channel:      ChannelRelease,
+		URL:          fmt.Sprintf("https://api.spiget.org/v2/resources/%s/download", url.PathEscape(id)),
+		GameVersions: gameVersions,
+	}}, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+func TestGameVersionDistance(t *testing.T) {
+	tests := []struct {
+		supported, wanted string
+		distance          int
+		ok                bool
+	}{
+		{"1.21.4", "1.21.4", 0, true},
+		{"1.21", "1.21", 0, true},
+		{"1.21", "1.21.0", 0, true},
+		{"1.21", "1.21.4", 4, true},
+		{"1.21.x", "1.21.4", 0, true},
+		{"1.21.x", "1.21", 0, true},
+		{"1.21.x", "1.20.6", 106, true},
+		{"1.20.4", "1.21.4", 100, true},
+		{"1.21.1", "1.21.4", 3, true},
+		{"1.21.4", "1.21.1", 3, true},
+
+		{"2.0", "1.21", 0, false},
+		{"1.21-pre1", "1.21", 0, false},
+		{"1.21.4", "", 0, false},
+		{"1", "1.21", 0, false},
+		{"1.21.4.1", "1.21.4", 0, false},
+	}
+
+	for _, tt := range tests {
+		d, ok := gameVersionDistance(tt.supported, tt.wanted)
+		if ok != tt.ok || (ok && d != tt.distance) {
+			t.Errorf("gameVersionDistance(%q, %q) = %d, %v; want %d, %v", tt.supported, tt.wanted, d, ok, tt.distance, tt.ok)
+		}
+	}
+}
+
+func TestSelectArtifact(t *testing.T) {
+	candidates := []PluginArtifact{
+		{Version: "5.0-beta", Channel: ChannelBeta, GameVersions: []string{"1.21.4"}, Loaders: []string{"paper"}},
+		{Version: "4.2", Channel: ChannelRelease, GameVersions: []string{"1.21.4", "1.21.3"}, Loaders: []string{"paper", "spigot"}},
+		{Version: "4.1-fabric", Channel: ChannelRelease, GameVersions: []string{"1.21.4"}, Loaders: []string{"fabric"}},
+		{Version: "4.0", Channel: ChannelRelease, GameVersions: []string{"1.20.x"}, Loaders: []string{"spigot"}},
+		{Version: "3.0", Channel: ChannelRelease, GameVersions: []string{"1.19.4"}, Loaders: []string{"bukkit"}},
+	}
+
+	tests := []struct {
+		name      string
+		requested string
+		target    ServerPlatform
+		version   string
+		warning   bool
+		err       bool
+	}{
+		{"paper plugins on purpur", "", ServerPlatform{Platform: PlatformPurpur, Version: "1.21.3"}, "4.2", false, false},
+		{"release before a newer beta", "", ServerPlatform{Platform: PlatformPaper, Version: "1.21.4"}, "4.2", false, false},
+		{"loader filters versions", "", ServerPlatform{Platform: PlatformFabric, Version: "1.21.4"}, "4.1-fabric", false, false},
+		{"wildcard covers patch releases", "", ServerPlatform{Platform: PlatformSpigot, Version: "1.20.6"}, "4.0", false, false},
+		{"closest version with a warning", "", ServerPlatform{Platform: PlatformSpigot, Version: "1.19.2"}, "3.0", true, false},
+		{"unknown game version takes the best channel", "", ServerPlatform{Platform: PlatformPaper}, "4.2", false, false},
+		{"requested version fits", "4.0", ServerPlatform{Platform: PlatformSpigot, Version: "1.20.1"}, "4.0", false, false},
+		{"requested version for another game version", "3.0", ServerPlatform{Platform: PlatformPaper, Version: "1.21.4"}, "3.0", true, false},
+		{"requested version for another loader", "4.1-fabric", ServerPlatform{Platform: PlatformPaper, Version: "1.21.4"}, "4.1-fabric", true, false},
+		{"requested version missing", "9.9", ServerPlatform{Platform: PlatformPaper, Version: "1.21.4"}, "", false, true},
+		{"no version for the loader", "", ServerPlatform{Platform: PlatformForge, Version: "1.21.4"}, "", false, true},
+		{"different major version", "", ServerPlatform{Platform: PlatformPaper, Version: "2.0"}, "", false, true},
+	}
+
+	for _, tt := range tests {
+		// selectArtifact sets warnings on the candidates it returns
+		list := append([]PluginArtifact{}, candidates...)
+		target := tt.target
+		got, err := selectArtifact(list, tt.requested, &target)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: got %s, want an error", tt.name, got.Version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.Version != tt.version || (got.Warning != "") != tt.warning {
+			t.Errorf("%s: got %s (warning %q), want %s (warning %v)", tt.name, got.Version, got.Warning, tt.version, tt.warning)
+		}
+	}
+
+	if _, err := selectArtifact(nil, "", &ServerPlatform{Platform: PlatformPaper}); err == nil {
+		t.Error("selectArtifact with no candidates should fail")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Number of revisions kept per file unless history_max_revisions says otherwise
+const defaultHistoryMaxRevisions = 50
+
+// FileRevision is an earlier version of a file, kept when ReplacedBy wrote
+// over it
+type FileRevision struct {
+	ID         int64  `json:"id"`
+	ServerID   string `json:"server_id"`
+	Path       string `json:"path"`
+	Hash       string `json:"hash"`
+	Size       int64  `json:"size"`
+	ReplacedBy string `json:"replaced_by"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// recordRevision stores content as the newest revision of a file, skipping it
+// when it matches the newest stored revision, and prunes old revisions.
+// replacedBy is the user about to overwrite content, not its author.
+func recordRevision(db *sql.DB, serverID, file string, content []byte, replacedBy string) error {
+	hash := contentHash(content)
+
+	var latest string
+	db.QueryRow("SELECT hash FROM file_revisions WHERE server_id = ? AND path = ? ORDER BY id DESC LIMIT 1",
+		serverID, file).Scan(&latest)
+	if latest == hash {
+		return nil
+	}
+
+	_, err := db.Exec("INSERT INTO file_revisions (server_id, path, hash, size, content, replaced_by) VALUES (?, ?, ?, ?, ?, ?)",
+		serverID, file, hash, len(content), content, replacedBy)
+	if err != nil {
+		return err
+	}
+
+	keep := GetSettingInt(db, "history_max_revisions", defaultHistoryMaxRevisions)
+	_, err = db.Exec(`DELETE FROM file_revisions WHERE server_id = ? AND path = ? AND id NOT IN (
+		SELECT id FROM file_revisions WHERE server_id = ? AND path = ? ORDER BY id DESC LIMIT ?)`,
+		serverID, file, serverID, file, keep)
+	return err
+}
+
+// keepCurrentRevision saves the current contents of a file as a revision
+// before PanelManager overwrites or deletes it. Only text files the editor
+// could open are kept; missing, binary and oversized files are skipped.
+func keepCurrentRevision(db *sql.DB, client *PteroClient, serverID, file, replacedBy string) error {
+	limit := GetSettingInt(db, "editor_max_file_kb", defaultEditorMaxFileKB) << 10
+	previous, err := client.ReadFile(serverID, file, limit)
+	switch {
+	case err == nil && isBinaryContent(previous):
+		// Jars, worlds and other binaries have no history
+	case err == nil:
+		if err := recordRevision(db, serverID, file, previous, replacedBy); err != nil {
+			return fmt.Errorf("failed to save previous version: %v", err)
+		}
+	case err == errFileTooLarge:
+		// Too large for the editor, so there is no history either
+	case err == errFileNotFound || pteroStatus(err) == http.StatusNotFound:
+		// New file, nothing to keep
+	default:
+		return fmt.Errorf("failed to read previous version: %v", err)
+	}
+	return nil
+}
+
+// writeFileWithHistory writes a file through the panel after saving its
+// current contents as a revision. Every write PanelManager makes to a text
+// file should go through here so it can be rolled back.
+func writeFileWithHistory(db *sql.DB, client *PteroClient, serverID, file string, content []byte, author string) error {
+	if err := keepCurrentRevision(db, client, serverID, file, author); err != nil {
+		return err
+	}
+	return client.WriteFile(serverID, file, content)
+}
+
+func getRevision(db *sql.DB, serverID string, id int64) (*FileRevision, []byte, error) {
+	var rev FileRevision
+	var content []byte
+	err := db.QueryRow("SELECT id, server_id, path, hash, size, replaced_by, created_at, content FROM file_revisions WHERE server_id = ? AND id = ?",
+		serverID, id).Scan(&rev.ID, &rev.ServerID, &rev.Path, &rev.Hash, &rev.Size, &rev.ReplacedBy, &rev.CreatedAt, &content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &rev, content, nil
+}
+
+func revisionFromParam(c *gin.Context, db *sql.DB, param string) (*FileRevision, []byte, bool) {
+	id, err := strconv.ParseInt(c.Param(param), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision id"})
+		return nil, nil, false
+	}
+	rev, content, err := getRevision(db, c.Param("id"), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return nil, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	return rev, content, true
+}
+
+// ListRevisionsHandler lists stored revisions of a file, newest first
+func ListRevisionsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		file, err := normalizeServerPath(c.Query("file"))
+		if err != nil || file == "/" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A file path is required"})
+			return
+		}
+
+		rows, err := db.Query("SELECT id, server_id, path, hash, size, replaced_by, created_at FROM file_revisions WHERE server_id = ? AND path = ? ORDER BY id DESC",
+			serverID, file)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		revisions := []FileRevision{}
+		for rows.Next() {
+			var rev FileRevision
+			rows.Scan(&rev.ID, &rev.ServerID, &rev.Path, &rev.Hash, &rev.Size, &rev.ReplacedBy, &rev.CreatedAt)
+			revisions = append(revisions, rev)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"file": file, "revisions": revisions})
+	}
+}
+
+// GetRevisionHandler returns the stored content of a revision
+func GetRevisionHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rev, content, ok := revisionFromParam(c, db, "rev")
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"revision": rev, "content": string(content)})
+	}
+}
+
+// DiffRevisionHandler shows a unified diff from a revision to another one,
+// or to the file as it is now when against is "current" (the default)
+func DiffRevisionHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		rev, content, ok := revisionFromParam(c, db, "rev")
+		if !ok {
+			return
+		}
+
+		against := c.DefaultQuery("against", "current")
+		var otherName string
+		var other []byte
+		if against == "current" {
+			client, err := NewPteroClientAPI(db)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			limit := GetSettingInt(db, "editor_max_file_kb", defaultEditorMaxFileKB) << 10
+			other, err = client.ReadFile(serverID, rev.Path, limit)
+			if err != nil && pteroStatus(err) != http.StatusNotFound {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			otherName = rev.Path + " (current)"
+		} else {
+			otherID, err := strconv.ParseInt(against, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "against must be a revision id or current"})
+				return
+			}
+			otherRev, otherContent, err := getRevision(db, serverID, otherID)
+			if err != nil || otherRev.Path != rev.Path {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found for this file"})
+				return
+			}
+			other = otherContent
+			otherName = fmt.Sprintf("%s (revision %d)", rev.Path, otherRev.ID)
+		}
+
+		diff := unifiedDiff(fmt.Sprintf("%s (revision %d)", rev.Path, rev.ID), otherName, string(content), string(other), 3)
+		c.JSON(http.StatusOK, gin.H{
+			"file":      rev.Path,
+			"from":      rev.ID,
+			"to":        against,
+			"identical": diff == "",
+			"diff":      diff,
+		})
+	}
+}
+
+// RestoreRevisionHandler writes a revision back to the server. The content
+// being replaced is stored as a new revision first, so a restore can itself
+// be undone.
+func RestoreRevisionHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.Param("id")
+		rev, content, ok := revisionFromParam(c, db, "rev")
+		if !ok {
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := writeFileWithHistory(db, client, serverID, rev.Path, content, CurrentUsername(c, db)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Revision restored",
+			"file":     rev.Path,
+			"revision": rev.ID,
+			"hash":     rev.Hash,
+		})
+	}
+}
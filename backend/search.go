@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Search limits; the query can lower them but never raise them past the max
+const (
+	defaultSearchDepth     = 8
+	maxSearchDepth         = 32
+	defaultSearchFiles     = 5000
+	maxSearchFiles         = 50000
+	defaultSearchContentKB = 256
+	maxSearchLinesPerFile  = 20
+	maxSearchLineLength    = 300
+)
+
+var (
+	errStopWalk = errors.New("stop walk")
+	errSkipDir  = errors.New("skip directory")
+)
+
+// walkServerFiles visits every entry below root breadth-first, up to maxDepth
+// directory levels. visit gets the entry's full path and either the entry or
+// the error from listing that path. Returning errSkipDir for a directory
+// skips it, errStopWalk ends the walk, and any other error is returned.
+func walkServerFiles(client *PteroClient, serverID, root string, maxDepth int, visit func(p string, f *FileObject, err error) error) error {
+	type pending struct {
+		dir   string
+		depth int
+	}
+	queue := []pending{{dir: root, depth: 0}}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		entries, err := client.ListFiles(serverID, current.dir)
+		if err != nil {
+			if err := visit(current.dir, nil, err); err != nil {
+				if err == errStopWalk {
+					return nil
+				}
+				if err != errSkipDir {
+					return err
+				}
+			}
+			continue
+		}
+
+		for i := range entries {
+			entry := &entries[i]
+			if entry.IsSymlink {
+				continue
+			}
+			full := path.Join(current.dir, entry.Name)
+			err := visit(full, entry, nil)
+			if err == errStopWalk {
+				return nil
+			}
+			if err == errSkipDir {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !entry.IsFile && current.depth+1 < maxDepth {
+				queue = append(queue, pending{dir: full, depth: current.depth + 1})
+			}
+		}
+	}
+	return nil
+}
+
+type searchLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+type searchEvent struct {
+	Type       string       `json:"type"`
+	Path       string       `json:"path,omitempty"`
+	Size       int64        `json:"size,omitempty"`
+	ModifiedAt *time.Time   `json:"modified_at,omitempty"`
+	Lines      []searchLine `json:"lines,omitempty"`
+	Error      string       `json:"error,omitempty"`
+
+	ScannedFiles int  `json:"scanned_files,omitempty"`
+	ScannedDirs  int  `json:"scanned_dirs,omitempty"`
+	Matches      int  `json:"matches,omitempty"`
+	Truncated    bool `json:"truncated,omitempty"`
+}
+
+// matchLines returns the lines of content that match pattern, capped per file
+func matchLines(content []byte, pattern *regexp.Regexp) []searchLine {
+	var lines []searchLine
+	for i, line := range strings.Split(string(content), "\n") {
+		if !pattern.MatchString(line) {
+			continue
+		}
+		line = strings.TrimRight(line, "\r")
+		if len(line) > maxSearchLineLength {
+			line = line[:maxSearchLineLength] + "…"
+		}
+		lines = append(lines, searchLine{Line: i + 1, Text: line})
+		if len(lines) >= maxSearchLinesPerFile {
+			break
+		}
+	}
+	return lines
+}
+
+func boundedQueryInt(c *gin.Context, key string, def, max int) int {
+	n, err := strconv.Atoi(c.Query(key))
+	if err != nil || n <= 0 {
+		return def
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// SearchFilesHandler walks a server's files looking for names matching a
+// glob and, optionally, contents matching a regular expression. Results are
+// streamed as newline-delimited JSON while the walk is in progress, ending
+// with a "done" event that carries the totals.
+func SearchFilesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		root, err := normalizeServerPath(c.DefaultQuery("directory", "/"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		nameGlob := c.DefaultQuery("name", "*")
+		if _, err := path.Match(nameGlob, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid name pattern: " + err.Error()})
+			return
+		}
+		ignoreCase := c.Query("ignore_case") == "true"
+		if ignoreCase {
+			nameGlob = strings.ToLower(nameGlob)
+		}
+
+		var contentPattern *regexp.Regexp
+		if expr := c.Query("content"); expr != "" {
+			if ignoreCase {
+				expr = "(?i)" + expr
+			}
+			contentPattern, err = regexp.Compile(expr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid content pattern: " + err.Error()})
+				return
+			}
+		}
+
+		maxDepth := boundedQueryInt(c, "max_depth", defaultSearchDepth, maxSearchDepth)
+		maxFiles := boundedQueryInt(c, "max_files", defaultSearchFiles, maxSearchFiles)
+		editorLimit := int(GetSettingInt(db, "editor_max_file_kb", defaultEditorMaxFileKB))
+		contentLimit := int64(boundedQueryInt(c, "content_max_kb", defaultSearchContentKB, editorLimit)) << 10
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		send := func(event searchEvent) {
+			encoder.Encode(event)
+			c.Writer.Flush()
+		}
+
+		ctx := c.Request.Context()
+
+		done := searchEvent{Type: "done"}
+		err = walkServerFiles(client, id, root, maxDepth, func(p string, f *FileObject, err error) error {
+			if ctx.Err() != nil {
+				return errStopWalk
+			}
+			if err != nil {
+				send(searchEvent{Type: "error", Path: p, Error: err.Error()})
+				return nil
+			}
+			if !f.IsFile {
+				done.ScannedDirs++
+				return nil
+			}
+
+			done.ScannedFiles++
+			if done.ScannedFiles > maxFiles {
+				done.ScannedFiles--
+				done.Truncated = true
+				return errStopWalk
+			}
+
+			name := f.Name
+			if ignoreCase {
+				name = strings.ToLower(name)
+			}
+			if ok, _ := path.Match(nameGlob, name); !ok {
+				return nil
+			}
+
+			modified := f.ModifiedAt
+			event := searchEvent{Type: "match", Path: p, Size: f.Size, ModifiedAt: &modified}
+			if contentPattern != nil {
+				if f.Size > contentLimit {
+					return nil
+				}
+				content, err := client.ReadFile(id, p, contentLimit)
+				if err != nil || isBinaryContent(content) {
+					return nil
+				}
+				event.Lines = matchLines(content, contentPattern)
+				if len(event.Lines) == 0 {
+					return nil
+				}
+			}
+
+			done.Matches++
+			send(event)
+			return nil
+		})
+		if err != nil {
+			send(searchEvent{Type: "error", Error: err.Error()})
+		}
+
+		send(done)
+	}
+}
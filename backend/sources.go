@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sent with every request to a plugin source; Modrinth and Hangar ask for an
+// identifying User-Agent
+const sourceUserAgent = "PanelManager (+https://github.com/senzore/panelmanager)"
+
+// Minimum time between requests to each source's API, by host
+var sourceRateLimits = map[string]time.Duration{
+	"hangar.papermc.io":  200 * time.Millisecond,
+	"api.modrinth.com":   200 * time.Millisecond, // 300 per minute
+	"api.spiget.org":     500 * time.Millisecond,
+	"api.curseforge.com": 100 * time.Millisecond,
+}
+
+// Longest a 429 Retry-After is honoured before giving up
+const maxSourceRetryAfter = 10 * time.Second
+
+var (
+	errSourceNotConfigured = errors.New("source is not configured")
+	errNotFound            = errors.New("not found")
+)
+
+// sourceUnavailableError marks a source that could not be reached or failed
+// on its side (network errors, rate limiting, 5xx), as opposed to one that
+// answered. Only these fall back to the artifact cache.
+type sourceUnavailableError struct {
+	err error
+}
+
+func (e *sourceUnavailableError) Error() string { return e.err.Error() }
+
+func (e *sourceUnavailableError) Unwrap() error { return e.err }
+
+func sourceUnavailable(err error) bool {
+	var u *sourceUnavailableError
+	return errors.As(err, &u)
+}
+
+// sourceStatusError describes a response a source failed with
+func sourceStatusError(status int) error {
+	err := fmt.Errorf("request failed (status %d)", status)
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return &sourceUnavailableError{err}
+	}
+	return err
+}
+
+// API calls time out; downloads of large files may take as long as they need
+var (
+	sourceAPIClient      = &http.Client{Timeout: 30 * time.Second}
+	sourceDownloadClient = &http.Client{}
+)
+
+// rateLimiter spaces calls at least interval apart
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (r *rateLimiter) Wait() {
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+	time.Sleep(wait)
+}
+
+var sourceLimiters = func() map[string]*rateLimiter {
+	limiters := map[string]*rateLimiter{}
+	for host, interval := range sourceRateLimits {
+		limiters[host] = &rateLimiter{interval: interval}
+	}
+	return limiters
+}()
+
+// doSourceRequest sends a request to a plugin source with the User-Agent
+// set, waiting its turn under the host's rate limit. A 429 is retried once
+// after the delay the source asks for.
+func doSourceRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", sourceUserAgent)
+	limiter := sourceLimiters[req.URL.Host]
+	for attempt := 0; ; attempt++ {
+		if limiter != nil {
+			limiter.Wait()
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, &sourceUnavailableError{err}
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt > 0 || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		resp.Body.Close()
+
+		delay := time.Second
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			delay = time.Duration(s) * time.Second
+		}
+		if delay > maxSourceRetryAfter {
+			return nil, &sourceUnavailableError{fmt.Errorf("%s is rate limiting requests, try again in %s", req.URL.Host, delay)}
+		}
+		time.Sleep(delay)
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func sourceDo(req *http.Request) (*http.Response, error) {
+	return doSourceRequest(sourceAPIClient, req)
+}
+
+// sourceDownload starts downloading a file from a plugin source or its CDN
+func sourceDownload(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return doSourceRequest(sourceDownloadClient, req)
+}
+
+func getJSON(endpoint string, v interface{}) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	return sendJSON(req, v)
+}
+
+func postJSON(endpoint string, body, v interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return sendJSON(req, v)
+}
+
+func sendJSON(req *http.Request, v interface{}) error {
+	resp, err := sourceDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", req.URL.Host, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return sourceStatusError(resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %v", req.URL.Host, err)
+	}
+	return nil
+}
+
+// Kinds of project a source can be searched for
+const (
+	KindPlugin  = "plugin"
+	KindMod     = "mod"
+	KindModpack = "modpack"
+)
+
+// Search orders
+const (
+	SortRelevance = "relevance"
+	SortDownloads = "downloads"
+	SortUpdated   = "updated"
+)
+
+// Results per page unless the search asks for a limit, and the most it may
+// ask for. Sources with a lower maximum return fewer.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchQuery is a search on a plugin source. Target carries the server's
+// platform, or just a loader, and is never nil. Categories use each source's
+// own category names (ids on CurseForge).
+type SearchQuery struct {
+	Text       string
+	Kind       string
+	Version    string
+	Target     *ServerPlatform
+	Offset     int
+	Limit      int
+	Sort       string
+	Categories []string
+}
+
+// SearchPage is one page of search results. Total counts every match, or
+// is -1 when the source does not say.
+type SearchPage struct {
+	Results []PluginResult `json:"results"`
+	Total   int            `json:"total"`
+}
+
+// searchQueryFromRequest reads the paging, sort and category parameters of
+// a search request (?offset=, ?limit=, ?sort=, ?category= repeated)
+func searchQueryFromRequest(c *gin.Context, kind, version string, target *ServerPlatform) (SearchQuery, error) {
+	q := SearchQuery{
+		Text:       c.Query("q"),
+		Kind:       kind,
+		Version:    version,
+		Target:     target,
+		Limit:      defaultSearchLimit,
+		Sort:       c.DefaultQuery("sort", SortRelevance),
+		Categories: c.QueryArray("category"),
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid offset %q", v)
+		}
+		q.Offset = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+		q.Limit = n
+	}
+	switch q.Sort {
+	case SortRelevance, SortDownloads, SortUpdated:
+	default:
+		return q, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	return q, nil
+}
+
+// PluginSource is a site plugins, mods or modpacks are installed from
+type PluginSource interface {
+	Name() string
+	// Supports reports whether the source hosts projects of kind
+	Supports(kind string) bool
+	Search(q SearchQuery) (*SearchPage, error)
+	// Versions lists the downloadable versions of a project, newest first
+	Versions(slug string, target *ServerPlatform) ([]PluginArtifact, error)
+	// Resolve picks the version of a project to install on target
+	Resolve(slug, version string, target *ServerPlatform) (*PluginArtifact, error)
+	// Dependencies returns an artifact's dependencies with their slugs and
+	// names filled in where the source only gives ids
+	Dependencies(artifact *PluginArtifact) ([]PluginDependency, error)
+}
+
+var pluginSources = struct {
+	sync.RWMutex
+	byName map[string]PluginSource
+	order  []string
+}{byName: map[string]PluginSource{}}
+
+// RegisterPluginSource makes a source available under its name. Sources
+// registered first are preferred when search results are merged.
+func RegisterPluginSource(s PluginSource) {
+	pluginSources.Lock()
+	defer pluginSources.Unlock()
+	if _, exists := pluginSources.byName[s.Name()]; !exists {
+		pluginSources.order = append(pluginSources.order, s.Name())
+	}
+	pluginSources.byName[s.Name()] = s
+}
+
+func GetPluginSource(name string) (PluginSource, error) {
+	pluginSources.RLock()
+	defer pluginSources.RUnlock()
+	s, ok := pluginSources.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin source %q", name)
+	}
+	return s, nil
+}
+
+func allPluginSources() []PluginSource {
+	pluginSources.RLock()
+	defer pluginSources.RUnlock()
+	sources := make([]PluginSource, 0, len(pluginSources.order))
+	for _, name := range pluginSources.order {
+		sources = append(sources, pluginSources.byName[name])
+	}
+	return sources
+}
+
+// InitPluginSources registers the built-in sources
+func InitPluginSources(db *sql.DB) {
+	RegisterPluginSource(hangarSource{})
+	RegisterPluginSource(modrinthSource{})
+	RegisterPluginSource(spigotSource{})
+	RegisterPluginSource(curseForgeSource{db: db})
+}
+
+// resolveFromSource picks a version out of everything a source offers
+func resolveFromSource(s PluginSource, slug, version string, target *ServerPlatform) (*PluginArtifact, error) {
+	candidates, err := s.Versions(slug, target)
+	if err != nil {
+		return nil, err
+	}
+	artifact, err := selectArtifact(candidates, version, target)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", slug, err)
+	}
+	artifact.Source = s.Name()
+	artifact.Slug = slug
+	return artifact, nil
+}
+
+// searchSources runs a search on one source, or with source "all" on every
+// source supporting the kind at once. Every source is asked for its page at
+// q.Offset and the merged results are cut back to q.Limit, so an "all" page
+// is never longer than a single source's. Failing sources are reported in the returned map rather than failing the
+// whole search; sources that are not configured are left out.
+func searchSources(source string, q SearchQuery) (*SearchPage, map[string]string, error) {
+	if source != "all" {
+		s, err := GetPluginSource(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !s.Supports(q.Kind) {
+			return nil, nil, fmt.Errorf("%s has no %ss", source, q.Kind)
+		}
+		page, err := s.Search(q)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s search failed: %v", source, err)
+		}
+		return page, nil, nil
+	}
+
+	var sources []PluginSource
+	for _, s := range allPluginSources() {
+		if s.Supports(q.Kind) {
+			sources = append(sources, s)
+		}
+	}
+	pages := make([]*SearchPage, len(sources))
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, s := range sources {
+		wg.Add(1)
+		go func(i int, s PluginSource) {
+			defer wg.Done()
+			pages[i], errs[i] = s.Search(q)
+		}(i, s)
+	}
+	wg.Wait()
+
+	failures := map[string]string{}
+	total := 0
+	var lists [][]PluginResult
+	for i, err := range errs {
+		if err != nil {
+			if !errors.Is(err, errSourceNotConfigured) {
+				failures[sources[i].Name()] = err.Error()
+			}
+			continue
+		}
+		if sources[i].Name() == "modrinth" {
+			if err := fillModrinthHashes(pages[i].Results); err != nil {
+				log.Printf("[WARN] Could not look up Modrinth file hashes: %v", err)
+			}
+		}
+		lists = append(lists, pages[i].Results)
+		if pages[i].Total < 0 || total < 0 {
+			total = -1
+		} else {
+			total += pages[i].Total
+		}
+	}
+	results := mergeSearchResults(lists, q.Sort)
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return &SearchPage{Results: results, Total: total}, failures, nil
+}
+
+// mergeSearchResults combines results from several sources in the order
+// asked for; for relevance each source's best match comes first, then each
+// one's second best and so on. The same project published on more than one
+// source, recognised by a shared file hash, is listed once under the
+// preferred source with the others in AlsoOn. Results that only share a name
+// stay separate and point at each other through SameName. Hangar offers no
+// hash the other sources have, so its results are only matched by name.
+func mergeSearchResults(lists [][]PluginResult, order string) []PluginResult {
+	merged := []PluginResult{}
+	rank := []int{}
+	byHash := map[string]int{}
+	byName := map[string]int{}
+	for _, list := range lists {
+	results:
+		for r, result := range list {
+			for _, hash := range result.fileHashes {
+				if i, ok := byHash[hash]; ok {
+					merged[i].AlsoOn = append(merged[i].AlsoOn, PluginResultRef{Source: result.Source, Slug: result.Slug})
+					merged[i].Downloads += result.Downloads
+					rank[i] = min(rank[i], r)
+					continue results
+				}
+			}
+
+			i := len(merged)
+			for _, hash := range result.fileHashes {
+				byHash[hash] = i
+			}
+			key := normalizePluginName(result.Name)
+			if j, ok := byName[key]; ok && key != "" && merged[j].Source != result.Source {
+				merged[j].SameName = append(merged[j].SameName, PluginResultRef{Source: result.Source, Slug: result.Slug})
+				result.SameName = append(result.SameName, PluginResultRef{Source: merged[j].Source, Slug: merged[j].Slug})
+			} else {
+				byName[key] = i
+			}
+			merged = append(merged, result)
+			rank = append(rank, r)
+		}
+	}
+
+	index := make([]int, len(merged))
+	for i := range index {
+		index[i] = i
+	}
+	updated := func(r PluginResult) time.Time {
+		t, _ := time.Parse(time.RFC3339Nano, r.UpdatedAt)
+		return t
+	}
+	sort.SliceStable(index, func(i, j int) bool {
+		a, b := index[i], index[j]
+		switch order {
+		case SortDownloads:
+			return merged[a].Downloads > merged[b].Downloads
+		case SortUpdated:
+			return updated(merged[a]).After(updated(merged[b]))
+		}
+		return rank[a] < rank[b]
+	})
+	sorted := make([]PluginResult, len(merged))
+	for i, m := range index {
+		sorted[i] = merged[m]
+	}
+	return sorted
+}
+
+type hangarSource struct{}
+
+func (hangarSource) Name() string { return "hangar" }
+
+func (hangarSource) Supports(kind string) bool { return kind == KindPlugin }
+
+func (hangarSource) Search(q SearchQuery) (*SearchPage, error) {
+	return searchHangar(q)
+}
+
+func (hangarSource) Versions(slug string, target *ServerPlatform) ([]PluginArtifact, error) {
+	return hangarArtifacts(slug, target)
+}
+
+func (s hangarSource) Resolve(slug, version string, target *ServerPlatform) (*PluginArtifact, error) {
+	return resolveFromSource(s, slug, version, target)
+}
+
+func (hangarSource) Dependencies(artifact *PluginArtifact) ([]PluginDependency, error) {
+	return artifact.Dependencies, nil
+}
+
+type modrinthSource struct{}
+
+func (modrinthSource) Name() string { return "modrinth" }
+
+func (modrinthSource) Supports(kind string) bool { return true }
+
+func (modrinthSource) Search(q SearchQuery) (*SearchPage, error) {
+	var loaders []string
+	if q.Kind == KindPlugin || q.Target.IsModded() {
+		loaders = q.Target.Loaders()
+	}
+	return searchModrinth(q, loaders)
+}
+
+func (modrinthSource) Versions(slug string, target *ServerPlatform) ([]PluginArtifact, error) {
+	return modrinthArtifacts(slug)
+}
+
+func (s modrinthSource) Resolve(slug, version string, target *ServerPlatform) (*PluginArtifact, error) {
+	return resolveFromSource(s, slug, version, target)
+}
+
+// Dependencies swaps the project ids Modrinth versions list for slugs
+func (modrinthSource) Dependencies(artifact *PluginArtifact) ([]PluginDependency, error) {
+	deps := make([]PluginDependency, 0, len(artifact.Dependencies))
+	for _, dep := range artifact.Dependencies {
+		if dep.Slug != "" && dep.Type != DependencyEmbedded {
+			if slug, title, err := modrinthProjectSlug(dep.Slug); err == nil {
+				dep.Slug, dep.Name = slug, title
+			}
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
+
+type spigotSource struct{}
+
+func (spigotSource) Name() string { return "spigot" }
+
+func (spigotSource) Supports(kind string) bool { return kind == KindPlugin }
+
+func (spigotSource) Search(q SearchQuery) (*SearchPage, error) {
+	return searchSpigot(q)
+}
+
+func (spigotSource) Versions(slug string, target *ServerPlatform) ([]PluginArtifact, error) {
+	return spigotArtifacts(slug)
+}
+
+func (s spigotSource) Resolve(slug, version string, target *ServerPlatform) (*PluginArtifact, error) {
+	return resolveFromSource(s, slug, version, target)
+}
+
+func (spigotSource) Dependencies(artifact *PluginArtifact) ([]PluginDependency, error) {
+	return artifact.Dependencies, nil
+}
+
+type curseForgeSource struct {
+	db *sql.DB
+}
+
+func (curseForgeSource) Name() string { return "curseforge" }
+
+func (curseForgeSource) Supports(kind string) bool { return true }
+
+func (s curseForgeSource) Search(q SearchQuery) (*SearchPage, error) {
+	classID := curseForgeClassPlugins
+	switch q.Kind {
+	case KindMod:
+		classID = curseForgeClassMods
+	case KindModpack:
+		classID = curseForgeClassModpacks
+	}
+	var loaders []string
+	if q.Target.IsModded() {
+		loaders = q.Target.Loaders()
+	}
+	return searchCurseForge(s.db, q, classID, loaders)
+}
+
+func (s curseForgeSource) Versions(slug string, target *ServerPlatform) ([]PluginArtifact, error) {
+	return curseForgeArtifacts(s.db, slug)
+}
+
+func (s curseForgeSource) Resolve(slug, version string, target *ServerPlatform) (*PluginArtifact, error) {
+	return resolveFromSource(s, slug, version, target)
+}
+
+// Dependencies looks up the names of the projects CurseForge lists by id
+func (s curseForgeSource) Dependencies(artifact *PluginArtifact) ([]PluginDependency, error) {
+	deps := make([]PluginDependency, 0, len(artifact.Dependencies))
+	for _, dep := range artifact.Dependencies {
+		if dep.Slug != "" && dep.Type != DependencyEmbedded {
+			if name, err := curseForgeModName(s.db, dep.Slug); err == nil {
+				dep.Name = name
+			}
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMergeSearchResults(t *testing.T) {
+	modrinth := []PluginResult{
+		{Name: "LuckPerms", Source: "modrinth", Slug: "luckperms", Downloads: 100, UpdatedAt: "2024-03-01T00:00:00Z", fileHashes: []string{"sha1:aa"}},
+		{Name: "Chunky", Source: "modrinth", Slug: "chunky", Downloads: 50, UpdatedAt: "2024-01-01T00:00:00Z", fileHashes: []string{"sha1:bb"}},
+	}
+	curseforge := []PluginResult{
+		{Name: "Chunky", Source: "curseforge", Slug: "123", Downloads: 300, UpdatedAt: "2024-02-01T00:00:00Z", fileHashes: []string{"sha1:bb"}},
+		{Name: "Luck Perms", Source: "curseforge", Slug: "456", Downloads: 20, UpdatedAt: "2024-04-01T00:00:00Z", fileHashes: []string{"sha1:cc"}},
+	}
+	hangar := []PluginResult{
+		{Name: "Essentials", Source: "hangar", Slug: "EssentialsX", Downloads: 200, UpdatedAt: "2023-12-01T00:00:00Z"},
+	}
+
+	type entry struct {
+		slug     string
+		alsoOn   []PluginResultRef
+		sameName []PluginResultRef
+	}
+	tests := []struct {
+		name  string
+		lists [][]PluginResult
+		order string
+		want  []entry
+	}{
+		{
+			name:  "relevance interleaves sources and merges shared hashes",
+			lists: [][]PluginResult{modrinth, curseforge, hangar},
+			order: SortRelevance,
+			want: []entry{
+				{slug: "luckperms", sameName: []PluginResultRef{{Source: "curseforge", Slug: "456"}}},
+				{slug: "chunky", alsoOn: []PluginResultRef{{Source: "curseforge", Slug: "123"}}},
+				{slug: "EssentialsX"},
+				{slug: "456", sameName: []PluginResultRef{{Source: "modrinth", Slug: "luckperms"}}},
+			},
+		},
+		{
+			name:  "downloads add up across merged sources",
+			lists: [][]PluginResult{modrinth, curseforge, hangar},
+			order: SortDownloads,
+			want: []entry{
+				{slug: "chunky", alsoOn: []PluginResultRef{{Source: "curseforge", Slug: "123"}}},
+				{slug: "EssentialsX"},
+				{slug: "luckperms", sameName: []PluginResultRef{{Source: "curseforge", Slug: "456"}}},
+				{slug: "456", sameName: []PluginResultRef{{Source: "modrinth", Slug: "luckperms"}}},
+			},
+		},
+		{
+			name:  "recently updated first",
+			lists: [][]PluginResult{modrinth, curseforge, hangar},
+			order: SortUpdated,
+			want: []entry{
+				{slug: "456", sameName: []PluginResultRef{{Source: "modrinth", Slug: "luckperms"}}},
+				{slug: "luckperms", sameName: []PluginResultRef{{Source: "curseforge", Slug: "456"}}},
+				{slug: "chunky", alsoOn: []PluginResultRef{{Source: "curseforge", Slug: "123"}}},
+				{slug: "EssentialsX"},
+			},
+		},
+		{
+			name:  "same name on one source is not linked",
+			lists: [][]PluginResult{{{Name: "Chunky", Source: "spigot", Slug: "1"}, {Name: "Chunky", Source: "spigot", Slug: "2"}}},
+			order: SortRelevance,
+			want:  []entry{{slug: "1"}, {slug: "2"}},
+		},
+		{
+			name:  "nothing found",
+			lists: [][]PluginResult{{}, nil},
+			order: SortRelevance,
+			want:  []entry{},
+		},
+	}
+
+	for _, tt := range tests {
+		merged := mergeSearchResults(tt.lists, tt.order)
+		got := []entry{}
+		for _, r := range merged {
+			got = append(got, entry{slug: r.Slug, alsoOn: r.AlsoOn, sameName: r.SameName})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+
+	merged := mergeSearchResults([][]PluginResult{modrinth, curseforge}, SortDownloads)
+	if merged[0].Slug != "chunky" || merged[0].Downloads != 350 {
+		t.Errorf("merged downloads = %d, want 350", merged[0].Downloads)
+	}
+}
+
+func TestSendJSONNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	var v struct {
+		OK bool `json:"ok"`
+	}
+	if err := getJSON(srv.URL+"/found", &v); err != nil || !v.OK {
+		t.Errorf("found: %v", err)
+	}
+	if err := getJSON(srv.URL+"/missing", &v); !errors.Is(err, errNotFound) {
+		t.Errorf("missing: got %v, want errNotFound", err)
+	}
+	if err := getJSON(srv.URL+"/broken", &v); err == nil || errors.Is(err, errNotFound) || !sourceUnavailable(err) {
+		t.Errorf("broken: got %v, want an unavailable source", err)
+	}
+}
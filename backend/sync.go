@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Most target servers a single sync may write to
+const maxSyncTargets = 50
+
+const (
+	syncCreate = "create"
+	syncUpdate = "update"
+	syncDelete = "delete"
+)
+
+type syncAction struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Size   int64  `json:"size"`
+	Error  string `json:"error,omitempty"`
+}
+
+type syncTargetResult struct {
+	Server    string       `json:"server"`
+	Directory string       `json:"directory"`
+	Actions   []syncAction `json:"actions"`
+	Unchanged int          `json:"unchanged"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// syncFilter decides which paths, relative to the synced directory, take
+// part in a sync. Patterns without a slash match any path component
+// ("*.db", "logs"); patterns with one match from the top ("data/*.yml").
+// Excluding a directory excludes everything below it.
+type syncFilter struct {
+	include []string
+	exclude []string
+}
+
+func newSyncFilter(include, exclude []string) (*syncFilter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(strings.Trim(pattern, "/"), ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return &syncFilter{include: include, exclude: exclude}, nil
+}
+
+func syncPatternMatches(pattern, rel string) bool {
+	pattern = strings.Trim(pattern, "/")
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, parts[i]); ok {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, strings.Join(parts[:i+1], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Excluded reports whether rel, or a directory containing it, is excluded
+func (f *syncFilter) Excluded(rel string) bool {
+	for _, pattern := range f.exclude {
+		if syncPatternMatches(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Selected reports whether the file at rel should be synced
+func (f *syncFilter) Selected(rel string) bool {
+	if f.Excluded(rel) {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if syncPatternMatches(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// listSyncFiles returns the selected files below root keyed by their path
+// relative to root. With allowMissing a missing root is treated as empty,
+// which only suits a target that the sync will create.
+func listSyncFiles(client *PteroClient, serverID, root string, filter *syncFilter, allowMissing bool) (map[string]FileObject, error) {
+	files := map[string]FileObject{}
+	err := walkServerFiles(client, serverID, root, maxSearchDepth, func(p string, f *FileObject, err error) error {
+		if err != nil {
+			if p == root && (err == errFileNotFound || pteroStatus(err) == http.StatusNotFound) {
+				if allowMissing {
+					return errStopWalk
+				}
+				return fmt.Errorf("%s does not exist on %s", root, serverID)
+			}
+			return fmt.Errorf("failed to list %s: %v", p, err)
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+		if !f.IsFile {
+			if filter.Excluded(rel) {
+				return errSkipDir
+			}
+			return nil
+		}
+		if filter.Selected(rel) {
+			files[rel] = *f
+		}
+		return nil
+	})
+	return files, err
+}
+
+func hashServerFile(client *PteroClient, serverID, file string) (string, error) {
+	resp, err := client.OpenFile(serverID, file, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// planSync compares the source files with a target directory. Files of equal
+// size are compared by content hash; sourceHashes caches the source side
+// across targets.
+func planSync(client *PteroClient, sourceID, sourceDir string, source map[string]FileObject, sourceHashes map[string]string,
+	targetID, targetDir string, filter *syncFilter, deleteExtra bool) (*syncTargetResult, error) {
+
+	target, err := listSyncFiles(client, targetID, targetDir, filter, true)
+	if err != nil {
+		return nil, err
+	}
+	if deleteExtra && len(source) == 0 && len(target) > 0 {
+		return nil, fmt.Errorf("the source has no files to sync; refusing to delete all %d files in %s", len(target), targetDir)
+	}
+
+	result := &syncTargetResult{Server: targetID, Directory: targetDir, Actions: []syncAction{}}
+	names := make([]string, 0, len(source))
+	for rel := range source {
+		names = append(names, rel)
+	}
+	sort.Strings(names)
+
+	for _, rel := range names {
+		src := source[rel]
+		dst, exists := target[rel]
+		if !exists {
+			result.Actions = append(result.Actions, syncAction{Path: rel, Action: syncCreate, Size: src.Size})
+			continue
+		}
+		if dst.Size == src.Size {
+			srcHash, ok := sourceHashes[rel]
+			if !ok {
+				srcHash, err = hashServerFile(client, sourceID, path.Join(sourceDir, rel))
+				if err != nil {
+					return nil, err
+				}
+				sourceHashes[rel] = srcHash
+			}
+			dstHash, err := hashServerFile(client, targetID, path.Join(targetDir, rel))
+			if err != nil {
+				return nil, err
+			}
+			if dstHash == srcHash {
+				result.Unchanged++
+				continue
+			}
+		}
+		result.Actions = append(result.Actions, syncAction{Path: rel, Action: syncUpdate, Size: src.Size})
+	}
+
+	if deleteExtra {
+		var extra []string
+		for rel := range target {
+			if _, ok := source[rel]; !ok {
+				extra = append(extra, rel)
+			}
+		}
+		sort.Strings(extra)
+		for _, rel := range extra {
+			result.Actions = append(result.Actions, syncAction{Path: rel, Action: syncDelete, Size: target[rel].Size})
+		}
+	}
+
+	return result, nil
+}
+
+// applySync carries out a planned sync, recording failures per action.
+// Files it overwrites or deletes are kept as revisions first, so they can be
+// restored from the file history.
+func applySync(job *Job, db *sql.DB, client *PteroClient, sourceID, sourceDir string, result *syncTargetResult, author string, done *int64, total int64) {
+	deletes := map[string][]int{}
+	for i := range result.Actions {
+		action := &result.Actions[i]
+		dst := path.Join(result.Directory, action.Path)
+		if action.Action == syncDelete {
+			if err := keepCurrentRevision(db, client, result.Server, dst, author); err != nil {
+				action.Error = err.Error()
+				*done++
+				continue
+			}
+			dir := path.Dir(dst)
+			deletes[dir] = append(deletes[dir], i)
+			continue
+		}
+
+		job.SetProgress(*done, total, fmt.Sprintf("Copying %s to %s", action.Path, result.Server))
+		var err error
+		if action.Action == syncUpdate {
+			err = keepCurrentRevision(db, client, result.Server, dst, author)
+		}
+		if err == nil {
+			var resp *http.Response
+			if resp, err = client.OpenFile(sourceID, path.Join(sourceDir, action.Path), ""); err == nil {
+				err = client.UploadFile(result.Server, path.Dir(dst), path.Base(dst), resp.Body)
+				resp.Body.Close()
+			}
+		}
+		if err != nil {
+			action.Error = err.Error()
+		}
+		*done++
+	}
+
+	for dir, indexes := range deletes {
+		job.SetProgress(*done, total, fmt.Sprintf("Deleting extra files in %s on %s", dir, result.Server))
+		names := make([]string, len(indexes))
+		for i, idx := range indexes {
+			names[i] = path.Base(result.Actions[idx].Path)
+		}
+		if err := client.DeleteFiles(result.Server, dir, names); err != nil {
+			for _, idx := range indexes {
+				result.Actions[idx].Error = err.Error()
+			}
+		}
+		*done += int64(len(indexes))
+	}
+}
+
+// SyncFilesHandler copies a directory tree from one server to one or more
+// target servers. With dry_run it only reports what would be created,
+// updated or deleted. Empty directories are not copied.
+func SyncFilesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			Directory       string   `json:"directory" binding:"required"`
+			Targets         []string `json:"targets" binding:"required"`
+			TargetDirectory string   `json:"target_directory"`
+			Include         []string `json:"include"`
+			Exclude         []string `json:"exclude"`
+			Delete          bool     `json:"delete"`
+			DryRun          bool     `json:"dry_run"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		sourceDir, err := normalizeServerPath(req.Directory)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		targetDir := sourceDir
+		if req.TargetDirectory != "" {
+			if targetDir, err = normalizeServerPath(req.TargetDirectory); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		if len(req.Targets) == 0 || len(req.Targets) > maxSyncTargets {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Between 1 and %d targets are required", maxSyncTargets)})
+			return
+		}
+		seen := map[string]bool{}
+		for _, target := range req.Targets {
+			if target == "" || seen[target] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Targets must be unique server ids"})
+				return
+			}
+			if target == id && (pathWithin(sourceDir, targetDir) || pathWithin(targetDir, sourceDir)) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Source and target directories overlap"})
+				return
+			}
+			seen[target] = true
+		}
+
+		filter, err := newSyncFilter(req.Include, req.Exclude)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		client, err := NewPteroClientAPI(db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// A missing source must not read as empty, or delete would wipe the targets
+		if root, err := client.StatFile(id, sourceDir); err != nil || root.IsFile {
+			switch {
+			case err == nil:
+				c.JSON(http.StatusBadRequest, gin.H{"error": sourceDir + " is not a directory"})
+			case err == errFileNotFound || pteroStatus(err) == http.StatusNotFound:
+				c.JSON(http.StatusNotFound, gin.H{"error": sourceDir + " does not exist"})
+			default:
+				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		author := CurrentUsername(c, db)
+		job := StartJob("sync", id, func(job *Job) (map[string]interface{}, error) {
+			job.SetProgress(0, 0, "Listing "+sourceDir)
+			source, err := listSyncFiles(client, id, sourceDir, filter, false)
+			if err != nil {
+				return nil, err
+			}
+
+			sourceHashes := map[string]string{}
+			results := make([]*syncTargetResult, 0, len(req.Targets))
+			var total int64
+			for _, target := range req.Targets {
+				job.SetProgress(0, 0, "Comparing with "+target)
+				result, err := planSync(client, id, sourceDir, source, sourceHashes, target, targetDir, filter, req.Delete)
+				if err != nil {
+					result = &syncTargetResult{Server: target, Directory: targetDir, Actions: []syncAction{}, Error: err.Error()}
+				}
+				total += int64(len(result.Actions))
+				results = append(results, result)
+			}
+
+			if !req.DryRun {
+				var done int64
+				for _, result := range results {
+					if result.Error == "" {
+						applySync(job, db, client, id, sourceDir, result, author, &done, total)
+					}
+				}
+				job.SetProgress(done, total, "Sync finished")
+			}
+
+			failed := 0
+			for _, result := range results {
+				if result.Error != "" {
+					failed++
+					continue
+				}
+				for _, action := range result.Actions {
+					if action.Error != "" {
+						failed++
+						break
+					}
+				}
+			}
+
+			return map[string]interface{}{
+				"directory":      sourceDir,
+				"files":          len(source),
+				"dry_run":        req.DryRun,
+				"targets":        results,
+				"failed_targets": failed,
+			}, nil
+		})
+
+		respondWithJob(c, job)
+	}
+}
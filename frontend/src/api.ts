@@ -82,19 +82,145 @@ export const files = {
     api.get(`/servers/${serverId}/files`, { params: { directory } }),
   delete: (serverId: string, root: string, files: string[]) =>
     api.delete(`/servers/${serverId}/files`, { data: { root, files } }),
-  download: (serverId: string, file: string) =>
-    api.get(`/servers/${serverId}/files/download`, { params: { file } }),
-  upload: (serverId: string) => api.post(`/servers/${serverId}/files/upload`),
+  download: (serverId: string, file: string | string[]) =>
+    api.get(`/servers/${serverId}/files/download`, {
+      params: { file },
+      paramsSerializer: { indexes: null },
+      responseType: 'blob',
+    }),
+  read: (serverId: string, file: string) =>
+    api.get(`/servers/${serverId}/files/contents`, { params: { file } }),
+  write: (serverId: string, data: { file: string; content: string; expected_hash?: string; expected_modified_at?: string; create?: boolean }) =>
+    api.put(`/servers/${serverId}/files/contents`, data),
+  createFolder: (serverId: string, root: string, name: string) =>
+    api.post(`/servers/${serverId}/files/folder`, { root, name }),
+  rename: (serverId: string, root: string, files: { from: string; to: string }[]) =>
+    api.put(`/servers/${serverId}/files/rename`, { root, files }),
+  copy: (serverId: string, root: string, files: string[]) =>
+    api.post(`/servers/${serverId}/files/copy`, { root, files }),
+  chmod: (serverId: string, root: string, files: { file: string; mode: string }[]) =>
+    api.post(`/servers/${serverId}/files/chmod`, { root, files }),
+  // Results stream back as newline-delimited JSON; read the response text incrementally
+  searchUrl: (serverId: string, params: Record<string, string>) =>
+    `/api/servers/${serverId}/files/search?${new URLSearchParams(params)}`,
+  pull: (serverId: string, data: { url: string; directory?: string; filename?: string; checksum?: string; method?: string }) =>
+    api.post(`/servers/${serverId}/files/pull`, data),
+  compress: (serverId: string, root: string, files: string[]) =>
+    api.post(`/servers/${serverId}/files/compress`, { root, files }),
+  decompress: (serverId: string, root: string, file: string) =>
+    api.post(`/servers/${serverId}/files/decompress`, { root, file }),
+  sync: (serverId: string, data: { directory: string; targets: string[]; target_directory?: string; include?: string[]; exclude?: string[]; delete?: boolean; dry_run?: boolean }) =>
+    api.post(`/servers/${serverId}/files/sync`, data),
+  upload: (serverId: string, directory: string, uploads: File[]) => {
+    const form = new FormData()
+    uploads.forEach((f) => form.append('files', f))
+    return api.post(`/servers/${serverId}/files/upload`, form, { params: { directory } })
+  },
+}
+
+export const revisions = {
+  list: (serverId: string, file: string) =>
+    api.get(`/servers/${serverId}/files/revisions`, { params: { file } }),
+  get: (serverId: string, rev: number) => api.get(`/servers/${serverId}/files/revisions/${rev}`),
+  diff: (serverId: string, rev: number, against: number | 'current' = 'current') =>
+    api.get(`/servers/${serverId}/files/revisions/${rev}/diff`, { params: { against } }),
+  restore: (serverId: string, rev: number) =>
+    api.post(`/servers/${serverId}/files/revisions/${rev}/restore`),
+}
+
+export const configs = {
+  list: (serverId: string) => api.get(`/servers/${serverId}/configs`),
+  get: (serverId: string, name: string) => api.get(`/servers/${serverId}/configs/${name}`),
+  update: (serverId: string, name: string, values: Record<string, any>, expected_hash?: string) =>
+    api.patch(`/servers/${serverId}/configs/${name}`, { values, expected_hash }),
 }
 
 export const plugins = {
-  search: (q: string, source: string, version: string) =>
-    api.get('/plugins/search', { params: { q, source, version } }),
-  install: (serverId: string, source: string, slug: string, version: string) =>
-    api.post(`/servers/${serverId}/plugins/install`, { source, slug, version }),
+  // Pass server to filter by its detected version and platform; source 'all'
+  // searches every source at once. Categories are repeated as category=
+  search: (q: string, source: string, version?: string, server?: string, options: { offset?: number; limit?: number; sort?: 'relevance' | 'downloads' | 'updated'; category?: string[] } = {}) =>
+    api.get('/plugins/search', { params: { q, source, version, server, ...options }, paramsSerializer: { indexes: null } }),
+  versions: (source: string, slug: string, server?: string) =>
+    api.get(`/plugins/${source}/${encodeURIComponent(slug)}/versions`, { params: { server } }),
+  platform: (serverId: string, refresh = false) =>
+    api.get(`/servers/${serverId}/platform`, { params: { refresh } }),
+  install: (serverId: string, source: string, slug: string, version: string, options: { include_optional?: boolean; optional?: string[]; force?: boolean } = {}) =>
+    api.post(`/servers/${serverId}/plugins/install`, { source, slug, version, ...options }),
+  plan: (serverId: string, source: string, slug: string, version: string, options: { include_optional?: boolean; optional?: string[]; force?: boolean } = {}) =>
+    api.post(`/servers/${serverId}/plugins/plan`, { source, slug, version, ...options }),
   list: (serverId: string) => api.get(`/servers/${serverId}/plugins`),
-  remove: (serverId: string, plugin: string) =>
-    api.delete(`/servers/${serverId}/plugins/${plugin}`),
+  remove: (serverId: string, plugin: string, options: { source?: string; data?: 'keep' | 'archive' | 'delete'; data_folder?: string; force?: boolean } = {}) =>
+    api.delete(`/servers/${serverId}/plugins/${plugin}`, { params: options }),
+  updates: (serverId: string, refresh = false) =>
+    api.get(`/servers/${serverId}/plugins/updates`, { params: { refresh } }),
+  update: (serverId: string, plugin: string, version?: string) =>
+    api.post(`/servers/${serverId}/plugins/${plugin}/update`, { version }),
+  updateAll: (serverId: string) => api.post(`/servers/${serverId}/plugins/update-all`),
+  scan: (serverId: string, dryRun?: boolean) =>
+    api.post(`/servers/${serverId}/plugins/scan`, null, { params: { dry_run: dryRun } }),
+  // Installs a local jar; force confirms one built for a newer Minecraft
+  upload: (serverId: string, file: File, force = false) => {
+    const form = new FormData()
+    form.append('file', file)
+    form.append('force', String(force))
+    return api.post(`/servers/${serverId}/plugins/upload`, form)
+  },
+}
+
+export const mods = {
+  // Pass server to filter by its detected loader and version
+  search: (q: string, server?: string, options: { source?: 'modrinth' | 'curseforge' | 'all'; loader?: string; version?: string; offset?: number; limit?: number; sort?: 'relevance' | 'downloads' | 'updated'; category?: string[] } = {}) =>
+    api.get('/mods/search', { params: { q, server, ...options }, paramsSerializer: { indexes: null } }),
+  install: (serverId: string, slug: string, version?: string, force = false, source = 'modrinth') =>
+    api.post(`/servers/${serverId}/mods/install`, { source, slug, version, force }),
+  list: (serverId: string) => api.get(`/servers/${serverId}/mods`),
+  remove: (serverId: string, slug: string) => api.delete(`/servers/${serverId}/mods/${slug}`),
+}
+
+// Pass file to install an uploaded .mrpack instead of a Modrinth modpack
+const modpackBody = (options: object, file?: File) => {
+  if (!file) return options
+  const form = new FormData()
+  form.append('file', file)
+  form.append('options', JSON.stringify(options))
+  return form
+}
+
+export const modpacks = {
+  search: (q: string, source = 'modrinth', options: { version?: string; loader?: string; offset?: number; limit?: number; sort?: 'relevance' | 'downloads' | 'updated'; category?: string[] } = {}) =>
+    api.get('/modpacks/search', { params: { q, source, ...options }, paramsSerializer: { indexes: null } }),
+  plan: (options: { source?: 'modrinth' | 'curseforge'; slug?: string; version?: string; server?: string; new_server?: any; reinstall?: boolean }, file?: File) =>
+    api.post('/modpacks/plan', modpackBody(options, file)),
+  install: (options: { source?: 'modrinth' | 'curseforge'; slug?: string; version?: string; server?: string; new_server?: any; reinstall?: boolean; force?: boolean }, file?: File) =>
+    api.post('/modpacks/install', modpackBody(options, file)),
+}
+
+// Named sets of plugins and config files applied to many servers. Plugin
+// versions may be exact, empty for the best fit, or constraints like '5.4.*'
+export const bundles = {
+  list: () => api.get('/bundles'),
+  get: (id: number) => api.get(`/bundles/${id}`),
+  create: (bundle: { name: string; description?: string; plugins: { source: string; slug: string; version?: string }[]; files?: { path: string; content: string }[] }) =>
+    api.post('/bundles', bundle),
+  update: (id: number, bundle: { name: string; description?: string; plugins: { source: string; slug: string; version?: string }[]; files?: { path: string; content: string }[] }) =>
+    api.put(`/bundles/${id}`, bundle),
+  delete: (id: number) => api.delete(`/bundles/${id}`),
+  plan: (id: number, servers: string[]) => api.post(`/bundles/${id}/plan`, { servers }),
+  // Runs as a job; results are reported per server
+  apply: (id: number, servers: string[], force = false) => api.post(`/bundles/${id}/apply`, { servers, force }),
+}
+
+// Downloaded plugin, mod and modpack files kept for reuse
+export const artifactCache = {
+  list: () => api.get('/cache/artifacts'),
+  // Without a project everything is purged
+  purge: (project?: { source: string; slug: string; version?: string }) =>
+    api.delete('/cache/artifacts', { params: project }),
+}
+
+export const jobs = {
+  list: (server?: string) => api.get('/jobs', { params: { server } }),
+  get: (id: string) => api.get(`/jobs/${id}`),
 }
 
 export const eggs = {